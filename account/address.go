@@ -0,0 +1,43 @@
+package account
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/mr-tron/base58"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	publicKeySize    = 32
+	ss58ChecksumSize = 2
+)
+
+var ss58Prefix = []byte("SS58PRE")
+
+// Decode the public key from the ss58 address of chain account, only one-byte address types are supported
+func DecodeAddress(address string) ([publicKeySize]byte, error) {
+	var publicKey [publicKeySize]byte
+	addressBytes, err := base58.Decode(address)
+	if err != nil {
+		return publicKey, fmt.Errorf("Wrong address '%s': %s", address, err)
+	}
+
+	if len(addressBytes) != 1+publicKeySize+ss58ChecksumSize || addressBytes[0] >= 64 {
+		return publicKey, fmt.Errorf("Wrong address '%s': unsupported format", address)
+	}
+
+	payloadSize := 1 + publicKeySize
+	checksum := getSS58Checksum(addressBytes[:payloadSize])
+	if !bytes.Equal(checksum, addressBytes[payloadSize:]) {
+		return publicKey, fmt.Errorf("Wrong address '%s': checksum mismatch", address)
+	}
+
+	copy(publicKey[:], addressBytes[1:payloadSize])
+	return publicKey, nil
+}
+
+func getSS58Checksum(payload []byte) []byte {
+	hash := blake2b.Sum512(append(append([]byte{}, ss58Prefix...), payload...))
+	return hash[:ss58ChecksumSize]
+}
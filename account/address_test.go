@@ -0,0 +1,29 @@
+package account
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecodeAddress(t *testing.T) {
+	// The account of Alice in substrate development chain
+	publicKey, err := DecodeAddress("5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKutQY")
+	if err != nil {
+		t.Fatalf("Decode address failed: %s", err)
+	}
+	if hex.EncodeToString(publicKey[:]) != "d43593c715fdd31c61141abd04a99fd6822c8558854ccde39a5684e7a56da27d" {
+		t.Fatalf("Wrong public key '%x'", publicKey)
+	}
+
+	wrongAddresses := []string{
+		"5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKutQZ", // Checksum mismatch
+		"5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKut",   // Too short
+		"5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKut0Y", // Not base58
+		"",
+	}
+	for _, address := range wrongAddresses {
+		if _, err = DecodeAddress(address); err == nil {
+			t.Fatalf("Decode wrong address '%s'", address)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package account
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	schnorrkel "github.com/ChainSafe/go-schnorrkel"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	secretKeySize       = 64
+	keystoreNonceSize   = 24
+	keystoreScryptSize  = 32 + 4 + 4 + 4
+	keystorePasswordLen = 32
+)
+
+var (
+	pkcs8Header  = []byte{48, 83, 2, 1, 1, 48, 5, 6, 3, 43, 101, 112, 4, 34, 4, 32}
+	pkcs8Divider = []byte{161, 35, 3, 33, 0}
+)
+
+// Keystore is the backup of chain account exported by polkadot-js, like the 'crust.backup' in config
+type keystore struct {
+	Address  string `json:"address"`
+	Encoded  string `json:"encoded"`
+	Encoding struct {
+		Content []string        `json:"content"`
+		Type    json.RawMessage `json:"type"`
+		Version string          `json:"version"`
+	} `json:"encoding"`
+}
+
+// Keypair is the sr25519 key of chain account, which is used to sign requests for the account
+type Keypair struct {
+	address   string
+	secretKey *schnorrkel.SecretKey
+	publicKey [publicKeySize]byte
+}
+
+// Decrypt the backup of chain account with the password, only sr25519 account is supported
+func LoadKeypair(backup string, password string) (*Keypair, error) {
+	var ks keystore
+	if err := json.Unmarshal([]byte(backup), &ks); err != nil {
+		return nil, fmt.Errorf("Wrong backup: %s", err)
+	}
+
+	if len(ks.Encoding.Content) < 2 || ks.Encoding.Content[0] != "pkcs8" || ks.Encoding.Content[1] != "sr25519" {
+		return nil, fmt.Errorf("Only pkcs8 sr25519 backup is supported, the content of backup is %v", ks.Encoding.Content)
+	}
+
+	encryptTypes, err := getKeystoreTypes(ks.Encoding.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := hex.DecodeString(strings.TrimPrefix(ks.Encoded, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("Wrong encoded of backup: %s", err)
+	}
+
+	// Password is used as the key directly in version 2, and derived by scrypt since version 3
+	var passwordKey [keystorePasswordLen]byte
+	copy(passwordKey[:], password)
+	if containsString(encryptTypes, "scrypt") {
+		if len(encoded) < keystoreScryptSize {
+			return nil, fmt.Errorf("Wrong encoded of backup: too short")
+		}
+		salt := encoded[0:32]
+		n := int(binary.LittleEndian.Uint32(encoded[32:36]))
+		p := int(binary.LittleEndian.Uint32(encoded[36:40]))
+		r := int(binary.LittleEndian.Uint32(encoded[40:44]))
+		derivedKey, err := scrypt.Key([]byte(password), salt, n, r, p, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Derive key of backup failed: %s", err)
+		}
+		copy(passwordKey[:], derivedKey)
+		encoded = encoded[keystoreScryptSize:]
+	}
+
+	if !containsString(encryptTypes, "xsalsa20-poly1305") {
+		return nil, fmt.Errorf("Unsupported encryption of backup: %v", encryptTypes)
+	}
+
+	if len(encoded) < keystoreNonceSize {
+		return nil, fmt.Errorf("Wrong encoded of backup: too short")
+	}
+	var nonce [keystoreNonceSize]byte
+	copy(nonce[:], encoded[:keystoreNonceSize])
+	decrypted, ok := secretbox.Open(nil, encoded[keystoreNonceSize:], &nonce, &passwordKey)
+	if !ok {
+		return nil, fmt.Errorf("Decrypt backup failed, please check the password")
+	}
+
+	return newKeypairFromPkcs8(ks.Address, decrypted)
+}
+
+// Pkcs8 of polkadot-js is header + secret key (64) + divider + public key (32)
+func newKeypairFromPkcs8(address string, decoded []byte) (*Keypair, error) {
+	divOffset := len(pkcs8Header) + secretKeySize
+	if len(decoded) != divOffset+len(pkcs8Divider)+publicKeySize ||
+		!bytes.Equal(decoded[:len(pkcs8Header)], pkcs8Header) ||
+		!bytes.Equal(decoded[divOffset:divOffset+len(pkcs8Divider)], pkcs8Divider) {
+		return nil, fmt.Errorf("Wrong pkcs8 of backup")
+	}
+
+	// The key of secret is multiplied by cofactor like ed25519 in polkadot-js
+	var key, nonce [32]byte
+	secret := decoded[len(pkcs8Header):divOffset]
+	copy(key[:], divideScalarByCofactor(secret[:32]))
+	copy(nonce[:], secret[32:])
+
+	keypair := &Keypair{
+		address:   address,
+		secretKey: schnorrkel.NewSecretKey(key, nonce),
+	}
+	copy(keypair.publicKey[:], decoded[divOffset+len(pkcs8Divider):])
+
+	publicKey, err := keypair.secretKey.Public()
+	if err != nil {
+		return nil, fmt.Errorf("Wrong secret key of backup: %s", err)
+	}
+	if publicKey.Encode() != keypair.publicKey {
+		return nil, fmt.Errorf("The secret key of backup doesn't match its public key")
+	}
+
+	addressKey, err := DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if addressKey != keypair.publicKey {
+		return nil, fmt.Errorf("The address '%s' doesn't match the public key of backup", address)
+	}
+
+	return keypair, nil
+}
+
+func (keypair *Keypair) Address() string {
+	return keypair.address
+}
+
+// The type of encoding is a string in version 2 and an array in version 3
+func getKeystoreTypes(rawType json.RawMessage) ([]string, error) {
+	var types []string
+	if err := json.Unmarshal(rawType, &types); err == nil {
+		return types, nil
+	}
+
+	var oneType string
+	if err := json.Unmarshal(rawType, &oneType); err != nil {
+		return nil, fmt.Errorf("Wrong encoding type of backup: %s", err)
+	}
+	return []string{oneType}, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func divideScalarByCofactor(scalar []byte) []byte {
+	result := append([]byte{}, scalar...)
+	var low byte = 0
+	for i := len(result) - 1; i >= 0; i-- {
+		r := result[i] & 7
+		result[i] >>= 3
+		result[i] += low
+		low = r << 5
+	}
+	return result
+}
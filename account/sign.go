@@ -0,0 +1,60 @@
+package account
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	schnorrkel "github.com/ChainSafe/go-schnorrkel"
+)
+
+const signatureSize = 64
+
+// The same signing context as substrate, so signatures can also be made by polkadot-js
+var signingContext = []byte("substrate")
+
+// Sign the message by sr25519 and return the hex signature with '0x' prefix
+func (keypair *Keypair) Sign(msg []byte) (string, error) {
+	signature, err := keypair.secretKey.Sign(schnorrkel.NewSigningContext(signingContext, msg))
+	if err != nil {
+		return "", err
+	}
+
+	signatureBytes := signature.Encode()
+	return "0x" + hex.EncodeToString(signatureBytes[:]), nil
+}
+
+// Verify that the hex signature of message is made by the account of address
+func Verify(address string, msg []byte, signatureHex string) error {
+	publicKeyBytes, err := DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+
+	signatureBytes, err := hex.DecodeString(strings.TrimPrefix(signatureHex, "0x"))
+	if err != nil || len(signatureBytes) != signatureSize {
+		return fmt.Errorf("Wrong signature format")
+	}
+
+	var signatureArray [signatureSize]byte
+	copy(signatureArray[:], signatureBytes)
+	// Signatures of schnorrkel are marked by the highest bit
+	if signatureArray[signatureSize-1]&128 == 0 {
+		return fmt.Errorf("Wrong signature format")
+	}
+
+	signature := &schnorrkel.Signature{}
+	if err = signature.Decode(signatureArray); err != nil {
+		return fmt.Errorf("Wrong signature format: %s", err)
+	}
+
+	publicKey := &schnorrkel.PublicKey{}
+	if err = publicKey.Decode(publicKeyBytes); err != nil {
+		return fmt.Errorf("Wrong public key of '%s': %s", address, err)
+	}
+
+	if !publicKey.Verify(signature, schnorrkel.NewSigningContext(signingContext, msg)) {
+		return fmt.Errorf("The signature isn't made by '%s'", address)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package account
+
+import (
+	"testing"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	keypair, err := LoadKeypair(testBackup, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("karst")
+	signature, err := keypair.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Verify(testAddress, msg, signature); err != nil {
+		t.Fatalf("Verify signature failed: %s", err)
+	}
+
+	if err = Verify(testAddress, []byte("karsT"), signature); err == nil {
+		t.Fatal("Verify signature of tampered message")
+	}
+	if err = Verify("5GrwvaEF5zXb26Fz9rcQpDWS57CtERHpNehXCPcNoHGKutQY", msg, signature); err == nil {
+		t.Fatal("Verify signature with the address of other account")
+	}
+
+	tampered := []byte(signature)
+	if tampered[2] == '0' {
+		tampered[2] = '1'
+	} else {
+		tampered[2] = '0'
+	}
+	if err = Verify(testAddress, msg, string(tampered)); err == nil {
+		t.Fatal("Verify tampered signature")
+	}
+	if err = Verify(testAddress, msg, signature[:len(signature)-2]); err == nil {
+		t.Fatal("Verify signature of wrong size")
+	}
+}
@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"karst/metrics"
 	"karst/utils"
 	"sync"
 	"time"
@@ -16,9 +18,11 @@ func SetBasePath(tmpBasePath string) {
 	defer lock.Unlock()
 	basePath = tmpBasePath
 	lockCache = 0
+	metrics.CacheLockedBytes.Set(0)
 }
 
-func WaitLock(size uint64) error {
+// Wait until the space can be locked, the error of ctx is returned if ctx is done while waiting
+func WaitLock(ctx context.Context, size uint64) error {
 	for i := 0; i < 1500; i++ {
 		canLock, err := Lock(size)
 		if err != nil {
@@ -29,7 +33,11 @@ func WaitLock(size uint64) error {
 			return nil
 		}
 
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
 	}
 
 	return fmt.Errorf("Get cache timeout")
@@ -43,8 +51,10 @@ func Lock(size uint64) (bool, error) {
 		return false, fmt.Errorf("Locked space is too large, need: %d, free: %d, lock: %d", size, diskUsage.Free, lockCache)
 	}
 
-	if size < diskUsage.Free {
+	// Space locked by other seal workers may not be written yet
+	if size+lockCache < diskUsage.Free {
 		lockCache = lockCache + size
+		metrics.CacheLockedBytes.Set(float64(lockCache))
 		return true, nil
 	}
 
@@ -60,6 +70,7 @@ func Unlock(size uint64) {
 	} else {
 		lockCache = lockCache - size
 	}
+	metrics.CacheLockedBytes.Set(float64(lockCache))
 }
 
 func CanLock(size uint64) bool {
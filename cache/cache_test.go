@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWaitLockIsCanceled(t *testing.T) {
+	basePath, err := ioutil.TempDir("", "karst-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath)
+	SetBasePath(basePath)
+
+	// Lock half of free space, so that another three quarters have to wait
+	free := GetCacheSize()
+	if ok, err := Lock(free / 2); err != nil || !ok {
+		t.Fatalf("Lock half of free space failed: %v", err)
+	}
+	defer Unlock(free / 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- WaitLock(ctx, free/4*3)
+	}()
+
+	select {
+	case err = <-done:
+		t.Fatalf("Wait lock returns '%v' before space is unlocked", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		if err != context.Canceled {
+			t.Fatalf("Wait lock returns '%v' after cancel, expected context canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait lock doesn't return after cancel")
+	}
+}
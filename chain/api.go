@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"karst/config"
 	"karst/logger"
 
 	"github.com/imroc/req"
@@ -26,20 +25,13 @@ type sOrderRequest struct {
 	Backup string `json:"backup"`
 }
 
-type storageOrder struct {
+type storageOrderInfo struct {
 	Merchant       string `json:"merchant"`
 	FileIdentifier string `json:"fileIdentifier"`
 	FileSize       uint64 `json:"fileSize"`
 	Duration       uint64 `json:"duration"`
 }
 
-type fullStorageOrder struct {
-	Merchant       string `json:"merchant"`
-	Client         string `json:"client"`
-	FileIdentifier string `json:"file_identifier"`
-	FileSize       uint64 `json:"file_size"`
-}
-
 type sOrderResponse struct {
 	OrderId string `json:"order_id"`
 }
@@ -50,21 +42,28 @@ type systemHealth struct {
 	ShouldHavePeers bool   `json:"shouldHavePeers"`
 }
 
-func Register(cfg *config.Configuration, karstAddr string, storagePrice uint64) error {
+// The client of crust api
+type httpClient struct {
+	baseUrl  string
+	backup   string
+	password string
+}
+
+func (client *httpClient) Register(karstAddr string, storagePrice uint64) error {
 	header := req.Header{
-		"password": cfg.Crust.Password,
+		"password": client.password,
 	}
 
 	regReq := registerRequest{
 		AddressInfo:  karstAddr,
 		StoragePrice: storagePrice,
-		Backup:       cfg.Crust.Backup,
+		Backup:       client.backup,
 	}
 
 	body := req.BodyJSON(&regReq)
 	logger.Debug("Register request body: %s", body)
 
-	r, err := req.Post("http://"+cfg.Crust.BaseUrl+"/api/v1/market/register", header, body)
+	r, err := req.Post("http://"+client.baseUrl+"/api/v1/market/register", header, body)
 
 	if err != nil {
 		return err
@@ -79,11 +78,11 @@ func Register(cfg *config.Configuration, karstAddr string, storagePrice uint64)
 	return nil
 }
 
-func GetMerchantAddr(cfg *config.Configuration, pChainAddr string) (string, error) {
+func (client *httpClient) GetMerchantAddr(pChainAddr string) (string, error) {
 	param := req.Param{
 		"address": pChainAddr,
 	}
-	r, err := req.Get("http://"+cfg.Crust.BaseUrl+"/api/v1/market/merchant", param)
+	r, err := req.Get("http://"+client.baseUrl+"/api/v1/market/merchant", param)
 
 	if err != nil {
 		return "", err
@@ -102,11 +101,11 @@ func GetMerchantAddr(cfg *config.Configuration, pChainAddr string) (string, erro
 	return merchant.Address, nil
 }
 
-func GetMerchantFileMap(cfg *config.Configuration, pChainAddr string) (map[string][]string, error) {
+func (client *httpClient) GetMerchantFileMap(pChainAddr string) (map[string][]string, error) {
 	param := req.Param{
 		"address": pChainAddr,
 	}
-	r, err := req.Get("http://"+cfg.Crust.BaseUrl+"/api/v1/market/merchant", param)
+	r, err := req.Get("http://"+client.baseUrl+"/api/v1/market/merchant", param)
 
 	if err != nil {
 		return nil, err
@@ -125,12 +124,12 @@ func GetMerchantFileMap(cfg *config.Configuration, pChainAddr string) (map[strin
 	return merchant.FileMap, nil
 }
 
-func PlaceStorageOrder(cfg *config.Configuration, merchant string, duration uint64, fId string, fSize uint64) (string, error) {
+func (client *httpClient) PlaceStorageOrder(merchant string, duration uint64, fId string, fSize uint64) (string, error) {
 	header := req.Header{
-		"password": cfg.Crust.Password,
+		"password": client.password,
 	}
 
-	sOrder := storageOrder{
+	sOrder := storageOrderInfo{
 		Merchant:       merchant,
 		FileIdentifier: fId,
 		FileSize:       fSize,
@@ -145,12 +144,12 @@ func PlaceStorageOrder(cfg *config.Configuration, merchant string, duration uint
 
 	sOrderReq := sOrderRequest{
 		SOrder: string(sOrderStr),
-		Backup: cfg.Crust.Backup,
+		Backup: client.backup,
 	}
 
 	body := req.BodyJSON(&sOrderReq)
 
-	r, err := req.Post("http://"+cfg.Crust.BaseUrl+"/api/v1/market/sorder", header, body)
+	r, err := req.Post("http://"+client.baseUrl+"/api/v1/market/sorder", header, body)
 	if err != nil {
 		return "", err
 	}
@@ -167,12 +166,12 @@ func PlaceStorageOrder(cfg *config.Configuration, merchant string, duration uint
 	return sOrderRes.OrderId, nil
 }
 
-func GetStorageOrder(cfg *config.Configuration, orderId string) (fullStorageOrder, error) {
+func (client *httpClient) GetStorageOrder(orderId string) (StorageOrder, error) {
 	param := req.Param{
 		"orderId": orderId,
 	}
-	r, err := req.Get("http://"+cfg.Crust.BaseUrl+"/api/v1/market/sorder", param)
-	sOrder := fullStorageOrder{}
+	r, err := req.Get("http://"+client.baseUrl+"/api/v1/market/sorder", param)
+	sOrder := StorageOrder{}
 
 	if err != nil {
 		return sOrder, err
@@ -189,8 +188,8 @@ func GetStorageOrder(cfg *config.Configuration, orderId string) (fullStorageOrde
 	return sOrder, errors.New("Error from crust api")
 }
 
-func IsReady(cfg *config.Configuration) bool {
-	r, err := req.Get("http://" + cfg.Crust.BaseUrl + "/api/v1/system/health")
+func (client *httpClient) IsReady() bool {
+	r, err := req.Get("http://" + client.baseUrl + "/api/v1/system/health")
 	if err != nil {
 		return false
 	}
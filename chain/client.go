@@ -0,0 +1,41 @@
+package chain
+
+import (
+	"karst/config"
+	"time"
+)
+
+const (
+	// Crust produces a block every 6 seconds, the duration of storage orders is counted in blocks
+	BlockInterval = 6 * time.Second
+)
+
+type StorageOrder struct {
+	Merchant       string `json:"merchant"`
+	Client         string `json:"client"`
+	FileIdentifier string `json:"file_identifier"`
+	FileSize       uint64 `json:"file_size"`
+}
+
+// Client is used by karst to read and write crust chain, it is implemented by crust api and fake chain
+type Client interface {
+	// Register karst address of the merchant
+	Register(karstAddr string, storagePrice uint64) error
+	// Get karst address of the merchant
+	GetMerchantAddr(pChainAddr string) (string, error)
+	// Get the map from file identifier to storage orders of the merchant
+	GetMerchantFileMap(pChainAddr string) (map[string][]string, error)
+	// Place storage order and return the order id
+	PlaceStorageOrder(merchant string, duration uint64, fId string, fSize uint64) (string, error)
+	GetStorageOrder(orderId string) (StorageOrder, error)
+	IsReady() bool
+}
+
+// Create the client of crust api with the chain configuration
+func NewClient(cfg *config.Configuration) Client {
+	return &httpClient{
+		baseUrl:  cfg.Crust.BaseUrl,
+		backup:   cfg.Crust.Backup,
+		password: cfg.Crust.Password,
+	}
+}
@@ -0,0 +1,257 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"karst/logger"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+type fakeMerchant struct {
+	karstAddr    string
+	storagePrice uint64
+	fileMap      map[string][]string
+}
+
+// FakeChain keeps merchants, storage orders and file maps in memory, it can be used by karst as chain client
+// directly or be served as crust api, so that the whole flow of karst can run without crust chain
+type FakeChain struct {
+	lock       sync.Mutex
+	merchants  map[string]*fakeMerchant
+	orders     map[string]StorageOrder
+	orderIndex uint64
+	syncing    bool
+}
+
+func NewFakeChain() *FakeChain {
+	return &FakeChain{
+		merchants: make(map[string]*fakeMerchant),
+		orders:    make(map[string]StorageOrder),
+	}
+}
+
+// Set whether the chain is synchronizing, karst waits for the chain during synchronization
+func (fc *FakeChain) SetSyncing(syncing bool) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.syncing = syncing
+}
+
+// Create chain client of the account, all of the orders placed by this client belong to 'address'
+func (fc *FakeChain) NewClient(address string) Client {
+	return &fakeClient{
+		chain:   fc,
+		address: address,
+	}
+}
+
+func (fc *FakeChain) register(address string, karstAddr string, storagePrice uint64) error {
+	if address == "" {
+		return fmt.Errorf("The address of merchant is empty")
+	}
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if m, ok := fc.merchants[address]; ok {
+		m.karstAddr = karstAddr
+		m.storagePrice = storagePrice
+		return nil
+	}
+
+	fc.merchants[address] = &fakeMerchant{
+		karstAddr:    karstAddr,
+		storagePrice: storagePrice,
+		fileMap:      make(map[string][]string),
+	}
+	return nil
+}
+
+func (fc *FakeChain) getMerchant(address string) (merchant, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	m, ok := fc.merchants[address]
+	if !ok {
+		return merchant{}, fmt.Errorf("Merchant '%s' doesn't exist", address)
+	}
+
+	fileMap := make(map[string][]string, len(m.fileMap))
+	for fId, orderIds := range m.fileMap {
+		fileMap[fId] = append([]string{}, orderIds...)
+	}
+
+	return merchant{
+		Address: m.karstAddr,
+		FileMap: fileMap,
+	}, nil
+}
+
+func (fc *FakeChain) placeStorageOrder(client string, mAddress string, duration uint64, fId string, fSize uint64) (string, error) {
+	if client == "" {
+		return "", fmt.Errorf("The address of client is empty")
+	}
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	m, ok := fc.merchants[mAddress]
+	if !ok {
+		return "", fmt.Errorf("Merchant '%s' doesn't exist", mAddress)
+	}
+
+	// Order id is like the hash on chain
+	fc.orderIndex++
+	orderIdBytes := sha256.Sum256([]byte(fmt.Sprintf("%s-%s-%s-%d-%d", client, mAddress, fId, duration, fc.orderIndex)))
+	orderId := "0x" + hex.EncodeToString(orderIdBytes[:])
+
+	fc.orders[orderId] = StorageOrder{
+		Merchant:       mAddress,
+		Client:         client,
+		FileIdentifier: fId,
+		FileSize:       fSize,
+	}
+	m.fileMap[fId] = append(m.fileMap[fId], orderId)
+	return orderId, nil
+}
+
+func (fc *FakeChain) getStorageOrder(orderId string) (StorageOrder, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	sOrder, ok := fc.orders[orderId]
+	if !ok {
+		return StorageOrder{}, fmt.Errorf("Storage order '%s' doesn't exist", orderId)
+	}
+	return sOrder, nil
+}
+
+func (fc *FakeChain) isReady() bool {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return !fc.syncing
+}
+
+// Serve the same apis as crust api, the account is read from 'address' of backup and password isn't checked
+func (fc *FakeChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("(FakeChain) %s %s", r.Method, r.URL.Path)
+
+	switch {
+	case r.URL.Path == "/api/v1/market/register" && r.Method == http.MethodPost:
+		var regReq registerRequest
+		if err := json.NewDecoder(r.Body).Decode(&regReq); err != nil {
+			writeFakeChainError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := fc.register(getBackupAddress(regReq.Backup), regReq.AddressInfo, regReq.StoragePrice); err != nil {
+			writeFakeChainError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeFakeChainJSON(w, map[string]string{"status": "success"})
+	case r.URL.Path == "/api/v1/market/merchant" && r.Method == http.MethodGet:
+		m, err := fc.getMerchant(r.URL.Query().Get("address"))
+		if err != nil {
+			writeFakeChainError(w, http.StatusNotFound, err)
+			return
+		}
+		writeFakeChainJSON(w, m)
+	case r.URL.Path == "/api/v1/market/sorder" && r.Method == http.MethodPost:
+		var sOrderReq sOrderRequest
+		if err := json.NewDecoder(r.Body).Decode(&sOrderReq); err != nil {
+			writeFakeChainError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var sOrder storageOrderInfo
+		if err := json.Unmarshal([]byte(sOrderReq.SOrder), &sOrder); err != nil {
+			writeFakeChainError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		orderId, err := fc.placeStorageOrder(getBackupAddress(sOrderReq.Backup), sOrder.Merchant, sOrder.Duration, sOrder.FileIdentifier, sOrder.FileSize)
+		if err != nil {
+			writeFakeChainError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeFakeChainJSON(w, sOrderResponse{OrderId: orderId})
+	case r.URL.Path == "/api/v1/market/sorder" && r.Method == http.MethodGet:
+		sOrder, err := fc.getStorageOrder(r.URL.Query().Get("orderId"))
+		if err != nil {
+			writeFakeChainError(w, http.StatusNotFound, err)
+			return
+		}
+		writeFakeChainJSON(w, sOrder)
+	case r.URL.Path == "/api/v1/system/health" && r.Method == http.MethodGet:
+		writeFakeChainJSON(w, systemHealth{
+			Peers:           1,
+			IsSyncing:       !fc.isReady(),
+			ShouldHavePeers: false,
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// The backup is the json of account, only its address is needed by fake chain
+func getBackupAddress(backup string) string {
+	var account struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal([]byte(backup), &account); err != nil {
+		return ""
+	}
+	return account.Address
+}
+
+func writeFakeChainJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("(FakeChain) Write err: %s", err)
+	}
+}
+
+func writeFakeChainError(w http.ResponseWriter, code int, err error) {
+	logger.Error("(FakeChain) %s", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"code": strconv.Itoa(code), "message": err.Error()})
+}
+
+// The chain client of one account on fake chain
+type fakeClient struct {
+	chain   *FakeChain
+	address string
+}
+
+func (client *fakeClient) Register(karstAddr string, storagePrice uint64) error {
+	return client.chain.register(client.address, karstAddr, storagePrice)
+}
+
+func (client *fakeClient) GetMerchantAddr(pChainAddr string) (string, error) {
+	m, err := client.chain.getMerchant(pChainAddr)
+	if err != nil {
+		return "", err
+	}
+	return m.Address, nil
+}
+
+func (client *fakeClient) GetMerchantFileMap(pChainAddr string) (map[string][]string, error) {
+	m, err := client.chain.getMerchant(pChainAddr)
+	if err != nil {
+		return nil, err
+	}
+	return m.FileMap, nil
+}
+
+func (client *fakeClient) PlaceStorageOrder(merchant string, duration uint64, fId string, fSize uint64) (string, error) {
+	return client.chain.placeStorageOrder(client.address, merchant, duration, fId, fSize)
+}
+
+func (client *fakeClient) GetStorageOrder(orderId string) (StorageOrder, error) {
+	return client.chain.getStorageOrder(orderId)
+}
+
+func (client *fakeClient) IsReady() bool {
+	return client.chain.isReady()
+}
@@ -0,0 +1,105 @@
+package chain
+
+import (
+	"karst/config"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testMerchant = "merchant"
+	testClient   = "client"
+)
+
+// Check the chain client from merchant registration to storage order lookup
+func testChainClient(t *testing.T, merchantClient Client, clientClient Client, fakeChain *FakeChain) {
+	if _, err := merchantClient.GetMerchantAddr(testMerchant); err == nil {
+		t.Fatal("Get address of merchant succeeded before registration")
+	}
+	if _, err := clientClient.PlaceStorageOrder(testMerchant, 100, "file_hash", 1000); err == nil {
+		t.Fatal("Place storage order succeeded before registration of merchant")
+	}
+
+	if err := merchantClient.Register("127.0.0.1:17000", 10); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+	karstAddr, err := clientClient.GetMerchantAddr(testMerchant)
+	if err != nil {
+		t.Fatalf("Get address of merchant failed: %s", err)
+	}
+	if karstAddr != "127.0.0.1:17000" {
+		t.Fatalf("Address of merchant is '%s', expected '127.0.0.1:17000'", karstAddr)
+	}
+
+	orderId, err := clientClient.PlaceStorageOrder(testMerchant, 100, "file_hash", 1000)
+	if err != nil {
+		t.Fatalf("Place storage order failed: %s", err)
+	}
+	if !strings.HasPrefix(orderId, "0x") {
+		t.Fatalf("Order id '%s' isn't like a hash", orderId)
+	}
+
+	sOrder, err := merchantClient.GetStorageOrder(orderId)
+	if err != nil {
+		t.Fatalf("Get storage order failed: %s", err)
+	}
+	expectedOrder := StorageOrder{
+		Merchant:       testMerchant,
+		Client:         testClient,
+		FileIdentifier: "file_hash",
+		FileSize:       1000,
+	}
+	if sOrder != expectedOrder {
+		t.Fatalf("Storage order is %+v, expected %+v", sOrder, expectedOrder)
+	}
+	if _, err = merchantClient.GetStorageOrder("0x00"); err == nil {
+		t.Fatal("Get storage order succeeded with unknown order id")
+	}
+
+	// Orders of the same file are kept in the file map
+	otherOrderId, err := clientClient.PlaceStorageOrder(testMerchant, 200, "file_hash", 1000)
+	if err != nil {
+		t.Fatalf("Place storage order failed: %s", err)
+	}
+	if otherOrderId == orderId {
+		t.Fatal("Two storage orders have the same order id")
+	}
+	fileMap, err := merchantClient.GetMerchantFileMap(testMerchant)
+	if err != nil {
+		t.Fatalf("Get file map failed: %s", err)
+	}
+	if orderIds := fileMap["file_hash"]; len(fileMap) != 1 || len(orderIds) != 2 || orderIds[0] != orderId || orderIds[1] != otherOrderId {
+		t.Fatalf("File map is %v, expected orders '%s' and '%s' of 'file_hash'", fileMap, orderId, otherOrderId)
+	}
+
+	if !clientClient.IsReady() {
+		t.Fatal("Chain isn't ready")
+	}
+	fakeChain.SetSyncing(true)
+	if clientClient.IsReady() {
+		t.Fatal("Chain is ready while synchronizing")
+	}
+	fakeChain.SetSyncing(false)
+}
+
+func TestFakeChainClient(t *testing.T) {
+	fakeChain := NewFakeChain()
+	testChainClient(t, fakeChain.NewClient(testMerchant), fakeChain.NewClient(testClient), fakeChain)
+}
+
+func TestCrustApiAgainstFakeChain(t *testing.T) {
+	fakeChain := NewFakeChain()
+	server := httptest.NewServer(fakeChain)
+	defer server.Close()
+
+	newClient := func(address string) Client {
+		cfg := &config.Configuration{}
+		cfg.Crust.BaseUrl = strings.TrimPrefix(server.URL, "http://")
+		cfg.Crust.Backup = `{"address":"` + address + `"}`
+		cfg.Crust.Password = "123456"
+		return NewClient(cfg)
+	}
+
+	testChainClient(t, newClient(testMerchant), newClient(testClient), fakeChain)
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiTokenSize         = 32
+	apiAuthMaxFailures   = 5
+	apiAuthFailureWindow = time.Minute
+	apiAuthBlockDuration = 5 * time.Minute
+)
+
+// Cmd apis are authenticated by the token in api token file, so chain secrets never leave the config file
+type apiAuth struct {
+	tokenHash [sha256.Size]byte
+	lock      sync.Mutex
+	failures  map[string]*apiAuthFailure
+	now       func() time.Time
+}
+
+type apiAuthFailure struct {
+	count        int
+	firstTime    time.Time
+	blockedUntil time.Time
+}
+
+func newApiAuth(token string) *apiAuth {
+	return &apiAuth{
+		tokenHash: sha256.Sum256([]byte(token)),
+		failures:  make(map[string]*apiAuthFailure),
+		now:       time.Now,
+	}
+}
+
+// Check if the host is blocked by too many failed attempts
+func (auth *apiAuth) IsBlocked(host string) bool {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	failure, ok := auth.failures[host]
+	return ok && auth.now().Before(failure.blockedUntil)
+}
+
+// Compare the token in constant time, the failure is recorded for the host and the host is blocked for a while
+// after too many failures in the window. Loopback hosts are never blocked, all local commands share the same host,
+// so one wrong token file would lock out the others, and the random token can't be guessed anyway
+func (auth *apiAuth) Check(host string, token string) bool {
+	// Hashes have the same length, so the length of token isn't leaked either
+	tokenHash := sha256.Sum256([]byte(token))
+	ok := subtle.ConstantTimeCompare(tokenHash[:], auth.tokenHash[:]) == 1
+
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	if ok {
+		delete(auth.failures, host)
+		return true
+	}
+
+	if isLoopbackHost(host) {
+		return false
+	}
+
+	now := auth.now()
+	auth.pruneFailures(now)
+	failure, exist := auth.failures[host]
+	if !exist || now.Sub(failure.firstTime) > apiAuthFailureWindow {
+		failure = &apiAuthFailure{firstTime: now}
+		auth.failures[host] = failure
+	}
+
+	failure.count++
+	if failure.count >= apiAuthMaxFailures {
+		failure.blockedUntil = now.Add(apiAuthBlockDuration)
+	}
+	return false
+}
+
+// Remove the failures which are neither in the window nor blocking, so the map doesn't grow forever
+func (auth *apiAuth) pruneFailures(now time.Time) {
+	for host, failure := range auth.failures {
+		if now.Sub(failure.firstTime) > apiAuthFailureWindow && now.After(failure.blockedUntil) {
+			delete(auth.failures, host)
+		}
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Load the api token, a new one is created if the token file doesn't exist
+func loadOrCreateApiToken(tokenPath string) (string, error) {
+	token, err := loadApiToken(tokenPath)
+	if err == nil {
+		return token, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	return createApiToken(tokenPath)
+}
+
+func loadApiToken(tokenPath string) (string, error) {
+	tokenBytes, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(tokenBytes))
+	if token == "" {
+		return "", fmt.Errorf("The api token in '%s' is empty", tokenPath)
+	}
+	return token, nil
+}
+
+// Only the owner can read the token file, and the existing one is never overwritten
+func createApiToken(tokenPath string) (string, error) {
+	tokenBytes := make([]byte, apiTokenSize)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	tokenFile, err := os.OpenFile(tokenPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = tokenFile.WriteString(token); err != nil {
+		tokenFile.Close()
+		os.Remove(tokenPath)
+		return "", err
+	}
+
+	if err = tokenFile.Close(); err != nil {
+		os.Remove(tokenPath)
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApiAuthBlock(t *testing.T) {
+	auth := newApiAuth("token")
+	now := time.Now()
+	auth.now = func() time.Time {
+		return now
+	}
+
+	host := "10.0.0.1"
+	for i := 0; i < apiAuthMaxFailures-1; i++ {
+		if auth.Check(host, "wrong") {
+			t.Fatal("Wrong token is accepted")
+		}
+	}
+	if auth.IsBlocked(host) {
+		t.Fatalf("The host is blocked after %d failures", apiAuthMaxFailures-1)
+	}
+
+	// Failures out of the window are not counted
+	now = now.Add(apiAuthFailureWindow + time.Second)
+	auth.Check(host, "wrong")
+	if auth.IsBlocked(host) {
+		t.Fatal("The host is blocked by the failures out of window")
+	}
+
+	for i := 0; i < apiAuthMaxFailures-1; i++ {
+		auth.Check(host, "wrong")
+	}
+	if !auth.IsBlocked(host) {
+		t.Fatalf("The host isn't blocked after %d failures", apiAuthMaxFailures)
+	}
+	if auth.IsBlocked("10.0.0.2") {
+		t.Fatal("Other host is blocked")
+	}
+
+	now = now.Add(apiAuthBlockDuration - time.Second)
+	if !auth.IsBlocked(host) {
+		t.Fatal("The host is unblocked before the block duration")
+	}
+
+	now = now.Add(2 * time.Second)
+	if auth.IsBlocked(host) {
+		t.Fatal("The host is still blocked after the block duration")
+	}
+	if !auth.Check(host, "token") {
+		t.Fatal("Right token is rejected after unblocked")
+	}
+}
+
+func TestApiAuthDoesNotBlockLoopback(t *testing.T) {
+	auth := newApiAuth("token")
+	for _, host := range []string{"127.0.0.1", "::1", "localhost"} {
+		for i := 0; i < apiAuthMaxFailures*2; i++ {
+			auth.Check(host, "wrong")
+		}
+		if auth.IsBlocked(host) {
+			t.Fatalf("The loopback host '%s' is blocked", host)
+		}
+		if !auth.Check(host, "token") {
+			t.Fatalf("Right token from '%s' is rejected", host)
+		}
+	}
+}
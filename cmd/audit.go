@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"karst/chain"
+	"karst/config"
+	"karst/logger"
+	"karst/model"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+type auditPartResult struct {
+	Index  uint64 `json:"index"`
+	Hash   string `json:"hash"`
+	Passed bool   `json:"passed"`
+	Info   string `json:"info"`
+}
+
+type auditReturnMessage struct {
+	Info        string            `json:"info"`
+	Passed      bool              `json:"passed"`
+	PassedNum   uint64            `json:"passed_num"`
+	FailedNum   uint64            `json:"failed_num"`
+	PartResults []auditPartResult `json:"part_results"`
+	Status      int               `json:"status"`
+}
+
+func init() {
+	auditWsCmd.Cmd.Flags().Uint64P("parts", "n", 10, "the number of random parts to be challenged")
+	auditWsCmd.ConnectCmdAndWs()
+	rootCmd.AddCommand(auditWsCmd.Cmd)
+}
+
+var auditWsCmd = &wsCmd{
+	Cmd: &cobra.Command{
+		Use:   "audit [file_hash] [merchant]",
+		Short: "Challenge merchant for random parts of file to check if the file is still stored",
+		Long:  "Challenge merchant for random parts of file to check if the file is still stored, the 'file_hash' is the hash of original file and the 'merchant' is chain address",
+		Args:  cobra.MinimumNArgs(2),
+	},
+	Connecter: func(cmd *cobra.Command, args []string) (map[string]string, error) {
+		partsNum, err := cmd.Flags().GetUint64("parts")
+		if err != nil {
+			return nil, err
+		}
+
+		reqBody := map[string]string{
+			"file_hash": args[0],
+			"merchant":  args[1],
+			"parts_num": strconv.FormatUint(partsNum, 10),
+		}
+		return reqBody, nil
+	},
+	WsEndpoint: "audit",
+	WsRunner: func(args map[string]string, wsc *wsCmd) interface{} {
+		// Base class
+		timeStart := time.Now()
+		logger.Debug("Audit input is %s", args)
+
+		// Check input
+		fileHash := args["file_hash"]
+		if fileHash == "" {
+			errString := "The field 'file_hash' is needed"
+			logger.Error(errString)
+			return auditReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		merchant := args["merchant"]
+		if merchant == "" {
+			errString := "The field 'merchant' is needed"
+			logger.Error(errString)
+			return auditReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		partsNum, err := strconv.ParseUint(args["parts_num"], 10, 64)
+		if err != nil || partsNum == 0 {
+			errString := fmt.Sprintf("The field 'parts_num' must be a positive integer, not '%s'", args["parts_num"])
+			logger.Error(errString)
+			return auditReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		// Challenge merchant
+		auditReturnMsg := auditMerchant(fileHash, merchant, partsNum, wsc.Db, wsc.Cfg, wsc.Chain)
+		if auditReturnMsg.Status != 200 {
+			logger.Error("Audit '%s' of merchant '%s' failed, error is: %s", fileHash, merchant, auditReturnMsg.Info)
+			return auditReturnMsg
+		}
+
+		if auditReturnMsg.Passed {
+			auditReturnMsg.Info = fmt.Sprintf("Audit '%s' of merchant '%s' passed in %s ! %d parts have been checked.", fileHash, merchant, time.Since(timeStart), auditReturnMsg.PassedNum)
+			logger.Info(auditReturnMsg.Info)
+		} else {
+			auditReturnMsg.Info = fmt.Sprintf("Audit '%s' of merchant '%s' failed in %s ! %d of %d parts are wrong.", fileHash, merchant, time.Since(timeStart), auditReturnMsg.FailedNum, auditReturnMsg.PassedNum+auditReturnMsg.FailedNum)
+			logger.Error(auditReturnMsg.Info)
+		}
+		return auditReturnMsg
+	},
+}
+
+// Each challenged part is proved to be the leaf of the sealed root at its index by the audit record saved when the
+// file was finished, the merchant sends the proof with the part
+func auditMerchant(fileHash string, merchant string, partsNum uint64, db *leveldb.DB, cfg *config.Configuration, chainClient chain.Client) auditReturnMessage {
+	// The merchant must have storage orders of the file
+	fileMap, err := chainClient.GetMerchantFileMap(merchant)
+	if err != nil {
+		return auditReturnMessage{
+			Info:   fmt.Sprintf("Can't read file map of '%s', error: %s", merchant, err),
+			Status: 400,
+		}
+	}
+	if len(fileMap["0x"+fileHash]) == 0 {
+		return auditReturnMessage{
+			Info:   fmt.Sprintf("Merchant '%s' has no storage order of '%s'", merchant, fileHash),
+			Status: 404,
+		}
+	}
+
+	auditRecord, err := model.GetAuditRecordFromDb(fileHash, merchant, db)
+	if err != nil {
+		return auditReturnMessage{
+			Info:   fmt.Sprintf("Only the file finished by this client can be audited, error: %s", err),
+			Status: 404,
+		}
+	}
+
+	// Get merchant node data address
+	karstBaseAddr, err := chainClient.GetMerchantAddr(merchant)
+	if err != nil {
+		return auditReturnMessage{
+			Info:   fmt.Sprintf("Can't read karst address of '%s', error: %s", merchant, err),
+			Status: 400,
+		}
+	}
+
+	karstNodeDataAddr := karstBaseAddr + "/api/v0/node/data"
+	logger.Debug("Get node data address '%s' of '%s' success.", karstNodeDataAddr, merchant)
+
+	// Request merchant to audit file
+	logger.Info("Connecting to %s to audit file", karstNodeDataAddr)
+	c, err := dialMerchant(karstNodeDataAddr, cfg)
+	if err != nil {
+		return auditReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+	defer c.Close()
+
+	nodeAuditMsg := model.NodeAuditMessage{
+		Client:   cfg.Crust.Address,
+		FileHash: fileHash,
+	}
+
+	if err = signMerchantRequest(model.NodeAuditSignPath, &nodeAuditMsg, cfg); err != nil {
+		return auditReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	nodeAuditMsgBytes, err := json.Marshal(nodeAuditMsg)
+	if err != nil {
+		return auditReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	logger.Debug("Node audit message is: %s", string(nodeAuditMsgBytes))
+	if err = c.WriteMessage(websocket.TextMessage, nodeAuditMsgBytes); err != nil {
+		return auditReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		return auditReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	nodeAuditReturnMsg := model.NodeAuditReturnMessage{}
+	if err = json.Unmarshal(message, &nodeAuditReturnMsg); err != nil {
+		return auditReturnMessage{
+			Info:   fmt.Sprintf("Unmarshal json: %s", err),
+			Status: 500,
+		}
+	}
+
+	if nodeAuditReturnMsg.Status != 200 {
+		return auditReturnMessage{
+			Info:   nodeAuditReturnMsg.Info,
+			Status: nodeAuditReturnMsg.Status,
+		}
+	}
+
+	// The sealed tree may be changed, then the parts can't be proved by the recorded sealed root
+	if nodeAuditReturnMsg.SealedHash != auditRecord.SealedHash {
+		return auditReturnMessage{
+			Info:   fmt.Sprintf("The sealed root '%s' from merchant doesn't match the recorded sealed root '%s'", nodeAuditReturnMsg.SealedHash, auditRecord.SealedHash),
+			Status: 500,
+		}
+	}
+
+	if auditRecord.LeavesNum < partsNum {
+		partsNum = auditRecord.LeavesNum
+	}
+
+	// Challenge random parts
+	auditReturnMsg := auditReturnMessage{
+		Status:      200,
+		PartResults: make([]auditPartResult, 0),
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, index := range random.Perm(int(auditRecord.LeavesNum))[:partsNum] {
+		partResult := auditPartResult{
+			Index: uint64(index),
+		}
+
+		nodeDataMsg := model.NodeDataMessage{
+			FileHash:  auditRecord.SealedHash,
+			NodeIndex: uint64(index),
+			WithProof: true,
+		}
+		nodeDataMsgBytes, _ := json.Marshal(nodeDataMsg)
+		if err = c.WriteMessage(websocket.TextMessage, nodeDataMsgBytes); err != nil {
+			return auditReturnMessage{
+				Info:   err.Error(),
+				Status: 500,
+			}
+		}
+
+		// The proof of part is sent before the part
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			return auditReturnMessage{
+				Info:   err.Error(),
+				Status: 500,
+			}
+		}
+
+		nodeProofMsg := model.NodeProofMessage{}
+		if err = json.Unmarshal(message, &nodeProofMsg); err != nil || nodeProofMsg.Status != 200 {
+			partResult.Info = fmt.Sprintf("Merchant returns: %s", message)
+		} else {
+			mt, partBytes, err := c.ReadMessage()
+			if err != nil {
+				return auditReturnMessage{
+					Info:   err.Error(),
+					Status: 500,
+				}
+			}
+
+			if mt != websocket.BinaryMessage {
+				partResult.Info = fmt.Sprintf("Merchant returns: %s", partBytes)
+			} else {
+				partHash := sha256.Sum256(partBytes)
+				partResult.Hash = hex.EncodeToString(partHash[:])
+				if err = nodeProofMsg.Proof.Verify(auditRecord.SealedHash, auditRecord.SealedSize, auditRecord.LeavesNum, auditRecord.MaxLinksNum, partResult.Hash, uint64(len(partBytes)), uint64(index)); err != nil {
+					partResult.Info = fmt.Sprintf("Wrong part: %s", err)
+				} else {
+					partResult.Passed = true
+				}
+			}
+		}
+
+		if partResult.Passed {
+			auditReturnMsg.PassedNum++
+		} else {
+			auditReturnMsg.FailedNum++
+			logger.Debug("Part %d of '%s' failed: %s", index, fileHash, partResult.Info)
+		}
+		auditReturnMsg.PartResults = append(auditReturnMsg.PartResults, partResult)
+	}
+
+	auditReturnMsg.Passed = auditReturnMsg.FailedNum == 0
+	return auditReturnMsg
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"karst/chain"
+	"karst/config"
+	"karst/logger"
+	"karst/model"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+)
+
+type cancelReturnMessage struct {
+	Info   string `json:"info"`
+	Status int    `json:"status"`
+}
+
+func init() {
+	cancelWsCmd.ConnectCmdAndWs()
+	rootCmd.AddCommand(cancelWsCmd.Cmd)
+}
+
+var cancelWsCmd = &wsCmd{
+	Cmd: &cobra.Command{
+		Use:   "cancel [store_order_hash] [merchant]",
+		Short: "Cancel the seal job in merchant",
+		Long:  "Cancel the seal job in merchant, a queued job will be removed at once and a running job will stop at the next stage, the uploaded file will be deleted by merchant",
+		Args:  cobra.MinimumNArgs(2),
+	},
+	Connecter: func(cmd *cobra.Command, args []string) (map[string]string, error) {
+		reqBody := map[string]string{
+			"store_order_hash": args[0],
+			"merchant":         args[1],
+		}
+		return reqBody, nil
+	},
+	WsEndpoint: "cancel",
+	WsRunner: func(args map[string]string, wsc *wsCmd) interface{} {
+		// Base class
+		timeStart := time.Now()
+		logger.Debug("Cancel input is %s", args)
+
+		// Check input
+		storeOrderHash := args["store_order_hash"]
+		if storeOrderHash == "" {
+			errString := "The field 'store_order_hash' is needed"
+			logger.Error(errString)
+			return cancelReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		merchant := args["merchant"]
+		if merchant == "" {
+			errString := "The field 'merchant' is needed"
+			logger.Error(errString)
+			return cancelReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		// Request merchant to cancel the seal job
+		cancelReturnMsg := requestMerchantSealCancel(storeOrderHash, merchant, wsc.Cfg, wsc.Chain)
+		if cancelReturnMsg.Status != 200 {
+			logger.Error("Request merchant '%s' to cancel '%s' failed, error is: %s", merchant, storeOrderHash, cancelReturnMsg.Info)
+			return cancelReturnMsg
+		}
+
+		cancelReturnMsg.Info = fmt.Sprintf("Request merchant '%s' to cancel '%s' successfully in %s !", merchant, storeOrderHash, time.Since(timeStart))
+		logger.Info(cancelReturnMsg.Info)
+		return cancelReturnMsg
+	},
+}
+
+func requestMerchantSealCancel(storeOrderHash string, merchant string, cfg *config.Configuration, chainClient chain.Client) cancelReturnMessage {
+	// Get merchant cancel address
+	karstBaseAddr, err := chainClient.GetMerchantAddr(merchant)
+	if err != nil {
+		return cancelReturnMessage{
+			Info:   fmt.Sprintf("Can't read karst address of '%s', error: %s", merchant, err),
+			Status: 400,
+		}
+	}
+
+	karstFileSealCancelAddr := karstBaseAddr + "/api/v0/file/seal/cancel"
+	logger.Debug("Get file seal cancel address '%s' of '%s' success.", karstFileSealCancelAddr, merchant)
+
+	// Request merchant to cancel seal job
+	logger.Info("Connecting to %s to cancel seal job", karstFileSealCancelAddr)
+	c, err := dialMerchant(karstFileSealCancelAddr, cfg)
+	if err != nil {
+		return cancelReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+	defer c.Close()
+
+	fileSealCancelMsg := model.FileSealCancelMessage{
+		Client:         cfg.Crust.Address,
+		StoreOrderHash: storeOrderHash,
+	}
+
+	if err = signMerchantRequest(model.FileSealCancelSignPath, &fileSealCancelMsg, cfg); err != nil {
+		return cancelReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	fileSealCancelMsgBytes, err := json.Marshal(fileSealCancelMsg)
+	if err != nil {
+		return cancelReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	logger.Debug("File seal cancel message is: %s", string(fileSealCancelMsgBytes))
+
+	if err = c.WriteMessage(websocket.TextMessage, fileSealCancelMsgBytes); err != nil {
+		return cancelReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		return cancelReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+	logger.Debug("File seal cancel return: %s", message)
+
+	fileSealCancelReturnMsg := model.FileSealCancelReturnMessage{}
+	if err = json.Unmarshal(message, &fileSealCancelReturnMsg); err != nil {
+		return cancelReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	return cancelReturnMessage{
+		Info:   fileSealCancelReturnMsg.Info,
+		Status: fileSealCancelReturnMsg.Status,
+	}
+}
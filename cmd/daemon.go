@@ -7,8 +7,11 @@ import (
 	"karst/filesystem"
 	"karst/logger"
 	"karst/loop"
+	"karst/sworker"
 	"karst/ws"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -29,8 +32,9 @@ var daemonCmd = &cobra.Command{
 		cfg.Show()
 
 		// Waiting for chain
+		chainClient := chain.NewClient(cfg)
 		for {
-			if chain.IsReady(cfg) {
+			if chainClient.IsReady() {
 				break
 			}
 			logger.Debug("Wait for the chain to start or synchronize to the latest block")
@@ -45,6 +49,14 @@ var daemonCmd = &cobra.Command{
 		}
 		defer db.Close()
 
+		// Api token of cmd apis
+		apiToken, err := loadOrCreateApiToken(cfg.KarstPaths.ApiTokenPath)
+		if err != nil {
+			logger.Error("Fatal error in loading api token: %s", err)
+			os.Exit(-1)
+		}
+		auth := newApiAuth(apiToken)
+
 		// Set cache
 		cache.SetBasePath(cfg.KarstPaths.InitPath)
 
@@ -54,12 +66,36 @@ var daemonCmd = &cobra.Command{
 			declareWsCmd,
 			obtainWsCmd,
 			finishWsCmd,
+			auditWsCmd,
+			getWsCmd,
+			uploadWsCmd,
+			putWsCmd,
+			statusWsCmd,
+			cancelWsCmd,
 		}
 
 		var merchantWsCommands = []*wsCmd{
 			registerWsCmd,
 			listWsCmd,
-			deleteWsCmd}
+			deleteWsCmd,
+			jobsWsCmd}
+
+		// Stop server when receiving signal, karst exits at once if another signal comes while stopping
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-sigs
+			logger.Info("Receive signal '%s', karst will stop", sig)
+			go func() {
+				if err := ws.StopServer(); err != nil {
+					logger.Error("Fatal error in stopping server: %s", err)
+				}
+			}()
+
+			sig = <-sigs
+			logger.Warn("Receive signal '%s' again, karst exits without waiting", sig)
+			os.Exit(1)
+		}()
 
 		// Sever model
 		if cfg.IsServerMode() {
@@ -72,31 +108,33 @@ var daemonCmd = &cobra.Command{
 			defer fs.Close()
 
 			// File seal loop
-			loop.StartFileSealLoop(cfg, db, fs)
+			sworkerClient := sworker.NewClient(cfg)
+			fileSealLoop := loop.StartFileSealLoop(cfg, db, fs, sworkerClient)
+			defer fileSealLoop.Stop()
 
 			// Register merchant cmd apis
 			for _, wsCmd := range merchantWsCommands {
-				wsCmd.Register(db, cfg, fs)
+				wsCmd.Register(db, cfg, fs, chainClient, sworkerClient, auth)
 			}
 
 			// Register base cmd apis
 			for _, wsCmd := range baseWsCommands {
-				wsCmd.Register(db, cfg, fs)
+				wsCmd.Register(db, cfg, fs, chainClient, sworkerClient, auth)
 			}
 
 			logger.Info("--------- Merchant model ------------")
-			if err := ws.StartServer(cfg, fs, db); err != nil {
+			if err := ws.StartServer(cfg, fs, db, chainClient, sworkerClient); err != nil {
 				logger.Error("%s", err)
 			}
 		} else {
 			// Register base cmd apis
 			for _, wsCmd := range baseWsCommands {
-				wsCmd.Register(db, cfg, nil)
+				wsCmd.Register(db, cfg, nil, chainClient, nil, auth)
 			}
 
 			logger.Info("---------- Client model -------------")
 			// Start websocket service
-			if err := ws.StartServer(cfg, nil, db); err != nil {
+			if err := ws.StartServer(cfg, nil, db, chainClient, nil); err != nil {
 				logger.Error("%s", err)
 			}
 		}
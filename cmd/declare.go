@@ -15,6 +15,11 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const (
+	// The storage order must last for more blocks than it
+	declareMinDuration = 300
+)
+
 type declareReturnMsg struct {
 	Info           string `json:"info"`
 	StoreOrderHash string `json:"store_order_hash"`
@@ -89,8 +94,8 @@ var declareWsCmd = &wsCmd{
 			}
 		}
 
-		if duration <= 30 {
-			errString := "The duration must be greater than 300"
+		if duration <= declareMinDuration {
+			errString := fmt.Sprintf("The duration must be greater than %d", declareMinDuration)
 			logger.Error(errString)
 			return declareReturnMsg{
 				Info:   errString,
@@ -99,7 +104,7 @@ var declareWsCmd = &wsCmd{
 		}
 
 		// Declare message
-		declareReturnMsg := declareFile(mt, merchant, duration, wsc.Cfg)
+		declareReturnMsg := declareFile(mt, merchant, duration, wsc.Cfg, wsc.Chain)
 		if declareReturnMsg.Status != 200 {
 			logger.Error(declareReturnMsg.Info)
 		} else {
@@ -111,21 +116,26 @@ var declareWsCmd = &wsCmd{
 	},
 }
 
-func declareFile(mt merkletree.MerkleTreeNode, merchant string, duration uint64, cfg *config.Configuration) declareReturnMsg {
-	// Get merchant seal address
-	karstBaseAddr, err := chain.GetMerchantAddr(cfg, merchant)
-	if err != nil {
+func declareFile(mt merkletree.MerkleTreeNode, merchant string, duration uint64, cfg *config.Configuration, chainClient chain.Client) declareReturnMsg {
+	placeReturnMsg := placeStorageOrder(mt, merchant, duration, chainClient)
+	if placeReturnMsg.Status != 200 {
+		return placeReturnMsg
+	}
+
+	return requestMerchantSeal(mt, merchant, placeReturnMsg.StoreOrderHash, cfg, chainClient)
+}
+
+// Place the storage order of the file on chain, the order is paid, so it must be placed only once for each file
+func placeStorageOrder(mt merkletree.MerkleTreeNode, merchant string, duration uint64, chainClient chain.Client) declareReturnMsg {
+	// The merchant must have registered its karst address
+	if _, err := chainClient.GetMerchantAddr(merchant); err != nil {
 		return declareReturnMsg{
 			Info:   fmt.Sprintf("Can't read karst address of '%s', error: %s", merchant, err),
 			Status: 400,
 		}
 	}
 
-	karstFileSealAddr := karstBaseAddr + "/api/v0/file/seal"
-	logger.Debug("Get file seal address '%s' of '%s' success.", karstFileSealAddr, merchant)
-
-	// Send order
-	storeOrderHash, err := chain.PlaceStorageOrder(cfg, merchant, duration, "0x"+mt.Hash, mt.Size)
+	storeOrderHash, err := chainClient.PlaceStorageOrder(merchant, duration, "0x"+mt.Hash, mt.Size)
 	if err != nil {
 		return declareReturnMsg{
 			Info:   fmt.Sprintf("Create store order failed, err is: %s", err),
@@ -134,14 +144,35 @@ func declareFile(mt merkletree.MerkleTreeNode, merchant string, duration uint64,
 	}
 
 	logger.Debug("Create store order '%s' success.", storeOrderHash)
+	return declareReturnMsg{
+		StoreOrderHash: storeOrderHash,
+		Status:         200,
+	}
+}
+
+// Request merchant to seal file of the placed storage order and give store proof, it can be sent again
+// for the same order if it fails
+func requestMerchantSeal(mt merkletree.MerkleTreeNode, merchant string, storeOrderHash string, cfg *config.Configuration, chainClient chain.Client) declareReturnMsg {
+	// Get merchant seal address
+	karstBaseAddr, err := chainClient.GetMerchantAddr(merchant)
+	if err != nil {
+		return declareReturnMsg{
+			Info:           fmt.Sprintf("Can't read karst address of '%s', error: %s", merchant, err),
+			StoreOrderHash: storeOrderHash,
+			Status:         400,
+		}
+	}
+
+	karstFileSealAddr := karstBaseAddr + "/api/v0/file/seal"
+	logger.Debug("Get file seal address '%s' of '%s' success.", karstFileSealAddr, merchant)
 
-	// Request merchant to seal file and give store proof
 	logger.Info("Connecting to %s to seal file", karstFileSealAddr)
-	c, _, err := websocket.DefaultDialer.Dial(karstFileSealAddr, nil)
+	c, err := dialMerchant(karstFileSealAddr, cfg)
 	if err != nil {
 		return declareReturnMsg{
-			Info:   err.Error(),
-			Status: 500,
+			Info:           err.Error(),
+			StoreOrderHash: storeOrderHash,
+			Status:         500,
 		}
 	}
 	defer c.Close()
@@ -152,27 +183,38 @@ func declareFile(mt merkletree.MerkleTreeNode, merchant string, duration uint64,
 		MerkleTree:     &mt,
 	}
 
+	if err = signMerchantRequest(model.FileSealSignPath, &fileSealMsg, cfg); err != nil {
+		return declareReturnMsg{
+			Info:           err.Error(),
+			StoreOrderHash: storeOrderHash,
+			Status:         500,
+		}
+	}
+
 	fileSealMsgBytes, err := json.Marshal(fileSealMsg)
 	if err != nil {
 		return declareReturnMsg{
-			Info:   err.Error(),
-			Status: 500,
+			Info:           err.Error(),
+			StoreOrderHash: storeOrderHash,
+			Status:         500,
 		}
 	}
 
 	logger.Debug("File seal message is: %s", string(fileSealMsgBytes))
 	if err = c.WriteMessage(websocket.TextMessage, fileSealMsgBytes); err != nil {
 		return declareReturnMsg{
-			Info:   err.Error(),
-			Status: 500,
+			Info:           err.Error(),
+			StoreOrderHash: storeOrderHash,
+			Status:         500,
 		}
 	}
 
 	_, message, err := c.ReadMessage()
 	if err != nil {
 		return declareReturnMsg{
-			Info:   err.Error(),
-			Status: 500,
+			Info:           err.Error(),
+			StoreOrderHash: storeOrderHash,
+			Status:         500,
 		}
 	}
 
@@ -181,8 +223,9 @@ func declareFile(mt merkletree.MerkleTreeNode, merchant string, duration uint64,
 	fileSealReturnMsg := model.FileSealReturnMessage{}
 	if err = json.Unmarshal(message, &fileSealReturnMsg); err != nil {
 		return declareReturnMsg{
-			Info:   fmt.Sprintf("Unmarshal json: %s", err),
-			Status: 500,
+			Info:           fmt.Sprintf("Unmarshal json: %s", err),
+			StoreOrderHash: storeOrderHash,
+			Status:         500,
 		}
 	}
 
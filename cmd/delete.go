@@ -2,10 +2,8 @@ package cmd
 
 import (
 	"fmt"
-	"karst/chain"
 	"karst/logger"
 	"karst/model"
-	"karst/sworker"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -50,7 +48,7 @@ var deleteWsCmd = &wsCmd{
 		fileHash := args["file_hash"]
 		if fileHash == "" {
 			// Get merchant file map
-			fileMap, err := chain.GetMerchantFileMap(wsc.Cfg, wsc.Cfg.Crust.Address)
+			fileMap, err := wsc.Chain.GetMerchantFileMap(wsc.Cfg.Crust.Address)
 			if err != nil {
 				logger.Error(err.Error())
 				return deleteReturnMessage{
@@ -123,7 +121,7 @@ func deleteFile(wsc *wsCmd, fileHash string) (error, int) {
 	}
 
 	// Clear file from db
-	if err = sworker.Delete(wsc.Cfg, fileInfo.MerkleTreeSealed.Hash); err != nil {
+	if err = wsc.Sworker.Delete(fileInfo.MerkleTreeSealed.Hash); err != nil {
 		return err, 500
 	}
 
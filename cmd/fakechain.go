@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"karst/chain"
+	"karst/logger"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(fakeChainCmd)
+}
+
+var fakeChainCmd = &cobra.Command{
+	Use:   "fake-chain [listen_address]",
+	Short: "Start in-memory fake chain for testing",
+	Long:  "Start in-memory fake chain which serves the same apis as crust api, for example: 'karst fake-chain 127.0.0.1:56666', then set 'crust.base_url' of karst to this address. Merchants, storage orders and file maps are lost after stopping",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Info("Fake chain is listening on '%s'", args[0])
+		if err := http.ListenAndServe(args[0], chain.NewFakeChain()); err != nil {
+			logger.Error("%s", err)
+		}
+	},
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"karst/filesystem/fastdfs"
+	"karst/logger"
+	"net"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	fakeFastdfsCmd.Flags().StringP("group", "g", "group1", "the group name of storage")
+	rootCmd.AddCommand(fakeFastdfsCmd)
+}
+
+var fakeFastdfsCmd = &cobra.Command{
+	Use:   "fake-fastdfs [listen_address]",
+	Short: "Start in-memory fastdfs tracker and storage for testing",
+	Long:  "Start in-memory fastdfs tracker and storage on the same address, which speak the commands used by karst, for example: 'karst fake-fastdfs 127.0.0.1:22122 -g group1'. Files are lost after stopping",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		group, _ := cmd.Flags().GetString("group")
+
+		listener, err := net.Listen("tcp", args[0])
+		if err != nil {
+			logger.Error("%s", err)
+			return
+		}
+
+		fakeServer, err := fastdfs.NewFakeServer(listener, group)
+		if err != nil {
+			listener.Close()
+			logger.Error("%s", err)
+			return
+		}
+
+		logger.Info("Fake fastdfs is listening on '%s', group is '%s'", fakeServer.Addr(), group)
+		if err := fakeServer.Serve(); err != nil {
+			logger.Error("%s", err)
+		}
+	},
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"karst/filesystem/s3"
+	"karst/logger"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	fakeS3Cmd.Flags().StringP("bucket", "b", "karst", "the bucket which can be used")
+	fakeS3Cmd.Flags().StringP("access_key", "a", "minioadmin", "the access key of s3")
+	fakeS3Cmd.Flags().StringP("secret_key", "s", "minioadmin", "the secret key of s3")
+	rootCmd.AddCommand(fakeS3Cmd)
+}
+
+var fakeS3Cmd = &cobra.Command{
+	Use:   "fake-s3 [listen_address]",
+	Short: "Start in-memory s3 stand-in for testing",
+	Long:  "Start in-memory s3 stand-in which supports the object and multipart apis used by karst, for example: 'karst fake-s3 127.0.0.1:9000 -b karst'. Objects are lost after stopping",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bucket, _ := cmd.Flags().GetString("bucket")
+		accessKey, _ := cmd.Flags().GetString("access_key")
+		secretKey, _ := cmd.Flags().GetString("secret_key")
+
+		logger.Info("Fake s3 is listening on '%s', bucket is '%s'", args[0], bucket)
+		if err := http.ListenAndServe(args[0], s3.NewFakeServer(accessKey, secretKey, bucket)); err != nil {
+			logger.Error("%s", err)
+		}
+	},
+}
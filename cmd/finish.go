@@ -12,6 +12,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/spf13/cobra"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 type finishReturnMessage struct {
@@ -77,7 +78,7 @@ var finishWsCmd = &wsCmd{
 		}
 
 		// Notify merchant to finish this file
-		finishReturnMsg := notifyMerchantFinish(&mt, merchant, wsc.Cfg)
+		finishReturnMsg := notifyMerchantFinish(&mt, merchant, wsc.Db, wsc.Cfg, wsc.Chain)
 		if finishReturnMsg.Status != 200 {
 			logger.Error("Request merchant '%s' to finish '%s' failed, error is: %s", mt.Hash, merchant, finishReturnMsg.Info)
 			return finishReturnMsg
@@ -88,9 +89,10 @@ var finishWsCmd = &wsCmd{
 	},
 }
 
-func notifyMerchantFinish(mt *merkletree.MerkleTreeNode, merchant string, cfg *config.Configuration) finishReturnMessage {
+// The sealed tree returned by merchant is recorded for audits after the file is finished, nothing is recorded if 'db' is nil
+func notifyMerchantFinish(mt *merkletree.MerkleTreeNode, merchant string, db *leveldb.DB, cfg *config.Configuration, chainClient chain.Client) finishReturnMessage {
 	// Get merchant unseal address
-	karstBaseAddr, err := chain.GetMerchantAddr(cfg, merchant)
+	karstBaseAddr, err := chainClient.GetMerchantAddr(merchant)
 	if err != nil {
 		return finishReturnMessage{
 			Info:   fmt.Sprintf("Can't read karst address of '%s', error: %s", merchant, err),
@@ -103,7 +105,7 @@ func notifyMerchantFinish(mt *merkletree.MerkleTreeNode, merchant string, cfg *c
 
 	// Request merchant to seal file and give store proof
 	logger.Info("Connecting to %s to finish file", karstFileFinishAddr)
-	c, _, err := websocket.DefaultDialer.Dial(karstFileFinishAddr, nil)
+	c, err := dialMerchant(karstFileFinishAddr, cfg)
 	if err != nil {
 		return finishReturnMessage{
 			Info:   err.Error(),
@@ -117,6 +119,13 @@ func notifyMerchantFinish(mt *merkletree.MerkleTreeNode, merchant string, cfg *c
 		MerkleTree: mt,
 	}
 
+	if err = signMerchantRequest(model.FileFinishSignPath, &fileFinishMsg, cfg); err != nil {
+		return finishReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
 	fileFinishMsgBytes, err := json.Marshal(fileFinishMsg)
 	if err != nil {
 		return finishReturnMessage{
@@ -151,6 +160,28 @@ func notifyMerchantFinish(mt *merkletree.MerkleTreeNode, merchant string, cfg *c
 		}
 	}
 
+	if fileFinishReturnMsg.Status == 200 && db != nil {
+		auditRecord := model.AuditRecord{
+			FileHash:    mt.Hash,
+			Merchant:    merchant,
+			SealedHash:  fileFinishReturnMsg.SealedHash,
+			SealedSize:  fileFinishReturnMsg.SealedSize,
+			LeavesNum:   fileFinishReturnMsg.SealedLeavesNum,
+			MaxLinksNum: fileFinishReturnMsg.SealedMaxLinksNum,
+		}
+		if auditRecord.SealedHash == "" {
+			logger.Warn("Merchant '%s' doesn't return the sealed tree of '%s', the file can't be audited", merchant, mt.Hash)
+		} else if auditRecord.LeavesNum != mt.LeavesNum() {
+			// Each part is sealed into one leaf, otherwise the parts of original file can't be challenged one by one
+			logger.Warn("The sealed tree of '%s' from merchant '%s' has %d leaves, but the file has %d parts, the file can't be audited", mt.Hash, merchant, auditRecord.LeavesNum, mt.LeavesNum())
+		} else if err = auditRecord.SaveToDb(db); err != nil {
+			return finishReturnMessage{
+				Info:   fmt.Sprintf("Save audit record of '%s' failed: %s", mt.Hash, err),
+				Status: 500,
+			}
+		}
+	}
+
 	return finishReturnMessage{
 		Info:   fileFinishReturnMsg.Info,
 		Status: fileFinishReturnMsg.Status,
@@ -0,0 +1,405 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"karst/cache"
+	"karst/chain"
+	"karst/config"
+	"karst/filesystem"
+	"karst/loop"
+	"karst/model"
+	"karst/sworker"
+	"karst/utils"
+	"karst/ws"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	testMerchant       = "merchant"
+	testClient         = "5FqazaU79hjpEMiWTWZx81VjsYFst15eBuSBKdQLgQibD7CX"
+	testClientPassword = "123456"
+	testMerchantBackup = `{"address":"merchant"}`
+	testClientBackup   = `{"address":"5FqazaU79hjpEMiWTWZx81VjsYFst15eBuSBKdQLgQibD7CX","encoded":"0xc81537c9442bd1d3f4985531293d88f6d2a960969a88b1cf8413e7c9ec1d5f4955adf91d2d687d8493b70ef457532d505b9cee7a3d2b726a554242b75fb9bec7d4beab74da4bf65260e1d6f7a6b44af4505bf35aaae4cf95b1059ba0f03f1d63c5b7c3ccbacd6bd80577de71f35d0c4976b6e43fe0e1583530e773dfab3ab46c92ce3fa2168673ba52678407a3ef619b5e14155706d43bd329a5e72d36","encoding":{"content":["pkcs8","sr25519"],"type":"xsalsa20-poly1305","version":"2"},"meta":{"name":"Yang1","tags":[],"whenCreated":1580628430860}}`
+	testSealTimeout    = 30 * time.Second
+)
+
+func newTestKarstPaths(t *testing.T, karstPath string) utils.KarstPaths {
+	karstPaths := utils.KarstPaths{
+		InitPath:        karstPath,
+		KarstPath:       karstPath,
+		ConfigFilePath:  filepath.Join(karstPath, "config.json"),
+		UnsealFilesPath: filepath.Join(karstPath, "unseal_files"),
+		SealFilesPath:   filepath.Join(karstPath, "seal_files"),
+		PutFilesPath:    filepath.Join(karstPath, "put_files"),
+		DbPath:          filepath.Join(karstPath, "db"),
+		ApiTokenPath:    filepath.Join(karstPath, "api_token"),
+	}
+
+	for _, path := range []string{karstPaths.UnsealFilesPath, karstPaths.SealFilesPath, karstPaths.PutFilesPath} {
+		if err := os.MkdirAll(path, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return karstPaths
+}
+
+// The listeners are kept until all addresses are got, so the same port isn't returned twice
+func getFreeAddresses(t *testing.T, num int) []string {
+	addresses := make([]string, 0)
+	for i := 0; i < num; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		addresses = append(addresses, listener.Addr().String())
+	}
+	return addresses
+}
+
+func waitForServer(t *testing.T, address string) {
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get("http://" + address + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Karst on '%s' doesn't start", address)
+}
+
+func newTestPutInfo(filePath string) *model.PutInfo {
+	return &model.PutInfo{
+		FilePath: filePath,
+		Merchant: testMerchant,
+		Duration: 1000,
+		Stage:    model.PutStageNew,
+	}
+}
+
+// Put a file to merchant and get it back, the merchant runs on fake chain, mock sworker and local fs
+func TestPutAndGetFlow(t *testing.T) {
+	testPath, err := ioutil.TempDir("", "karst-flow-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testPath)
+
+	fakeChain := chain.NewFakeChain()
+	mockSworker := sworker.NewMockSworker(testMerchantBackup)
+	mockSworkerServer := httptest.NewServer(mockSworker)
+	defer mockSworkerServer.Close()
+
+	// Merchant
+	merchantAddresses := getFreeAddresses(t, 2)
+	merchantCfg := &config.Configuration{
+		KarstPaths:            newTestKarstPaths(t, filepath.Join(testPath, "merchant")),
+		BaseUrl:               merchantAddresses[0],
+		ProbeUrl:              merchantAddresses[1],
+		FilePartSize:          1 << 10,
+		MerkleTreeMaxLinksNum: 3,
+		RetryTimes:            3,
+		RetryInterval:         10 * time.Millisecond,
+	}
+	merchantCfg.Crust.Address = testMerchant
+	merchantCfg.Crust.Backup = testMerchantBackup
+	merchantCfg.Fs.FsFlag = config.LOCAL_FLAG
+	merchantCfg.Fs.Local.Path = filepath.Join(testPath, "merchant", "fs")
+	merchantCfg.Fs.ClientDailyUploadSize = config.DefaultClientDailyUploadSize
+	merchantCfg.Sworker.BaseUrl = mockSworkerServer.Listener.Addr().String()
+	merchantCfg.Sworker.HttpBaseUrl = mockSworkerServer.URL
+	merchantCfg.Sworker.Backup = testMerchantBackup
+	merchantCfg.Sworker.SealWorkersNum = 2
+
+	merchantDb, err := leveldb.OpenFile(merchantCfg.KarstPaths.DbPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer merchantDb.Close()
+
+	merchantFs, err := filesystem.GetFs(merchantCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer merchantFs.Close()
+
+	cache.SetBasePath(testPath)
+	merchantChain := fakeChain.NewClient(testMerchant)
+	if err = merchantChain.Register("ws://"+merchantCfg.BaseUrl, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	sealLoop := loop.StartFileSealLoop(merchantCfg, merchantDb, merchantFs, sworker.NewClient(merchantCfg))
+	defer sealLoop.Stop()
+
+	go func() {
+		if err := ws.StartServer(merchantCfg, merchantFs, merchantDb, merchantChain, sworker.NewClient(merchantCfg)); err != nil {
+			t.Error(err)
+		}
+	}()
+	defer ws.StopServer()
+	waitForServer(t, merchantCfg.BaseUrl)
+	waitForServer(t, merchantCfg.ProbeUrl)
+
+	// Client
+	clientCfg := &config.Configuration{
+		KarstPaths:            newTestKarstPaths(t, filepath.Join(testPath, "client")),
+		FilePartSize:          1 << 10,
+		MerkleTreeMaxLinksNum: 3,
+		RetryTimes:            3,
+		RetryInterval:         10 * time.Millisecond,
+	}
+	clientCfg.Crust.Address = testClient
+	clientCfg.Crust.Backup = testClientBackup
+	clientCfg.Crust.Password = testClientPassword
+
+	clientDb, err := leveldb.OpenFile(clientCfg.KarstPaths.DbPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientDb.Close()
+
+	clientChain := fakeChain.NewClient(testClient)
+
+	fileBytes := make([]byte, 10*(1<<10)+100)
+	if _, err = rand.Read(fileBytes); err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(testPath, "file")
+	if err = ioutil.WriteFile(filePath, fileBytes, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Split and upload
+	fileInfo, err := splitFile(filePath, clientCfg.KarstPaths.PutFilesPath, clientCfg)
+	if err != nil {
+		t.Fatalf("Split failed: %s", err)
+	}
+	mt := fileInfo.MerkleTree
+
+	remoteFs, err := openMerchantFs(testMerchant, clientCfg, clientChain)
+	if err != nil {
+		t.Fatalf("Open merchant fs failed: %s", err)
+	}
+	err = uploadMerkleTreeFile(remoteFs, mt, fileInfo.OriginalPath, 4)
+	remoteFs.Close()
+	if err != nil {
+		t.Fatalf("Upload failed: %s", err)
+	}
+
+	// Declare, sworker is updating when the merchant starts to seal
+	mockSworker.SetUpdating(3)
+	declareReturnMsg := declareFile(*mt, testMerchant, 1000, clientCfg, clientChain)
+	if declareReturnMsg.Status != 200 {
+		t.Fatalf("Declare failed: %s", declareReturnMsg.Info)
+	}
+
+	// Wait for sealing
+	timeStart := time.Now()
+	for {
+		statusReturnMsg := requestMerchantSealStatus(declareReturnMsg.StoreOrderHash, testMerchant, clientCfg, clientChain)
+		if statusReturnMsg.Status != 200 {
+			t.Fatalf("Get seal status failed: %s", statusReturnMsg.Info)
+		}
+
+		sealJobStatus := statusReturnMsg.SealJobStatus
+		if sealJobStatus.Stage == model.SealJobStageFailed || sealJobStatus.Stage == model.SealJobStageDead {
+			t.Fatalf("Seal failed: %s", sealJobStatus.Error)
+		}
+
+		if sealJobStatus.CanFinish {
+			break
+		}
+
+		if time.Since(timeStart) > testSealTimeout {
+			t.Fatalf("Wait for sealing timeout, the last stage is '%s'", sealJobStatus.Stage)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The client can't read the uploaded parts after they are sealed
+	for _, leaf := range mt.Leaves() {
+		if model.IsLocalFsKeyGranted(testClient, leaf.StoredKey, merchantDb) {
+			t.Fatalf("The uploaded part '%s' is still granted to client after sealing", leaf.StoredKey)
+		}
+	}
+
+	// Finish and audit
+	finishReturnMsg := notifyMerchantFinish(mt, testMerchant, clientDb, clientCfg, clientChain)
+	if finishReturnMsg.Status != 200 {
+		t.Fatalf("Finish failed: %s", finishReturnMsg.Info)
+	}
+
+	auditReturnMsg := auditMerchant(mt.Hash, testMerchant, 3, clientDb, clientCfg, clientChain)
+	if auditReturnMsg.Status != 200 || !auditReturnMsg.Passed {
+		t.Fatalf("Audit failed: %s", auditReturnMsg.Info)
+	}
+
+	// The audit fails if a sealed part in merchant fs is broken
+	sealedFileInfo, err := model.GetFileInfoFromDb(mt.Hash, merchantDb, model.FileFlagInDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedKey := sealedFileInfo.MerkleTreeSealed.Leaves()[0].StoredKey
+	sealedPartPath := filepath.Join(merchantCfg.Fs.Local.Path, sealedKey[0:2], sealedKey[2:4], sealedKey)
+	sealedPart, err := ioutil.ReadFile(sealedPartPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brokenPart := append([]byte{}, sealedPart...)
+	brokenPart[0] ^= 0xff
+	if err = ioutil.WriteFile(sealedPartPath, brokenPart, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	leavesNum := sealedFileInfo.MerkleTreeSealed.LeavesNum()
+	auditReturnMsg = auditMerchant(mt.Hash, testMerchant, leavesNum, clientDb, clientCfg, clientChain)
+	if auditReturnMsg.Status != 200 || auditReturnMsg.Passed || auditReturnMsg.FailedNum != 1 || auditReturnMsg.PassedNum != leavesNum-1 {
+		t.Fatalf("Audit of broken part returns status %d, %d passed and %d failed: %s", auditReturnMsg.Status, auditReturnMsg.PassedNum, auditReturnMsg.FailedNum, auditReturnMsg.Info)
+	}
+
+	if err = ioutil.WriteFile(sealedPartPath, sealedPart, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if auditReturnMsg = auditMerchant(mt.Hash, testMerchant, leavesNum, clientDb, clientCfg, clientChain); !auditReturnMsg.Passed {
+		t.Fatalf("Audit of all parts failed: %s", auditReturnMsg.Info)
+	}
+
+	// Get file back
+	outputPath := filepath.Join(testPath, "output")
+	getReturnMsg := getFile(mt.Hash, testMerchant, outputPath, clientCfg, clientChain)
+	if getReturnMsg.Status != 200 {
+		t.Fatalf("Get failed: %s", getReturnMsg.Info)
+	}
+
+	outputBytes, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(outputBytes, fileBytes) {
+		t.Fatalf("The file got from merchant is different, size is %s", strconv.Itoa(len(outputBytes)))
+	}
+
+	// The unsealed parts are deleted by finish of get
+	for _, leaf := range mt.Leaves() {
+		if _, err = merchantFs.GetToBuffer(leaf.StoredKey, leaf.Size); err == nil {
+			t.Fatalf("The unsealed part '%s' is still in merchant fs after finish", leaf.StoredKey)
+		}
+	}
+
+	// Other clients can't get the file
+	otherCfg := *clientCfg
+	otherCfg.Crust.Address = "other"
+	getReturnMsg = getFile(mt.Hash, testMerchant, filepath.Join(testPath, "other_output"), &otherCfg, fakeChain.NewClient("other"))
+	if getReturnMsg.Status == 200 {
+		t.Fatal("The file is got by the client without storage order")
+	}
+
+	// Put another file in one step, put waits while the merchant is sealing
+	putSealCheckInterval = 20 * time.Millisecond
+	putFilePath := filepath.Join(testPath, "put_file")
+	if err = ioutil.WriteFile(putFilePath, fileBytes[:5000], os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	mockSworker.SetUpdating(10)
+	putReturnMsg := putFile(newTestPutInfo(putFilePath), clientDb, clientCfg, clientChain)
+	if putReturnMsg.Status != 200 || putReturnMsg.Stage != model.PutStageFinished {
+		t.Fatalf("Put failed in stage '%s': %s", putReturnMsg.Stage, putReturnMsg.Info)
+	}
+	if _, err = model.GetPutInfoFromDb(putFilePath, testMerchant, clientDb); err == nil {
+		t.Fatal("The put info is kept after put is finished")
+	}
+
+	// Put is interrupted after the storage order is placed, the order isn't placed again when put is resumed
+	resumeFilePath := filepath.Join(testPath, "resume_file")
+	if err = ioutil.WriteFile(resumeFilePath, fileBytes[5000:9000], os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	resumePutInfo := newTestPutInfo(resumeFilePath)
+	resumeFileInfo, err := splitFile(resumeFilePath, clientCfg.KarstPaths.PutFilesPath, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumePutInfo.SplitPath = resumeFileInfo.OriginalPath
+	resumePutInfo.MerkleTree = resumeFileInfo.MerkleTree
+	remoteFs, err = openMerchantFs(testMerchant, clientCfg, clientChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = uploadMerkleTreeFile(remoteFs, resumePutInfo.MerkleTree, resumePutInfo.SplitPath, putUploadParallel)
+	remoteFs.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	placeReturnMsg := placeStorageOrder(*resumePutInfo.MerkleTree, testMerchant, resumePutInfo.Duration, clientChain)
+	if placeReturnMsg.Status != 200 {
+		t.Fatal(placeReturnMsg.Info)
+	}
+	resumePutInfo.StoreOrderHash = placeReturnMsg.StoreOrderHash
+	resumePutInfo.OrderTime = time.Now().UnixNano()
+	resumePutInfo.Stage = model.PutStageOrderPlaced
+
+	if putReturnMsg = putFile(resumePutInfo, clientDb, clientCfg, clientChain); putReturnMsg.Status != 200 {
+		t.Fatalf("Resumed put failed in stage '%s': %s", putReturnMsg.Stage, putReturnMsg.Info)
+	}
+	if putReturnMsg.StoreOrderHash != placeReturnMsg.StoreOrderHash {
+		t.Fatal("Resumed put uses another storage order")
+	}
+	fileMap, err := merchantChain.GetMerchantFileMap(testMerchant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileMap["0x"+resumePutInfo.MerkleTree.Hash]) != 1 {
+		t.Fatalf("The storage order is placed %d times", len(fileMap["0x"+resumePutInfo.MerkleTree.Hash]))
+	}
+
+	// The uploaded file is deleted from merchant if sworker refuses to seal it
+	rejectedFilePath := filepath.Join(testPath, "rejected_file")
+	if err = ioutil.WriteFile(rejectedFilePath, fileBytes[9000:], os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	mockSworker.SetSealRejected(1)
+	if putReturnMsg = putFile(newTestPutInfo(rejectedFilePath), clientDb, clientCfg, clientChain); putReturnMsg.Status == 200 {
+		t.Fatal("Put succeeded while sworker refuses to seal the file")
+	}
+	if _, err = model.GetPutInfoFromDb(rejectedFilePath, testMerchant, clientDb); err == nil {
+		t.Fatal("The put info is kept after put fails for good")
+	}
+
+	rejectedFileInfo, err := splitFile(rejectedFilePath, filepath.Join(testPath, "rejected_split"), clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range rejectedFileInfo.MerkleTree.Leaves() {
+		if _, err = merchantFs.GetToBuffer(leaf.Hash, leaf.Size); err == nil {
+			t.Fatalf("The uploaded part '%s' is still in merchant fs after put fails", leaf.Hash)
+		}
+	}
+
+	// Merchant isn't ready while chain is synchronizing
+	fakeChain.SetSyncing(true)
+	resp, err := http.Get("http://" + merchantCfg.ProbeUrl + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Readyz returns %d while chain is synchronizing", resp.StatusCode)
+	}
+}
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"karst/account"
+	"karst/chain"
+	"karst/config"
+	"karst/filesystem"
+	"karst/logger"
+	"karst/merkletree"
+	"karst/model"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+)
+
+const (
+	getTmpFileSuffix = ".karst_tmp"
+)
+
+type getReturnMessage struct {
+	Info       string `json:"info"`
+	OutputPath string `json:"output_path"`
+	Status     int    `json:"status"`
+}
+
+func init() {
+	getWsCmd.ConnectCmdAndWs()
+	rootCmd.AddCommand(getWsCmd.Cmd)
+}
+
+var getWsCmd = &wsCmd{
+	Cmd: &cobra.Command{
+		Use:   "get [file_hash] [merchant] [output_path]",
+		Short: "Get file from merchant and save it to output_path",
+		Long:  "Get file from merchant and save it to output_path, the merchant will unseal file, then all parts of file will be downloaded from merchant's fs and reassembled, an interrupted download can be resumed by running the same command again",
+		Args:  cobra.MinimumNArgs(3),
+	},
+	Connecter: func(cmd *cobra.Command, args []string) (map[string]string, error) {
+		reqBody := map[string]string{
+			"file_hash":   args[0],
+			"merchant":    args[1],
+			"output_path": args[2],
+		}
+		return reqBody, nil
+	},
+	WsEndpoint: "get",
+	WsRunner: func(args map[string]string, wsc *wsCmd) interface{} {
+		// Base class
+		timeStart := time.Now()
+		logger.Debug("Get input is %s", args)
+
+		// Check input
+		fileHash := args["file_hash"]
+		if fileHash == "" {
+			errString := "The field 'file_hash' is needed"
+			logger.Error(errString)
+			return getReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		merchant := args["merchant"]
+		if merchant == "" {
+			errString := "The field 'merchant' is needed"
+			logger.Error(errString)
+			return getReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		outputPath := args["output_path"]
+		if outputPath == "" {
+			errString := "The field 'output_path' is needed"
+			logger.Error(errString)
+			return getReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		if fileStat, err := os.Stat(outputPath); err == nil && fileStat.IsDir() {
+			outputPath = filepath.FromSlash(outputPath + "/" + fileHash)
+		}
+
+		// Get file
+		getReturnMsg := getFile(fileHash, merchant, outputPath, wsc.Cfg, wsc.Chain)
+		if getReturnMsg.Status != 200 {
+			logger.Error("Get '%s' from '%s' failed, error is: %s", fileHash, merchant, getReturnMsg.Info)
+			return getReturnMsg
+		}
+
+		getReturnMsg.Info = fmt.Sprintf("Get '%s' from '%s' successfully in %s ! It has been saved to '%s'.", fileHash, merchant, time.Since(timeStart), outputPath)
+		logger.Info(getReturnMsg.Info)
+		return getReturnMsg
+	},
+}
+
+func getFile(fileHash string, merchant string, outputPath string, cfg *config.Configuration, chainClient chain.Client) getReturnMessage {
+	// Request merchant to unseal file
+	obtainReturnMsg := requestMerchantUnseal(fileHash, merchant, cfg, chainClient)
+	if obtainReturnMsg.Status != 200 {
+		return getReturnMessage{
+			Info:   obtainReturnMsg.Info,
+			Status: obtainReturnMsg.Status,
+		}
+	}
+
+	var mt merkletree.MerkleTreeNode
+	err := json.Unmarshal([]byte(obtainReturnMsg.MerkleTree), &mt)
+	if err != nil {
+		return getReturnMessage{
+			Info:   fmt.Sprintf("The merkle tree from merchant can't be parsed, err is: %s", err),
+			Status: 500,
+		}
+	}
+
+	if !mt.IsLegal() || mt.Hash != fileHash {
+		return getReturnMessage{
+			Info:   fmt.Sprintf("The merkle tree from merchant is illegal or its hash '%s' isn't '%s'", mt.Hash, fileHash),
+			Status: 500,
+		}
+	}
+
+	// Open merchant fs
+	remoteFs, err := openMerchantFs(merchant, cfg, chainClient)
+	if err != nil {
+		return getReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+	defer remoteFs.Close()
+
+	// Download and reassemble file
+	if err = downloadMerkleTreeFile(remoteFs, &mt, outputPath); err != nil {
+		return getReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	// Help merchant to clear unsealed file, the audit record is kept as it was when the file was stored
+	finishReturnMsg := notifyMerchantFinish(&mt, merchant, nil, cfg, chainClient)
+	if finishReturnMsg.Status != 200 {
+		logger.Warn("Request merchant '%s' to finish '%s' failed, error is: %s", merchant, fileHash, finishReturnMsg.Info)
+	}
+
+	return getReturnMessage{
+		OutputPath: outputPath,
+		Status:     200,
+	}
+}
+
+// Download all parts into a temporary file, parts which have been downloaded will be skipped
+func downloadMerkleTreeFile(fs filesystem.FsInterface, mt *merkletree.MerkleTreeNode, outputPath string) error {
+	tmpPath := outputPath + getTmpFileSuffix
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Fatal error in opening '%s': %s", tmpPath, err)
+	}
+	defer file.Close()
+
+	leaves := mt.Leaves()
+	logger.Info("Downloading '%s' with %d parts.", mt.Hash, len(leaves))
+	bar := pb.StartNew(len(leaves))
+
+	var offset int64 = 0
+	for i, leaf := range leaves {
+		// Bar
+		bar.Increment()
+
+		// Skip downloaded part
+		partBuffer := make([]byte, leaf.Size)
+		if _, err = file.ReadAt(partBuffer, offset); err == nil && isPartMatched(partBuffer, leaf) {
+			offset = offset + int64(leaf.Size)
+			continue
+		}
+
+		// Download part
+		partBuffer, err = fs.GetToBuffer(leaf.StoredKey, leaf.Size)
+		if err != nil {
+			return fmt.Errorf("Fatal error in downloading part %d of '%s': %s", i, mt.Hash, err)
+		}
+
+		if !isPartMatched(partBuffer, leaf) {
+			return fmt.Errorf("The part %d of '%s' is broken, the hash should be '%s'", i, mt.Hash, leaf.Hash)
+		}
+
+		if _, err = file.WriteAt(partBuffer, offset); err != nil {
+			return fmt.Errorf("Fatal error in writing part %d of '%s': %s", i, mt.Hash, err)
+		}
+		offset = offset + int64(leaf.Size)
+	}
+	bar.Finish()
+
+	if err = file.Truncate(offset); err != nil {
+		return fmt.Errorf("Fatal error in truncating '%s': %s", tmpPath, err)
+	}
+
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("Fatal error in syncing '%s': %s", tmpPath, err)
+	}
+
+	if err = os.Rename(tmpPath, outputPath); err != nil {
+		return fmt.Errorf("Fatal error in renaming '%s' to '%s': %s", tmpPath, outputPath, err)
+	}
+
+	return nil
+}
+
+func isPartMatched(partBuffer []byte, leaf *merkletree.MerkleTreeNode) bool {
+	partHash := sha256.Sum256(partBuffer)
+	return uint64(len(partBuffer)) == leaf.Size && hex.EncodeToString(partHash[:]) == leaf.Hash
+}
+
+func openMerchantFs(merchant string, cfg *config.Configuration, chainClient chain.Client) (filesystem.FsInterface, error) {
+	karstBaseAddr, err := chainClient.GetMerchantAddr(merchant)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read karst address of '%s', error: %s", merchant, err)
+	}
+
+	nodeInfoReturnMsg, err := requestMerchantNodeInfo(karstBaseAddr, "address", cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read fs address of '%s', error: %s", merchant, err)
+	}
+
+	// Local fs served by merchant karst is advertised as a path, 'wss://' becomes 'https://'
+	localAddress := nodeInfoReturnMsg.LocalAddress
+	if strings.HasPrefix(localAddress, "/") {
+		localAddress = strings.Replace(karstBaseAddr, "ws", "http", 1) + localAddress
+	}
+
+	var tlsConfig *tls.Config = nil
+	if strings.HasPrefix(localAddress, "https://") {
+		if tlsConfig, err = cfg.TLS.GetClientTLSConfig(); err != nil {
+			return nil, fmt.Errorf("Fatal error in loading tls config: %s", err)
+		}
+	}
+
+	// Requests to local fs are signed by chain account, the keypair is loaded once for all parts
+	var localSigner filesystem.RemoteLocalSigner = nil
+	if localAddress != "" {
+		keypair, err := account.LoadKeypair(cfg.Crust.Backup, cfg.Crust.Password)
+		if err != nil {
+			return nil, fmt.Errorf("Load chain account failed: %s", err)
+		}
+		localSigner = newLocalFsSigner(cfg.Crust.Address, keypair)
+	}
+
+	remoteFs, err := filesystem.OpenRemoteFs(filesystem.RemoteAddress{
+		Fastdfs: nodeInfoReturnMsg.FastdfsAddress,
+		Ipfs:    nodeInfoReturnMsg.IpfsAddress,
+		Local:   localAddress,
+	}, tlsConfig, localSigner)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open fs of '%s', error: %s", merchant, err)
+	}
+
+	return remoteFs, nil
+}
+
+func newLocalFsSigner(client string, keypair *account.Keypair) filesystem.RemoteLocalSigner {
+	return func(req *http.Request, key string, size uint64) error {
+		localFsReq := model.LocalFsRequest{
+			Client: client,
+			Method: req.Method,
+			Key:    key,
+			Size:   size,
+		}
+		if err := model.SignRequest(model.LocalFsSignPath, &localFsReq, keypair); err != nil {
+			return err
+		}
+
+		localFsReqBytes, err := json.Marshal(localFsReq)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(model.LocalFsRequestHeader, string(localFsReqBytes))
+		return nil
+	}
+}
+
+func requestMerchantNodeInfo(karstBaseAddr string, request string, cfg *config.Configuration) (*model.NodeInfoReturnMessage, error) {
+	karstNodeInfoAddr := karstBaseAddr + "/api/v0/node/info"
+	logger.Debug("Connecting to %s to get node information", karstNodeInfoAddr)
+
+	c, err := dialMerchant(karstNodeInfoAddr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if err = c.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+		return nil, err
+	}
+
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Node info return: %s", message)
+
+	nodeInfoReturnMsg := &model.NodeInfoReturnMessage{}
+	if err = json.Unmarshal(message, nodeInfoReturnMsg); err != nil {
+		return nil, fmt.Errorf("Unmarshal json: %s", err)
+	}
+
+	if nodeInfoReturnMsg.Status != 200 {
+		return nil, fmt.Errorf("%s", nodeInfoReturnMsg.Info)
+	}
+
+	return nodeInfoReturnMsg, nil
+}
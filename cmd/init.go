@@ -54,12 +54,24 @@ var initCmd = &cobra.Command{
 				os.Exit(-1)
 			}
 
+			if err := os.MkdirAll(karstPaths.PutFilesPath, os.ModePerm); err != nil {
+				logger.Error("Fatal error in creating karst put files directory: %s", err)
+				os.RemoveAll(karstPaths.KarstPath)
+				os.Exit(-1)
+			}
+
 			if err := os.MkdirAll(karstPaths.DbPath, os.ModePerm); err != nil {
 				logger.Error("Fatal error in creating karst db directory: %s", err)
 				os.RemoveAll(karstPaths.KarstPath)
 				os.Exit(-1)
 			}
 
+			if _, err := createApiToken(karstPaths.ApiTokenPath); err != nil {
+				logger.Error("Fatal error in creating karst api token: %s", err)
+				os.RemoveAll(karstPaths.KarstPath)
+				os.Exit(-1)
+			}
+
 			inputConfigFilePath, _ := cmd.Flags().GetString("config")
 			if inputConfigFilePath == "" {
 				config.WriteDefault(karstPaths.ConfigFilePath)
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"karst/logger"
+	"karst/loop"
+	"karst/model"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type jobsReturnMessage struct {
+	Info   string                 `json:"info"`
+	Jobs   []*model.SealJobStatus `json:"jobs"`
+	Status int                    `json:"status"`
+}
+
+func init() {
+	jobsWsCmd.ConnectCmdAndWs()
+	rootCmd.AddCommand(jobsWsCmd.Cmd)
+}
+
+var jobsWsCmd = &wsCmd{
+	Cmd: &cobra.Command{
+		Use:   "jobs [list|requeue|purge|cancel] [store_order_hash]",
+		Short: "list, requeue or purge seal jobs in dead list, or cancel seal job (for merchant)",
+		Long:  "list, requeue or purge seal jobs in dead list, seal jobs are moved into dead list after too many failed attempts, for example: 'karst jobs list', 'karst jobs requeue [store_order_hash]' or 'karst jobs purge [store_order_hash]', purge will delete the original file. Use 'karst jobs cancel [store_order_hash]' to cancel a queued or running seal job",
+		Args:  cobra.MinimumNArgs(1),
+	},
+	Connecter: func(cmd *cobra.Command, args []string) (map[string]string, error) {
+		storeOrderHash := ""
+		if len(args) > 1 {
+			storeOrderHash = args[1]
+		}
+
+		reqBody := map[string]string{
+			"action":           args[0],
+			"store_order_hash": storeOrderHash,
+		}
+
+		return reqBody, nil
+	},
+	WsEndpoint: "jobs",
+	WsRunner: func(args map[string]string, wsc *wsCmd) interface{} {
+		// Base class
+		timeStart := time.Now()
+		logger.Debug("Jobs input is %s", args)
+
+		// Check input
+		action := args["action"]
+		storeOrderHash := args["store_order_hash"]
+		if action != "list" && storeOrderHash == "" {
+			errString := "The field 'store_order_hash' is needed"
+			logger.Error(errString)
+			return jobsReturnMessage{
+				Info:   errString,
+				Jobs:   make([]*model.SealJobStatus, 0),
+				Status: 400,
+			}
+		}
+
+		switch action {
+		case "list":
+			sealDeadJobList, err := model.GetSealDeadJobList(wsc.Db)
+			if err != nil {
+				logger.Error(err.Error())
+				return jobsReturnMessage{
+					Info:   err.Error(),
+					Jobs:   make([]*model.SealJobStatus, 0),
+					Status: 500,
+				}
+			}
+
+			jobs := make([]*model.SealJobStatus, 0)
+			for _, sealDeadJob := range sealDeadJobList {
+				if storeOrderHash == "" || storeOrderHash == sealDeadJob.Message.StoreOrderHash {
+					jobs = append(jobs, sealDeadJob.GetStatus())
+				}
+			}
+
+			jobsReturnMsg := jobsReturnMessage{
+				Info:   fmt.Sprintf("List %d dead jobs successfully in %s !", len(jobs), time.Since(timeStart)),
+				Jobs:   jobs,
+				Status: 200,
+			}
+			logger.Info(jobsReturnMsg.Info)
+			return jobsReturnMsg
+		case "requeue":
+			if err := loop.RequeueFileSealDeadJob(storeOrderHash); err != nil {
+				errString := fmt.Sprintf("Requeue dead job '%s' failed, error is: %s", storeOrderHash, err)
+				logger.Error(errString)
+				return jobsReturnMessage{
+					Info:   errString,
+					Jobs:   make([]*model.SealJobStatus, 0),
+					Status: 500,
+				}
+			}
+
+			jobsReturnMsg := jobsReturnMessage{
+				Info:   fmt.Sprintf("Requeue dead job '%s' successfully in %s !", storeOrderHash, time.Since(timeStart)),
+				Jobs:   make([]*model.SealJobStatus, 0),
+				Status: 200,
+			}
+			logger.Info(jobsReturnMsg.Info)
+			return jobsReturnMsg
+		case "purge":
+			if err := loop.PurgeFileSealDeadJob(storeOrderHash, wsc.Fs); err != nil {
+				errString := fmt.Sprintf("Purge dead job '%s' failed, error is: %s", storeOrderHash, err)
+				logger.Error(errString)
+				return jobsReturnMessage{
+					Info:   errString,
+					Jobs:   make([]*model.SealJobStatus, 0),
+					Status: 500,
+				}
+			}
+
+			jobsReturnMsg := jobsReturnMessage{
+				Info:   fmt.Sprintf("Purge dead job '%s' successfully in %s !", storeOrderHash, time.Since(timeStart)),
+				Jobs:   make([]*model.SealJobStatus, 0),
+				Status: 200,
+			}
+			logger.Info(jobsReturnMsg.Info)
+			return jobsReturnMsg
+		case "cancel":
+			if status, err := loop.CancelFileSealJob(storeOrderHash, "", wsc.Fs); err != nil {
+				errString := fmt.Sprintf("Cancel seal job '%s' failed, error is: %s", storeOrderHash, err)
+				logger.Error(errString)
+				return jobsReturnMessage{
+					Info:   errString,
+					Jobs:   make([]*model.SealJobStatus, 0),
+					Status: status,
+				}
+			}
+
+			jobsReturnMsg := jobsReturnMessage{
+				Info:   fmt.Sprintf("Cancel seal job '%s' successfully in %s ! The running job will stop at the next stage.", storeOrderHash, time.Since(timeStart)),
+				Jobs:   make([]*model.SealJobStatus, 0),
+				Status: 200,
+			}
+			logger.Info(jobsReturnMsg.Info)
+			return jobsReturnMsg
+		default:
+			errString := fmt.Sprintf("Unknown action '%s', it should be 'list', 'requeue', 'purge' or 'cancel'", action)
+			logger.Error(errString)
+			return jobsReturnMessage{
+				Info:   errString,
+				Jobs:   make([]*model.SealJobStatus, 0),
+				Status: 400,
+			}
+		}
+	},
+}
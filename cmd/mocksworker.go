@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"karst/logger"
+	"karst/sworker"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	mockSworkerCmd.Flags().StringP("backup", "b", "", "only accept requests with this backup, requests aren't checked if it is empty")
+	mockSworkerCmd.Flags().IntP("updating", "u", 0, "return 503 for the first n requests, like sworker which is updating")
+	rootCmd.AddCommand(mockSworkerCmd)
+}
+
+var mockSworkerCmd = &cobra.Command{
+	Use:   "mock-sworker [listen_address]",
+	Short: "Start mock sworker for testing",
+	Long:  "Start mock sworker which serves the storage apis of sworker without SGX, for example: 'karst mock-sworker 127.0.0.1:12222', then set 'sworker.base_url' of merchant to this address. It must run on the same machine as merchant, because files are passed by paths",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		backup, err := cmd.Flags().GetString("backup")
+		if err != nil {
+			logger.Error("%s", err)
+			return
+		}
+
+		updatingTimes, err := cmd.Flags().GetInt("updating")
+		if err != nil {
+			logger.Error("%s", err)
+			return
+		}
+
+		mockSworker := sworker.NewMockSworker(backup)
+		mockSworker.SetUpdating(updatingTimes)
+		logger.Info("Mock sworker is listening on '%s'", args[0])
+		if err := http.ListenAndServe(args[0], mockSworker); err != nil {
+			logger.Error("%s", err)
+		}
+	},
+}
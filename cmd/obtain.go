@@ -65,7 +65,7 @@ var obtainWsCmd = &wsCmd{
 		}
 
 		// Register karst address
-		obtainReturnMsg := requestMerchantUnseal(fileHash, merchant, wsc.Cfg)
+		obtainReturnMsg := requestMerchantUnseal(fileHash, merchant, wsc.Cfg, wsc.Chain)
 		if obtainReturnMsg.Status != 200 {
 			logger.Error("Request merchant '%s' to unseal '%s' failed, error is: %s", fileHash, merchant, obtainReturnMsg.Info)
 			return obtainReturnMsg
@@ -76,9 +76,9 @@ var obtainWsCmd = &wsCmd{
 	},
 }
 
-func requestMerchantUnseal(fileHash string, merchant string, cfg *config.Configuration) obtainReturnMessage {
+func requestMerchantUnseal(fileHash string, merchant string, cfg *config.Configuration, chainClient chain.Client) obtainReturnMessage {
 	// Get merchant unseal address
-	karstBaseAddr, err := chain.GetMerchantAddr(cfg, merchant)
+	karstBaseAddr, err := chainClient.GetMerchantAddr(merchant)
 	if err != nil {
 		return obtainReturnMessage{
 			Info:   fmt.Sprintf("Can't read karst address of '%s', error: %s", merchant, err),
@@ -91,7 +91,7 @@ func requestMerchantUnseal(fileHash string, merchant string, cfg *config.Configu
 
 	// Request merchant to unseal file and return stored information
 	logger.Info("Connecting to %s to unseal file and get information", karstFileUnsealAddr)
-	c, _, err := websocket.DefaultDialer.Dial(karstFileUnsealAddr, nil)
+	c, err := dialMerchant(karstFileUnsealAddr, cfg)
 	if err != nil {
 		return obtainReturnMessage{
 			Info:   err.Error(),
@@ -105,6 +105,13 @@ func requestMerchantUnseal(fileHash string, merchant string, cfg *config.Configu
 		FileHash: fileHash,
 	}
 
+	if err = signMerchantRequest(model.FileUnsealSignPath, &fileUnsealMessage, cfg); err != nil {
+		return obtainReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
 	fileUnsealMsgBytes, err := json.Marshal(fileUnsealMessage)
 	if err != nil {
 		return obtainReturnMessage{
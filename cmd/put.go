@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"karst/chain"
+	"karst/config"
+	"karst/logger"
+	"karst/model"
+	"karst/utils"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	putUploadParallel = 8
+	putStagesNum      = 4
+)
+
+// The seal status is polled until the storage order expires
+var putSealCheckInterval = 10 * time.Second
+
+type putReturnMessage struct {
+	Info           string `json:"info"`
+	MerkleTree     string `json:"merkle_tree"`
+	StoreOrderHash string `json:"store_order_hash"`
+	Stage          string `json:"stage"`
+	Status         int    `json:"status"`
+}
+
+func init() {
+	putWsCmd.ConnectCmdAndWs()
+	rootCmd.AddCommand(putWsCmd.Cmd)
+}
+
+var putWsCmd = &wsCmd{
+	Cmd: &cobra.Command{
+		Use:   "put [file_path] [duration] [merchant]",
+		Short: "Put file to merchant, including split, upload, declare and finish",
+		Long:  "Put file to merchant, it will split file, upload parts into merchant's fs, declare file to chain, wait for sealing until the storage order expires and finish, an interrupted put can be resumed by running the same command again, the storage order is placed only once. If the merchant fails to seal the file, the uploaded file will be deleted by merchant",
+		Args:  cobra.MinimumNArgs(3),
+	},
+	Connecter: func(cmd *cobra.Command, args []string) (map[string]string, error) {
+		reqBody := map[string]string{
+			"file_path": args[0],
+			"duration":  args[1],
+			"merchant":  args[2],
+		}
+
+		return reqBody, nil
+	},
+	WsEndpoint: "put",
+	WsRunner: func(args map[string]string, wsc *wsCmd) interface{} {
+		timeStart := time.Now()
+		logger.Debug("Put input is %s", args)
+
+		// Check input
+		filePath := args["file_path"]
+		if filePath == "" {
+			errString := "The field 'file_path' is needed"
+			logger.Error(errString)
+			return putReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		merchant := args["merchant"]
+		if merchant == "" {
+			errString := "The field 'merchant' is needed"
+			logger.Error(errString)
+			return putReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		duration, err := strconv.ParseUint(args["duration"], 10, 64)
+		if err != nil {
+			errString := err.Error()
+			logger.Error(errString)
+			return putReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		if duration <= declareMinDuration {
+			errString := fmt.Sprintf("The duration must be greater than %d", declareMinDuration)
+			logger.Error(errString)
+			return putReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		// Resume put from db
+		putInfo, err := model.GetPutInfoFromDb(filePath, merchant, wsc.Db)
+		if err != nil {
+			putInfo = &model.PutInfo{
+				FilePath: filePath,
+				Merchant: merchant,
+				Duration: duration,
+				Stage:    model.PutStageNew,
+			}
+		} else {
+			logger.Info("Resume putting '%s' to '%s' from stage '%s'", filePath, merchant, putInfo.Stage)
+		}
+
+		putReturnMsg := putFile(putInfo, wsc.Db, wsc.Cfg, wsc.Chain)
+		if putReturnMsg.Status != 200 {
+			logger.Error("Put '%s' to '%s' failed in stage '%s', error is: %s", filePath, merchant, putReturnMsg.Stage, putReturnMsg.Info)
+			return putReturnMsg
+		}
+
+		putReturnMsg.Info = fmt.Sprintf("Put '%s' to '%s' successfully in %s ! Store order hash is '%s'.", filePath, merchant, time.Since(timeStart), putReturnMsg.StoreOrderHash)
+		logger.Info(putReturnMsg.Info)
+		return putReturnMsg
+	},
+}
+
+// Run each stage of put and save the stage into db after it is done
+func putFile(putInfo *model.PutInfo, db *leveldb.DB, cfg *config.Configuration, chainClient chain.Client) putReturnMessage {
+	// Split
+	if putInfo.Stage == model.PutStageNew || (putInfo.Stage == model.PutStageSplit && !utils.IsDirOrFileExist(putInfo.SplitPath)) {
+		logger.Info("Put stage 1/%d: splitting '%s'", putStagesNum, putInfo.FilePath)
+		fileInfo, err := splitFile(putInfo.FilePath, cfg.KarstPaths.PutFilesPath, cfg)
+		if err != nil {
+			fileInfo.ClearFile()
+			return putFailed(putInfo, err.Error(), 500)
+		}
+
+		putInfo.SplitPath = fileInfo.OriginalPath
+		putInfo.MerkleTree = fileInfo.MerkleTree
+		if putInfo.Stage != model.PutStageNew {
+			logger.Info("The split files of '%s' are lost, put will start from the beginning", putInfo.FilePath)
+		}
+		putInfo.Stage = model.PutStageSplit
+		if err = putInfo.SaveToDb(db); err != nil {
+			return putFailed(putInfo, err.Error(), 500)
+		}
+	}
+
+	// Upload
+	if putInfo.Stage == model.PutStageSplit {
+		logger.Info("Put stage 2/%d: uploading '%s' to '%s'", putStagesNum, putInfo.SplitPath, putInfo.Merchant)
+		remoteFs, err := openMerchantFs(putInfo.Merchant, cfg, chainClient)
+		if err != nil {
+			return putFailed(putInfo, err.Error(), 500)
+		}
+
+		err = uploadMerkleTreeFile(remoteFs, putInfo.MerkleTree, putInfo.SplitPath, putUploadParallel)
+		remoteFs.Close()
+		if err != nil {
+			return putFailed(putInfo, err.Error(), 500)
+		}
+
+		putInfo.Stage = model.PutStageUploaded
+		if err = putInfo.SaveToDb(db); err != nil {
+			return putFailed(putInfo, err.Error(), 500)
+		}
+	}
+
+	// Place storage order, it is saved at once, so the paid order won't be placed again when put is resumed
+	if putInfo.Stage == model.PutStageUploaded {
+		logger.Info("Put stage 3/%d: placing storage order of '%s' to '%s'", putStagesNum, putInfo.MerkleTree.Hash, putInfo.Merchant)
+		placeReturnMsg := placeStorageOrder(*putInfo.MerkleTree, putInfo.Merchant, putInfo.Duration, chainClient)
+		if placeReturnMsg.Status != 200 {
+			return putFailed(putInfo, placeReturnMsg.Info, placeReturnMsg.Status)
+		}
+
+		putInfo.StoreOrderHash = placeReturnMsg.StoreOrderHash
+		putInfo.OrderTime = time.Now().UnixNano()
+		putInfo.Stage = model.PutStageOrderPlaced
+		if err := putInfo.SaveToDb(db); err != nil {
+			return putFailed(putInfo, err.Error(), 500)
+		}
+	}
+
+	// Declare, the seal request is sent again for the placed order if the merchant hasn't accepted it
+	if putInfo.Stage == model.PutStageOrderPlaced {
+		logger.Info("Put stage 3/%d: requesting '%s' to seal '%s'", putStagesNum, putInfo.Merchant, putInfo.MerkleTree.Hash)
+		statusReturnMsg := requestMerchantSealStatus(putInfo.StoreOrderHash, putInfo.Merchant, cfg, chainClient)
+		if statusReturnMsg.Status == 404 {
+			declareReturnMsg := requestMerchantSeal(*putInfo.MerkleTree, putInfo.Merchant, putInfo.StoreOrderHash, cfg, chainClient)
+			if declareReturnMsg.Status != 200 {
+				return putFailed(putInfo, declareReturnMsg.Info, declareReturnMsg.Status)
+			}
+		} else if statusReturnMsg.Status != 200 {
+			return putFailed(putInfo, statusReturnMsg.Info, statusReturnMsg.Status)
+		}
+
+		putInfo.Stage = model.PutStageDeclared
+		if err := putInfo.SaveToDb(db); err != nil {
+			return putFailed(putInfo, err.Error(), 500)
+		}
+	}
+
+	// Wait for sealing and finish, the original file can't be deleted before the sealed file is stored
+	if putInfo.Stage == model.PutStageDeclared {
+		logger.Info("Put stage 4/%d: waiting for '%s' to seal '%s' and finishing", putStagesNum, putInfo.Merchant, putInfo.MerkleTree.Hash)
+		orderExpireTime := time.Unix(0, putInfo.OrderTime).Add(time.Duration(putInfo.Duration) * chain.BlockInterval)
+		lastSealStage := ""
+		for {
+			statusReturnMsg := requestMerchantSealStatus(putInfo.StoreOrderHash, putInfo.Merchant, cfg, chainClient)
+			if statusReturnMsg.Status != 200 {
+				return putFailed(putInfo, statusReturnMsg.Info, statusReturnMsg.Status)
+			}
+
+			sealJobStatus := statusReturnMsg.SealJobStatus
+			switch sealJobStatus.Stage {
+			case model.SealJobStageFailed, model.SealJobStageDead, model.SealJobStageCanceled:
+				return putAbandoned(putInfo, fmt.Sprintf("The merchant failed to seal the file in stage '%s', error is: %s", sealJobStatus.Stage, sealJobStatus.Error), db, cfg, chainClient)
+			}
+
+			if sealJobStatus.CanFinish {
+				break
+			}
+
+			if time.Now().After(orderExpireTime) {
+				return putAbandoned(putInfo, fmt.Sprintf("The storage order expires before sealing, the last stage is '%s'", sealJobStatus.Stage), db, cfg, chainClient)
+			}
+
+			if sealJobStatus.Stage != lastSealStage {
+				logger.Info("The merchant '%s' is sealing '%s' in stage '%s'", putInfo.Merchant, putInfo.MerkleTree.Hash, sealJobStatus.Stage)
+				lastSealStage = sealJobStatus.Stage
+			}
+			time.Sleep(putSealCheckInterval)
+		}
+
+		finishReturnMsg := notifyMerchantFinish(putInfo.MerkleTree, putInfo.Merchant, db, cfg, chainClient)
+		if finishReturnMsg.Status != 200 {
+			return putFailed(putInfo, finishReturnMsg.Info, finishReturnMsg.Status)
+		}
+
+		putInfo.Stage = model.PutStageFinished
+	}
+
+	// Clear
+	putInfo.ClearSplitFile()
+	putInfo.ClearDb(db)
+
+	merkleTreeBytes, _ := json.Marshal(putInfo.MerkleTree)
+	return putReturnMessage{
+		MerkleTree:     string(merkleTreeBytes),
+		StoreOrderHash: putInfo.StoreOrderHash,
+		Stage:          putInfo.Stage,
+		Status:         200,
+	}
+}
+
+// The put fails for good, the merchant is requested to delete the uploaded file by canceling the seal job,
+// and the put will start from the beginning next time
+func putAbandoned(putInfo *model.PutInfo, info string, db *leveldb.DB, cfg *config.Configuration, chainClient chain.Client) putReturnMessage {
+	cancelReturnMsg := requestMerchantSealCancel(putInfo.StoreOrderHash, putInfo.Merchant, cfg, chainClient)
+	if cancelReturnMsg.Status != 200 {
+		logger.Warn("Request '%s' to delete the uploaded file of '%s' failed, error is: %s", putInfo.Merchant, putInfo.StoreOrderHash, cancelReturnMsg.Info)
+	}
+
+	putReturnMsg := putFailed(putInfo, info, 500)
+	putInfo.ClearSplitFile()
+	putInfo.ClearDb(db)
+	return putReturnMsg
+}
+
+func putFailed(putInfo *model.PutInfo, info string, status int) putReturnMessage {
+	return putReturnMessage{
+		Info:           info,
+		StoreOrderHash: putInfo.StoreOrderHash,
+		Stage:          putInfo.Stage,
+		Status:         status,
+	}
+}
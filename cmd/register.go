@@ -3,7 +3,6 @@ package cmd
 import (
 	"fmt"
 	"karst/chain"
-	"karst/config"
 	"karst/logger"
 	"strconv"
 	"time"
@@ -73,7 +72,7 @@ var registerWsCmd = &wsCmd{
 		}
 
 		// Register karst address
-		registerReturnMsg := RegisterToChain(karstAddr, storagePrice, wsc.Cfg)
+		registerReturnMsg := RegisterToChain(karstAddr, storagePrice, wsc.Chain)
 		if registerReturnMsg.Status != 200 {
 			logger.Error("Register to crust failed, error is: %s", registerReturnMsg.Info)
 			return registerReturnMsg
@@ -84,8 +83,8 @@ var registerWsCmd = &wsCmd{
 	},
 }
 
-func RegisterToChain(karstAddr string, storagePrice uint64, cfg *config.Configuration) registerReturnMesssage {
-	if err := chain.Register(cfg, karstAddr, storagePrice); err != nil {
+func RegisterToChain(karstAddr string, storagePrice uint64, chainClient chain.Client) registerReturnMesssage {
+	if err := chainClient.Register(karstAddr, storagePrice); err != nil {
 		return registerReturnMesssage{
 			Info:   fmt.Sprintf("Register failed, please make sure:1. Your `backup`, `password` is correct; 2. You have report works; 3. You have enough mortgage, err is: %s", err.Error()),
 			Status: 400,
@@ -164,7 +164,7 @@ func splitFile(filePath string, outputPath string, cfg *config.Configuration) (*
 	bar.Finish()
 
 	// Rename folder
-	fileMerkleTree := merkletree.CreateMerkleTree(partHashs, partSizes)
+	fileMerkleTree := merkletree.CreateMerkleTree(partHashs, partSizes, cfg.MerkleTreeMaxLinksNum)
 	fileStorePathInHash := filepath.FromSlash(outputPath + "/" + fileMerkleTree.Hash)
 
 	if !utils.IsDirOrFileExist(fileStorePathInHash) {
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"karst/chain"
+	"karst/config"
+	"karst/logger"
+	"karst/model"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+)
+
+type statusReturnMessage struct {
+	Info          string               `json:"info"`
+	SealJobStatus *model.SealJobStatus `json:"seal_job_status"`
+	Status        int                  `json:"status"`
+}
+
+func init() {
+	statusWsCmd.ConnectCmdAndWs()
+	rootCmd.AddCommand(statusWsCmd.Cmd)
+}
+
+var statusWsCmd = &wsCmd{
+	Cmd: &cobra.Command{
+		Use:   "status [store_order_hash] [merchant]",
+		Short: "Get the status of the seal job from merchant",
+		Long:  "Get the status of the seal job from merchant, it is safe to call 'finish' when 'can_finish' is true",
+		Args:  cobra.MinimumNArgs(2),
+	},
+	Connecter: func(cmd *cobra.Command, args []string) (map[string]string, error) {
+		reqBody := map[string]string{
+			"store_order_hash": args[0],
+			"merchant":         args[1],
+		}
+		return reqBody, nil
+	},
+	WsEndpoint: "status",
+	WsRunner: func(args map[string]string, wsc *wsCmd) interface{} {
+		// Base class
+		timeStart := time.Now()
+		logger.Debug("Status input is %s", args)
+
+		// Check input
+		storeOrderHash := args["store_order_hash"]
+		if storeOrderHash == "" {
+			errString := "The field 'store_order_hash' is needed"
+			logger.Error(errString)
+			return statusReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		merchant := args["merchant"]
+		if merchant == "" {
+			errString := "The field 'merchant' is needed"
+			logger.Error(errString)
+			return statusReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		// Request merchant to get the status of seal job
+		statusReturnMsg := requestMerchantSealStatus(storeOrderHash, merchant, wsc.Cfg, wsc.Chain)
+		if statusReturnMsg.Status != 200 {
+			logger.Error("Request merchant '%s' to get the status of '%s' failed, error is: %s", merchant, storeOrderHash, statusReturnMsg.Info)
+			return statusReturnMsg
+		}
+
+		statusReturnMsg.Info = fmt.Sprintf("Get the status of '%s' from '%s' successfully in %s ! Its stage is '%s'.", storeOrderHash, merchant, time.Since(timeStart), statusReturnMsg.SealJobStatus.Stage)
+		logger.Info(statusReturnMsg.Info)
+		return statusReturnMsg
+	},
+}
+
+func requestMerchantSealStatus(storeOrderHash string, merchant string, cfg *config.Configuration, chainClient chain.Client) statusReturnMessage {
+	// Get merchant status address
+	karstBaseAddr, err := chainClient.GetMerchantAddr(merchant)
+	if err != nil {
+		return statusReturnMessage{
+			Info:   fmt.Sprintf("Can't read karst address of '%s', error: %s", merchant, err),
+			Status: 400,
+		}
+	}
+
+	karstFileStatusAddr := karstBaseAddr + "/api/v0/file/status"
+	logger.Debug("Get file status address '%s' of '%s' success.", karstFileStatusAddr, merchant)
+
+	// Request merchant to get seal job status
+	logger.Debug("Connecting to %s to get file status", karstFileStatusAddr)
+	c, err := dialMerchant(karstFileStatusAddr, cfg)
+	if err != nil {
+		return statusReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+	defer c.Close()
+
+	fileStatusMsg := model.FileStatusMessage{
+		Client:         cfg.Crust.Address,
+		StoreOrderHash: storeOrderHash,
+	}
+
+	fileStatusMsgBytes, err := json.Marshal(fileStatusMsg)
+	if err != nil {
+		return statusReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	logger.Debug("File status message is: %s", string(fileStatusMsgBytes))
+
+	if err = c.WriteMessage(websocket.TextMessage, fileStatusMsgBytes); err != nil {
+		return statusReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		return statusReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+	logger.Debug("File status return: %s", message)
+
+	fileStatusReturnMsg := model.FileStatusReturnMessage{}
+	if err = json.Unmarshal(message, &fileStatusReturnMsg); err != nil {
+		return statusReturnMessage{
+			Info:   err.Error(),
+			Status: 500,
+		}
+	}
+
+	if fileStatusReturnMsg.Status == 200 && fileStatusReturnMsg.SealJobStatus == nil {
+		return statusReturnMessage{
+			Info:   "The merchant returns empty seal job status",
+			Status: 500,
+		}
+	}
+
+	return statusReturnMessage{
+		Info:          fileStatusReturnMsg.Info,
+		SealJobStatus: fileStatusReturnMsg.SealJobStatus,
+		Status:        fileStatusReturnMsg.Status,
+	}
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"karst/chain"
+	"karst/config"
+	"karst/filesystem"
+	"karst/logger"
+	"karst/merkletree"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	"github.com/spf13/cobra"
+)
+
+type uploadReturnMessage struct {
+	Info       string `json:"info"`
+	MerkleTree string `json:"merkle_tree"`
+	Status     int    `json:"status"`
+}
+
+func init() {
+	uploadWsCmd.Cmd.Flags().IntP("parallel", "p", 8, "the number of parts uploaded at the same time")
+	uploadWsCmd.ConnectCmdAndWs()
+	rootCmd.AddCommand(uploadWsCmd.Cmd)
+}
+
+var uploadWsCmd = &wsCmd{
+	Cmd: &cobra.Command{
+		Use:   "upload [split_dir] [merchant]",
+		Short: "Upload splited file parts into merchant's fs",
+		Long:  "Upload splited file parts into merchant's fs, the 'split_dir' is the directory created by 'split' and the returned merkle tree containing store keys can be used to declare",
+		Args:  cobra.MinimumNArgs(2),
+	},
+	Connecter: func(cmd *cobra.Command, args []string) (map[string]string, error) {
+		parallel, err := cmd.Flags().GetInt("parallel")
+		if err != nil {
+			return nil, err
+		}
+
+		reqBody := map[string]string{
+			"split_dir": args[0],
+			"merchant":  args[1],
+			"parallel":  strconv.Itoa(parallel),
+		}
+		return reqBody, nil
+	},
+	WsEndpoint: "upload",
+	WsRunner: func(args map[string]string, wsc *wsCmd) interface{} {
+		// Base class
+		timeStart := time.Now()
+		logger.Debug("Upload input is %s", args)
+
+		// Check input
+		splitDir := strings.TrimRight(strings.TrimRight(args["split_dir"], "/"), "\\")
+		if splitDir == "" {
+			errString := "The field 'split_dir' is needed"
+			logger.Error(errString)
+			return uploadReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		merchant := args["merchant"]
+		if merchant == "" {
+			errString := "The field 'merchant' is needed"
+			logger.Error(errString)
+			return uploadReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		parallel, err := strconv.Atoi(args["parallel"])
+		if err != nil || parallel <= 0 {
+			errString := fmt.Sprintf("The field 'parallel' must be a positive integer, err is: %s", err)
+			logger.Error(errString)
+			return uploadReturnMessage{
+				Info:   errString,
+				Status: 400,
+			}
+		}
+
+		// Upload
+		mt, err := uploadSplitDir(splitDir, merchant, parallel, wsc.Cfg, wsc.Chain)
+		if err != nil {
+			logger.Error("Upload '%s' to '%s' failed, error is: %s", splitDir, merchant, err)
+			return uploadReturnMessage{
+				Info:   err.Error(),
+				Status: 500,
+			}
+		}
+
+		merkleTreeBytes, _ := json.Marshal(mt)
+		logger.Debug("Uploaded merkleTree is %s", string(merkleTreeBytes))
+
+		returnInfo := fmt.Sprintf("Upload '%s' to '%s' successfully in %s ! It root hash is '%s'.", splitDir, merchant, time.Since(timeStart), mt.Hash)
+		logger.Info(returnInfo)
+		return uploadReturnMessage{
+			Info:       returnInfo,
+			MerkleTree: string(merkleTreeBytes),
+			Status:     200,
+		}
+	},
+}
+
+func uploadSplitDir(splitDir string, merchant string, parallel int, cfg *config.Configuration, chainClient chain.Client) (*merkletree.MerkleTreeNode, error) {
+	// Rebuild merkle tree from split directory
+	mt, err := readSplitDir(splitDir, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open merchant fs
+	remoteFs, err := openMerchantFs(merchant, cfg, chainClient)
+	if err != nil {
+		return nil, err
+	}
+	defer remoteFs.Close()
+
+	// Upload parts
+	if err = uploadMerkleTreeFile(remoteFs, mt, splitDir, parallel); err != nil {
+		return nil, err
+	}
+
+	return mt, nil
+}
+
+// Rebuild merkle tree by file parts named 'index_hash' in split directory
+func readSplitDir(splitDir string, cfg *config.Configuration) (*merkletree.MerkleTreeNode, error) {
+	partInfos, err := ioutil.ReadDir(splitDir)
+	if err != nil {
+		return nil, fmt.Errorf("Fatal error in reading '%s': %s", splitDir, err)
+	}
+
+	partHashs := make([][]byte, len(partInfos))
+	partSizes := make([]uint64, len(partInfos))
+	for _, partInfo := range partInfos {
+		nameItems := strings.SplitN(partInfo.Name(), "_", 2)
+		if partInfo.IsDir() || len(nameItems) != 2 {
+			return nil, fmt.Errorf("Illegal part '%s' in '%s'", partInfo.Name(), splitDir)
+		}
+
+		index, err := strconv.ParseUint(nameItems[0], 10, 64)
+		if err != nil || index >= uint64(len(partInfos)) || partHashs[index] != nil {
+			return nil, fmt.Errorf("Illegal part index of '%s' in '%s'", partInfo.Name(), splitDir)
+		}
+
+		partHash, err := hex.DecodeString(nameItems[1])
+		if err != nil {
+			return nil, fmt.Errorf("Illegal part hash of '%s' in '%s': %s", partInfo.Name(), splitDir, err)
+		}
+
+		partHashs[index] = partHash
+		partSizes[index] = uint64(partInfo.Size())
+	}
+
+	mt := merkletree.CreateMerkleTree(partHashs, partSizes, cfg.MerkleTreeMaxLinksNum)
+	if dirName := filepath.Base(splitDir); len(dirName) == 64 && dirName != mt.Hash {
+		return nil, fmt.Errorf("The root hash of parts is '%s', but the split directory is '%s'", mt.Hash, dirName)
+	}
+
+	return mt, nil
+}
+
+func uploadMerkleTreeFile(fs filesystem.FsInterface, mt *merkletree.MerkleTreeNode, splitDir string, parallel int) error {
+	leaves := mt.Leaves()
+	logger.Info("Uploading '%s' with %d parts.", mt.Hash, len(leaves))
+	bar := pb.StartNew(len(leaves))
+	defer bar.Finish()
+
+	indexes := make(chan int, len(leaves))
+	for i := range leaves {
+		indexes <- i
+	}
+	close(indexes)
+
+	var errLock sync.Mutex
+	var uploadErr error = nil
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errLock.Lock()
+				failed := uploadErr != nil
+				errLock.Unlock()
+				if failed {
+					return
+				}
+
+				partFileName := filepath.FromSlash(splitDir + "/" + strconv.FormatInt(int64(i), 10) + "_" + leaves[i].Hash)
+				key, err := fs.Put(partFileName)
+				if err != nil {
+					errLock.Lock()
+					uploadErr = fmt.Errorf("Fatal error in uploading '%s': %s", partFileName, err)
+					errLock.Unlock()
+					return
+				}
+
+				leaves[i].StoredKey = key
+				bar.Increment()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return uploadErr
+}
@@ -2,10 +2,18 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
+	"karst/account"
+	"karst/chain"
 	"karst/config"
 	"karst/filesystem"
 	"karst/logger"
+	"karst/metrics"
+	"karst/model"
+	"karst/sworker"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/websocket"
 	"github.com/spf13/cobra"
@@ -16,6 +24,9 @@ type wsCmd struct {
 	Db         *leveldb.DB
 	Cfg        *config.Configuration
 	Fs         filesystem.FsInterface
+	Chain      chain.Client
+	Sworker    sworker.Client
+	Auth       *apiAuth
 	Cmd        *cobra.Command
 	WsEndpoint string
 	Connecter  func(cmd *cobra.Command, args []string) (map[string]string, error)
@@ -24,9 +35,26 @@ type wsCmd struct {
 
 func (wsc *wsCmd) connectCmdAndWsFunc(cmd *cobra.Command, args []string) {
 	wsc.Cfg = config.GetInstance()
+	token, err := loadApiToken(wsc.Cfg.KarstPaths.ApiTokenPath)
+	if err != nil {
+		logger.Error("Fatal error in loading api token, please run 'karst init' or start karst daemon first: %s", err)
+		return
+	}
+
 	// Connect to ws
+	dialer := *websocket.DefaultDialer
 	url := "ws://" + wsc.Cfg.BaseUrl + "/api/v0/cmd/" + wsc.WsEndpoint
-	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if wsc.Cfg.TLS.IsEnabled() {
+		tlsConfig, err := wsc.Cfg.TLS.GetLocalClientTLSConfig()
+		if err != nil {
+			logger.Error("Fatal error in loading tls config: %s", err)
+			return
+		}
+		dialer.TLSClientConfig = tlsConfig
+		url = "wss://" + wsc.Cfg.BaseUrl + "/api/v0/cmd/" + wsc.WsEndpoint
+	}
+
+	c, _, err := dialer.Dial(url, nil)
 	if err != nil {
 		logger.Error("%s", err)
 		return
@@ -39,8 +67,7 @@ func (wsc *wsCmd) connectCmdAndWsFunc(cmd *cobra.Command, args []string) {
 		logger.Error("%s", err)
 		return
 	}
-	reqBody["backup"] = wsc.Cfg.Crust.Backup
-	reqBody["password"] = wsc.Cfg.Crust.Password
+	reqBody["token"] = token
 
 	// Send message to ws
 	reqBodyBytes, err := json.Marshal(reqBody)
@@ -64,11 +91,46 @@ func (wsc *wsCmd) connectCmdAndWsFunc(cmd *cobra.Command, args []string) {
 	logger.Info("%s", message)
 }
 
+// Connect to karst of merchant, 'wss://' address is verified by the trust roots in tls config
+func dialMerchant(karstAddr string, cfg *config.Configuration) (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	if strings.HasPrefix(karstAddr, "wss://") {
+		tlsConfig, err := cfg.TLS.GetClientTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Fatal error in loading tls config: %s", err)
+		}
+		dialer.TLSClientConfig = tlsConfig
+	}
+
+	c, _, err := dialer.Dial(karstAddr, nil)
+	return c, err
+}
+
+// Sign the request to merchant by the chain account in config, the merchant checks that it owns the storage order
+func signMerchantRequest(path string, req model.SignedRequest, cfg *config.Configuration) error {
+	keypair, err := account.LoadKeypair(cfg.Crust.Backup, cfg.Crust.Password)
+	if err != nil {
+		return fmt.Errorf("Load chain account failed: %s", err)
+	}
+	return model.SignRequest(path, req, keypair)
+}
+
 func (wsc *wsCmd) ConnectCmdAndWs() {
 	wsc.Cmd.Run = wsc.connectCmdAndWsFunc
 }
 
 func (wsc *wsCmd) handleFunc(w http.ResponseWriter, r *http.Request) {
+	// Reject blocked host before upgrading
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if wsc.Auth.IsBlocked(host) {
+		logger.Warn("Reject '%s' from '%s' for too many failed attempts", wsc.WsEndpoint, host)
+		http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+		return
+	}
+
 	// Get ws upgrader
 	var upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
@@ -98,8 +160,7 @@ func (wsc *wsCmd) handleFunc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check backup
-	// TODO: support interface
+	// Check token
 	args := make(map[string]string)
 	err = json.Unmarshal(message, &args)
 	if err != nil {
@@ -107,18 +168,12 @@ func (wsc *wsCmd) handleFunc(w http.ResponseWriter, r *http.Request) {
 		wsc.sendBack(c, 400)
 		return
 	}
-	if args["backup"] != wsc.Cfg.Crust.Backup {
-		logger.Error("Wrong backup")
-		wsc.sendBack(c, 400)
-		return
-	}
-	args["backup"] = "***backup***"
-	if args["password"] != wsc.Cfg.Crust.Password {
-		logger.Error("Wrong password")
-		wsc.sendBack(c, 400)
+	if !wsc.Auth.Check(host, args["token"]) {
+		logger.Warn("Wrong api token for '%s' from '%s'", wsc.WsEndpoint, host)
+		wsc.sendBack(c, 401)
 		return
 	}
-	args["password"] = "***password***"
+	args["token"] = "***token***"
 
 	// Run deal function
 	wsc.sendBack(c, wsc.WsRunner(args, wsc))
@@ -138,9 +193,12 @@ func (wsc *wsCmd) sendBack(c *websocket.Conn, back interface{}) {
 	}
 }
 
-func (wsc *wsCmd) Register(db *leveldb.DB, cfg *config.Configuration, fs filesystem.FsInterface) {
+func (wsc *wsCmd) Register(db *leveldb.DB, cfg *config.Configuration, fs filesystem.FsInterface, chainClient chain.Client, sworkerClient sworker.Client, auth *apiAuth) {
 	wsc.Db = db
 	wsc.Cfg = cfg
 	wsc.Fs = fs
-	http.HandleFunc("/api/v0/cmd/"+wsc.WsEndpoint, wsc.handleFunc)
+	wsc.Chain = chainClient
+	wsc.Sworker = sworkerClient
+	wsc.Auth = auth
+	http.HandleFunc("/api/v0/cmd/"+wsc.WsEndpoint, metrics.InstrumentWsHandler("/api/v0/cmd/"+wsc.WsEndpoint, wsc.handleFunc))
 }
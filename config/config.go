@@ -3,8 +3,10 @@ package config
 import (
 	"fmt"
 	"karst/logger"
+	"karst/merkletree"
 	"karst/utils"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,9 +16,21 @@ import (
 const (
 	IPFS_FLAG    string = "ipfs"
 	FASTDFS_FLAG string = "fastdfs"
+	LOCAL_FLAG   string = "local"
+	S3_FLAG      string = "s3"
 	NOFS_FLAG    string = ""
 )
 
+const (
+	DefaultSealWorkersNum        = 4
+	DefaultFastdfsMaxConns       = 100
+	DefaultFastdfsConnectTimeout = 10 * time.Second
+	DefaultFastdfsNetworkTimeout = 30 * time.Second
+	DefaultClientDailyUploadSize = 100 * (1 << 30) // 100 GB
+	// Each pool keeps this number of connections at least
+	FastdfsMinConns = 5
+)
+
 type CrustConfiguration struct {
 	BaseUrl  string
 	Backup   string
@@ -25,10 +39,15 @@ type CrustConfiguration struct {
 }
 
 type SworkerConfiguration struct {
-	BaseUrl     string
-	Backup      string
-	WsBaseUrl   string
-	HttpBaseUrl string
+	BaseUrl        string
+	Backup         string
+	WsBaseUrl      string
+	HttpBaseUrl    string
+	SealWorkersNum int
+	// Files with smaller size will be sealed first in the queue of each client
+	SealSmallFilesFirst bool
+	// The limit of total size of files being sealed for each client, 0 means no limit
+	SealClientMaxInFlightSize uint64
 }
 
 type IpfsConfiguration struct {
@@ -37,27 +56,58 @@ type IpfsConfiguration struct {
 }
 
 type FastdfsConfiguration struct {
-	TrackerAddrs      []string
+	TrackerAddrs []string
+	// Separated by ',' like 'TrackerAddrs' in config file
 	OuterTrackerAddrs string
-	MaxConns          int
+	// The max number of connections to each tracker or storage
+	MaxConns       int
+	ConnectTimeout time.Duration
+	// The timeout of health checks of trackers and connections
+	NetworkTimeout time.Duration
+}
+
+type LocalConfiguration struct {
+	Path string
+	// The url for clients to reach local fs, karst serves local fs by itself if it is empty
+	OuterBaseUrl string
+}
+
+type S3Configuration struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// Parts larger than it are uploaded by multipart upload, 0 means the default size. Each upload buffers one part
+	// of this size (or the whole object if it is smaller) in memory, so the memory used by uploads is up to
+	// 'PartSize' * the number of parallel uploads
+	PartSize uint64
 }
 
 type FsConfiguration struct {
 	FsFlag  string
 	Ipfs    IpfsConfiguration
 	Fastdfs FastdfsConfiguration
+	Local   LocalConfiguration
+	S3      S3Configuration
+	// The limit of size of parts which each client can put into local or s3 fs served by karst in a day
+	ClientDailyUploadSize uint64
 }
 
 type Configuration struct {
-	KarstPaths    utils.KarstPaths
-	BaseUrl       string
-	FilePartSize  uint64
-	RetryTimes    int
-	RetryInterval time.Duration
-	Debug         bool
-	Crust         CrustConfiguration
-	Fs            FsConfiguration
-	Sworker       SworkerConfiguration
+	KarstPaths            utils.KarstPaths
+	BaseUrl               string
+	ProbeUrl              string
+	FilePartSize          uint64
+	MerkleTreeMaxLinksNum uint64
+	RetryTimes            int
+	RetryInterval         time.Duration
+	Debug                 bool
+	Crust                 CrustConfiguration
+	Fs                    FsConfiguration
+	Sworker               SworkerConfiguration
+	TLS                   TLSConfiguration
 }
 
 var config *Configuration
@@ -89,6 +139,14 @@ func GetInstance() *Configuration {
 		config.RetryInterval = 6 * time.Second // 10s
 		config.RetryTimes = 3
 
+		config.MerkleTreeMaxLinksNum = viper.GetUint64("merkle_tree_max_links_num")
+		if config.MerkleTreeMaxLinksNum == 0 {
+			config.MerkleTreeMaxLinksNum = merkletree.DefaultMaxLinksNum
+		} else if config.MerkleTreeMaxLinksNum < 2 {
+			logger.Error("The 'merkle_tree_max_links_num' must be greater than or equal to 2")
+			os.Exit(-1)
+		}
+
 		karstPort := viper.GetInt("port")
 		if karstPort <= 0 {
 			logger.Error("Need right 'port' in config file")
@@ -96,6 +154,47 @@ func GetInstance() *Configuration {
 		}
 		config.BaseUrl = fmt.Sprintf("0.0.0.0:%d", karstPort)
 
+		// Health checks and metrics are served on the probe port without tls if it is given
+		probePort := viper.GetInt("probe_port")
+		if probePort < 0 || probePort == karstPort {
+			logger.Error("The 'probe_port' must be positive and different from 'port'")
+			os.Exit(-1)
+		}
+		if probePort > 0 {
+			config.ProbeUrl = fmt.Sprintf("0.0.0.0:%d", probePort)
+		}
+
+		// TLS
+		config.TLS.CertFile = viper.GetString("tls.cert_file")
+		config.TLS.KeyFile = viper.GetString("tls.key_file")
+		config.TLS.ClientCAFile = viper.GetString("tls.client_ca_file")
+		config.TLS.RootCAFile = viper.GetString("tls.root_ca_file")
+		config.TLS.ClientCertFile = viper.GetString("tls.client_cert_file")
+		config.TLS.ClientKeyFile = viper.GetString("tls.client_key_file")
+		config.TLS.LocalClientCertFile = viper.GetString("tls.local_client_cert_file")
+		config.TLS.LocalClientKeyFile = viper.GetString("tls.local_client_key_file")
+		if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+			logger.Error("The 'tls.cert_file' and 'tls.key_file' must be given together")
+			os.Exit(-1)
+		}
+		if config.TLS.ClientCAFile != "" && !config.TLS.IsEnabled() {
+			logger.Error("The 'tls.client_ca_file' needs 'tls.cert_file' and 'tls.key_file'")
+			os.Exit(-1)
+		}
+		if (config.TLS.ClientCertFile == "") != (config.TLS.ClientKeyFile == "") {
+			logger.Error("The 'tls.client_cert_file' and 'tls.client_key_file' must be given together")
+			os.Exit(-1)
+		}
+		if (config.TLS.LocalClientCertFile == "") != (config.TLS.LocalClientKeyFile == "") {
+			logger.Error("The 'tls.local_client_cert_file' and 'tls.local_client_key_file' must be given together")
+			os.Exit(-1)
+		}
+		// Local commands and probes can't connect without client certificates under mutual TLS
+		if config.TLS.ClientCAFile != "" && (config.TLS.LocalClientCertFile == "" || config.ProbeUrl == "") {
+			logger.Error("The 'tls.client_ca_file' needs 'tls.local_client_cert_file', 'tls.local_client_key_file' and 'probe_port'")
+			os.Exit(-1)
+		}
+
 		// Log
 		config.Debug = viper.GetBool("debug")
 		if config.Debug {
@@ -113,10 +212,22 @@ func GetInstance() *Configuration {
 		}
 
 		// FS
-		fastdfsAddress := viper.GetString("file_system.fastdfs.tracker_addrs")
+		fastdfsAddresses := getAddressList("file_system.fastdfs.tracker_addrs")
 		ipfsBaseUrl := viper.GetString("file_system.ipfs.base_url")
+		localPath := viper.GetString("file_system.local.path")
+		s3Endpoint := viper.GetString("file_system.s3.endpoint")
 
-		if ipfsBaseUrl != "" && fastdfsAddress != "" {
+		fsNum := 0
+		if len(fastdfsAddresses) != 0 {
+			fsNum++
+		}
+		for _, fsAddress := range []string{ipfsBaseUrl, localPath, s3Endpoint} {
+			if fsAddress != "" {
+				fsNum++
+			}
+		}
+
+		if fsNum > 1 {
 			logger.Error("You can only configure one file system")
 			os.Exit(-1)
 		} else if ipfsBaseUrl != "" {
@@ -126,23 +237,65 @@ func GetInstance() *Configuration {
 			config.Fs.Fastdfs.TrackerAddrs = []string{}
 			config.Fs.Fastdfs.OuterTrackerAddrs = ""
 			config.Fs.Fastdfs.MaxConns = 0
-		} else if fastdfsAddress != "" {
+		} else if len(fastdfsAddresses) != 0 {
 			config.Fs.FsFlag = FASTDFS_FLAG
-			config.Fs.Fastdfs.TrackerAddrs = []string{fastdfsAddress}
-			config.Fs.Fastdfs.OuterTrackerAddrs = viper.GetString("file_system.fastdfs.outer_tracker_addrs")
-			config.Fs.Fastdfs.MaxConns = 100
+			config.Fs.Fastdfs.TrackerAddrs = fastdfsAddresses
+			config.Fs.Fastdfs.OuterTrackerAddrs = strings.Join(getAddressList("file_system.fastdfs.outer_tracker_addrs"), ",")
+			config.Fs.Fastdfs.MaxConns = viper.GetInt("file_system.fastdfs.max_conns")
+			if config.Fs.Fastdfs.MaxConns == 0 {
+				config.Fs.Fastdfs.MaxConns = DefaultFastdfsMaxConns
+			} else if config.Fs.Fastdfs.MaxConns < FastdfsMinConns {
+				logger.Error("The 'file_system.fastdfs.max_conns' must be greater than or equal to %d", FastdfsMinConns)
+				os.Exit(-1)
+			}
+			config.Fs.Fastdfs.ConnectTimeout = time.Duration(viper.GetInt64("file_system.fastdfs.connect_timeout")) * time.Second
+			if config.Fs.Fastdfs.ConnectTimeout <= 0 {
+				config.Fs.Fastdfs.ConnectTimeout = DefaultFastdfsConnectTimeout
+			}
+			config.Fs.Fastdfs.NetworkTimeout = time.Duration(viper.GetInt64("file_system.fastdfs.network_timeout")) * time.Second
+			if config.Fs.Fastdfs.NetworkTimeout <= 0 {
+				config.Fs.Fastdfs.NetworkTimeout = DefaultFastdfsNetworkTimeout
+			}
 			config.Fs.Ipfs.BaseUrl = ""
 			config.Fs.Ipfs.OuterBaseUrl = ""
+		} else if localPath != "" {
+			config.Fs.FsFlag = LOCAL_FLAG
+			config.Fs.Local.Path = localPath
+			config.Fs.Local.OuterBaseUrl = viper.GetString("file_system.local.outer_base_url")
+		} else if s3Endpoint != "" {
+			config.Fs.FsFlag = S3_FLAG
+			config.Fs.S3.Endpoint = s3Endpoint
+			config.Fs.S3.Region = viper.GetString("file_system.s3.region")
+			config.Fs.S3.Bucket = viper.GetString("file_system.s3.bucket")
+			config.Fs.S3.Prefix = viper.GetString("file_system.s3.prefix")
+			config.Fs.S3.AccessKey = viper.GetString("file_system.s3.access_key")
+			config.Fs.S3.SecretKey = viper.GetString("file_system.s3.secret_key")
+			config.Fs.S3.PartSize = viper.GetUint64("file_system.s3.part_size")
 		} else {
 			config.Fs.FsFlag = NOFS_FLAG
 		}
 
+		config.Fs.ClientDailyUploadSize = viper.GetUint64("file_system.client_daily_upload_size")
+		if config.Fs.ClientDailyUploadSize == 0 {
+			config.Fs.ClientDailyUploadSize = DefaultClientDailyUploadSize
+		}
+
 		// Sworker
 		config.Sworker.BaseUrl = viper.GetString("sworker.base_url")
 		if config.Sworker.BaseUrl != "" {
 			config.Sworker.HttpBaseUrl = "http://" + config.Sworker.BaseUrl
 			config.Sworker.WsBaseUrl = "ws://" + config.Sworker.BaseUrl
 			config.Sworker.Backup = config.Crust.Backup
+
+			config.Sworker.SealWorkersNum = viper.GetInt("sworker.seal_workers_num")
+			if config.Sworker.SealWorkersNum == 0 {
+				config.Sworker.SealWorkersNum = DefaultSealWorkersNum
+			} else if config.Sworker.SealWorkersNum < 0 {
+				logger.Error("The 'sworker.seal_workers_num' must be greater than 0")
+				os.Exit(-1)
+			}
+			config.Sworker.SealSmallFilesFirst = viper.GetBool("sworker.seal_small_files_first")
+			config.Sworker.SealClientMaxInFlightSize = viper.GetUint64("sworker.seal_client_max_in_flight_size")
 		}
 	})
 
@@ -152,9 +305,31 @@ func GetInstance() *Configuration {
 func (cfg *Configuration) Show() {
 	logger.Info("KarstPath = %s", cfg.KarstPaths.KarstPath)
 	logger.Info("BaseUrl = %s", cfg.BaseUrl)
+	if cfg.ProbeUrl != "" {
+		logger.Info("ProbeUrl = %s", cfg.ProbeUrl)
+	}
+	logger.Info("MerkleTreeMaxLinksNum = %d", cfg.MerkleTreeMaxLinksNum)
 
 	if cfg.Sworker.BaseUrl != "" {
 		logger.Info("SworkerBaseUrl = %s", cfg.Sworker.BaseUrl)
+		logger.Info("SworkerSealWorkersNum = %d", cfg.Sworker.SealWorkersNum)
+		logger.Info("SworkerSealSmallFilesFirst = %t", cfg.Sworker.SealSmallFilesFirst)
+		logger.Info("SworkerSealClientMaxInFlightSize = %d", cfg.Sworker.SealClientMaxInFlightSize)
+	}
+
+	if cfg.TLS.IsEnabled() {
+		logger.Info("TLS.CertFile = %s", cfg.TLS.CertFile)
+		logger.Info("TLS.KeyFile = %s", cfg.TLS.KeyFile)
+		logger.Info("TLS.ClientCAFile = %s", cfg.TLS.ClientCAFile)
+		logger.Info("TLS.LocalClientCertFile = %s", cfg.TLS.LocalClientCertFile)
+		logger.Info("TLS.LocalClientKeyFile = %s", cfg.TLS.LocalClientKeyFile)
+	}
+	if cfg.TLS.RootCAFile != "" {
+		logger.Info("TLS.RootCAFile = %s", cfg.TLS.RootCAFile)
+	}
+	if cfg.TLS.ClientCertFile != "" {
+		logger.Info("TLS.ClientCertFile = %s", cfg.TLS.ClientCertFile)
+		logger.Info("TLS.ClientKeyFile = %s", cfg.TLS.ClientKeyFile)
 	}
 
 	logger.Info("Crust.BaseUrl = %s", cfg.Crust.BaseUrl)
@@ -164,8 +339,24 @@ func (cfg *Configuration) Show() {
 		logger.Info("Ipfs.BaseUrl = %s", cfg.Fs.Ipfs.BaseUrl)
 		logger.Info("Ipfs.OuterBaseUrl = %s", cfg.Fs.Ipfs.OuterBaseUrl)
 	} else if cfg.Fs.FsFlag == FASTDFS_FLAG {
-		logger.Info("Fastdfs.TrackerAddrs = %s", cfg.Fs.Fastdfs.TrackerAddrs[0])
+		logger.Info("Fastdfs.TrackerAddrs = %s", strings.Join(cfg.Fs.Fastdfs.TrackerAddrs, ","))
 		logger.Info("Fastdfs.OuterTrackerAddrs = %s", cfg.Fs.Fastdfs.OuterTrackerAddrs)
+		logger.Info("Fastdfs.MaxConns = %d", cfg.Fs.Fastdfs.MaxConns)
+		logger.Info("Fastdfs.ConnectTimeout = %s", cfg.Fs.Fastdfs.ConnectTimeout)
+		logger.Info("Fastdfs.NetworkTimeout = %s", cfg.Fs.Fastdfs.NetworkTimeout)
+	} else if cfg.Fs.FsFlag == LOCAL_FLAG {
+		logger.Info("Local.Path = %s", cfg.Fs.Local.Path)
+		logger.Info("Local.OuterBaseUrl = %s", cfg.Fs.Local.OuterBaseUrl)
+	} else if cfg.Fs.FsFlag == S3_FLAG {
+		logger.Info("S3.Endpoint = %s", cfg.Fs.S3.Endpoint)
+		logger.Info("S3.Region = %s", cfg.Fs.S3.Region)
+		logger.Info("S3.Bucket = %s", cfg.Fs.S3.Bucket)
+		logger.Info("S3.Prefix = %s", cfg.Fs.S3.Prefix)
+		logger.Info("S3.PartSize = %d", cfg.Fs.S3.PartSize)
+	}
+
+	if cfg.Fs.FsFlag == LOCAL_FLAG || cfg.Fs.FsFlag == S3_FLAG {
+		logger.Info("ClientDailyUploadSize = %d", cfg.Fs.ClientDailyUploadSize)
 	}
 
 	if cfg.Debug {
@@ -179,6 +370,19 @@ func (cfg *Configuration) IsServerMode() bool {
 	return cfg.Sworker.BaseUrl != "" && cfg.Fs.FsFlag != NOFS_FLAG
 }
 
+// The addresses can be a list or a string separated by ','
+func getAddressList(key string) []string {
+	addresses := make([]string, 0)
+	for _, item := range viper.GetStringSlice(key) {
+		for _, address := range strings.Split(item, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
 func NewSworkerConfiguration(baseUrl string, backup string) *SworkerConfiguration {
 	return &SworkerConfiguration{
 		Backup:      backup,
@@ -192,7 +396,19 @@ func WriteDefault(configFilePath string) {
 	viper.SetConfigType("json")
 	// Base configuration
 	viper.Set("port", 17000)
+	viper.Set("probe_port", 0)
 	viper.Set("debug", true)
+	viper.Set("merkle_tree_max_links_num", merkletree.DefaultMaxLinksNum)
+
+	// TLS configuration
+	viper.Set("tls.cert_file", "")
+	viper.Set("tls.key_file", "")
+	viper.Set("tls.client_ca_file", "")
+	viper.Set("tls.root_ca_file", "")
+	viper.Set("tls.client_cert_file", "")
+	viper.Set("tls.client_key_file", "")
+	viper.Set("tls.local_client_cert_file", "")
+	viper.Set("tls.local_client_key_file", "")
 
 	// Crust chain configuration
 	viper.Set("crust.base_url", "")
@@ -202,12 +418,28 @@ func WriteDefault(configFilePath string) {
 
 	// Sworker configuration
 	viper.Set("sworker.base_url", "")
+	viper.Set("sworker.seal_workers_num", DefaultSealWorkersNum)
+	viper.Set("sworker.seal_small_files_first", false)
+	viper.Set("sworker.seal_client_max_in_flight_size", 0)
 
 	// File system configuration
 	viper.Set("file_system.ipfs.base_url", "")
 	viper.Set("file_system.ipfs.outer_base_url", "")
 	viper.Set("file_system.fastdfs.tracker_addrs", "")
 	viper.Set("file_system.fastdfs.outer_tracker_addrs", "")
+	viper.Set("file_system.fastdfs.max_conns", DefaultFastdfsMaxConns)
+	viper.Set("file_system.fastdfs.connect_timeout", int(DefaultFastdfsConnectTimeout/time.Second))
+	viper.Set("file_system.fastdfs.network_timeout", int(DefaultFastdfsNetworkTimeout/time.Second))
+	viper.Set("file_system.local.path", "")
+	viper.Set("file_system.local.outer_base_url", "")
+	viper.Set("file_system.s3.endpoint", "")
+	viper.Set("file_system.s3.region", "")
+	viper.Set("file_system.s3.bucket", "")
+	viper.Set("file_system.s3.prefix", "")
+	viper.Set("file_system.s3.access_key", "")
+	viper.Set("file_system.s3.secret_key", "")
+	viper.Set("file_system.s3.part_size", 0)
+	viper.Set("file_system.client_daily_upload_size", DefaultClientDailyUploadSize)
 
 	// Write
 	if err := viper.WriteConfigAs(configFilePath); err != nil {
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+type TLSConfiguration struct {
+	CertFile string
+	KeyFile  string
+	// Clients must present certificates signed by it if it isn't empty, which is mutual TLS
+	ClientCAFile string
+	// Trust roots of 'wss://' merchants, system roots are used if it is empty
+	RootCAFile string
+	// The certificate presented to merchants which need mutual TLS, the certificate of karst is for servers only
+	ClientCertFile string
+	ClientKeyFile  string
+	// The certificate presented by local commands under mutual TLS, it must be signed by the client CA
+	LocalClientCertFile string
+	LocalClientKeyFile  string
+}
+
+// Karst serves wss and https if the certificate is given
+func (tlsCfg *TLSConfiguration) IsEnabled() bool {
+	return tlsCfg.CertFile != ""
+}
+
+func (tlsCfg *TLSConfiguration) GetServerTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if tlsCfg.ClientCAFile != "" {
+		clientCAs, err := loadCertPool(tlsCfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// The tls config for connecting to merchants, the client certificate is presented for merchants which need mutual TLS
+func (tlsCfg *TLSConfiguration) GetClientTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if tlsCfg.RootCAFile != "" {
+		rootCAs, err := loadCertPool(tlsCfg.RootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if tlsCfg.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.ClientCertFile, tlsCfg.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// Merchants reject the certificate only for servers in handshakes, which is hard to find out
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		if !canAuthClient(leaf) {
+			return nil, fmt.Errorf("The certificate in '%s' can't be used for client authentication", tlsCfg.ClientCertFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// The tls config for commands connecting to the local karst, the server must present the same certificate
+// as the one in config, so the host name of the certificate doesn't matter. The local client certificate is
+// presented under mutual TLS
+func (tlsCfg *TLSConfiguration) GetLocalClientTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], cert.Certificate[0]) {
+				return fmt.Errorf("The certificate of local karst doesn't match '%s'", tlsCfg.CertFile)
+			}
+			return nil
+		},
+	}
+
+	if tlsCfg.LocalClientCertFile != "" {
+		localClientCert, err := tls.LoadX509KeyPair(tlsCfg.LocalClientCertFile, tlsCfg.LocalClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{localClientCert}
+	}
+
+	return tlsConfig, nil
+}
+
+// The certificate without extended key usages can be used for any purpose
+func canAuthClient(cert *x509.Certificate) bool {
+	if len(cert.ExtKeyUsage) == 0 {
+		return true
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageClientAuth || usage == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	caBytes, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caBytes) {
+		return nil, fmt.Errorf("No certificate in '%s'", caFile)
+	}
+	return certPool, nil
+}
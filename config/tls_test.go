@@ -0,0 +1,205 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write the certificate and its key into 'dir', it is self-signed if 'parent' is nil
+func writeTestCert(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err = ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPem, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPem, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newTestCertTemplate(serial int64, extKeyUsage x509.ExtKeyUsage) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "karst"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+}
+
+func TestLocalClientUnderMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "karst-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The certificate of karst can't be used by clients
+	writeTestCert(t, dir, "karst", newTestCertTemplate(1, x509.ExtKeyUsageServerAuth), nil, nil)
+
+	caTemplate := newTestCertTemplate(2, x509.ExtKeyUsageClientAuth)
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := writeTestCert(t, dir, "client_ca", caTemplate, nil, nil)
+	writeTestCert(t, dir, "local_client", newTestCertTemplate(3, x509.ExtKeyUsageClientAuth), ca, caKey)
+
+	tlsCfg := &TLSConfiguration{
+		CertFile:            filepath.Join(dir, "karst.crt"),
+		KeyFile:             filepath.Join(dir, "karst.key"),
+		ClientCAFile:        filepath.Join(dir, "client_ca.crt"),
+		LocalClientCertFile: filepath.Join(dir, "local_client.crt"),
+		LocalClientKeyFile:  filepath.Join(dir, "local_client.key"),
+	}
+
+	serverTLSConfig, err := tlsCfg.GetServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = serverTLSConfig
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(tlsCfg *TLSConfiguration) error {
+		tlsConfig, err := tlsCfg.GetLocalClientTLSConfig()
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if err = get(tlsCfg); err != nil {
+		t.Fatalf("Local client can't connect: %s", err)
+	}
+
+	noClientCertCfg := *tlsCfg
+	noClientCertCfg.LocalClientCertFile = ""
+	noClientCertCfg.LocalClientKeyFile = ""
+	if err = get(&noClientCertCfg); err == nil {
+		t.Fatal("Local client connects without client certificate")
+	}
+
+	// The local client only trusts the certificate of karst
+	otherCfg := *tlsCfg
+	otherCfg.CertFile = filepath.Join(dir, "local_client.crt")
+	otherCfg.KeyFile = filepath.Join(dir, "local_client.key")
+	if err = get(&otherCfg); err == nil {
+		t.Fatal("Local client connects to the server with other certificate")
+	}
+}
+
+func TestClientCertificateForMerchants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "karst-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The certificate of karst is only for servers, the merchant trusts the clients signed by its client CA
+	writeTestCert(t, dir, "karst", newTestCertTemplate(1, x509.ExtKeyUsageServerAuth), nil, nil)
+	writeTestCert(t, dir, "merchant", newTestCertTemplate(2, x509.ExtKeyUsageServerAuth), nil, nil)
+
+	caTemplate := newTestCertTemplate(3, x509.ExtKeyUsageClientAuth)
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := writeTestCert(t, dir, "client_ca", caTemplate, nil, nil)
+	writeTestCert(t, dir, "client", newTestCertTemplate(4, x509.ExtKeyUsageClientAuth), ca, caKey)
+
+	merchantTLSCfg := &TLSConfiguration{
+		CertFile:     filepath.Join(dir, "merchant.crt"),
+		KeyFile:      filepath.Join(dir, "merchant.key"),
+		ClientCAFile: filepath.Join(dir, "client_ca.crt"),
+	}
+	serverTLSConfig, err := merchantTLSCfg.GetServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = serverTLSConfig
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(tlsCfg *TLSConfiguration) error {
+		tlsConfig, err := tlsCfg.GetClientTLSConfig()
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	tlsCfg := &TLSConfiguration{
+		CertFile:       filepath.Join(dir, "karst.crt"),
+		KeyFile:        filepath.Join(dir, "karst.key"),
+		RootCAFile:     filepath.Join(dir, "merchant.crt"),
+		ClientCertFile: filepath.Join(dir, "client.crt"),
+		ClientKeyFile:  filepath.Join(dir, "client.key"),
+	}
+	if err = get(tlsCfg); err != nil {
+		t.Fatalf("Client can't connect to merchant: %s", err)
+	}
+
+	// The certificate of karst isn't presented
+	noClientCertCfg := *tlsCfg
+	noClientCertCfg.ClientCertFile = ""
+	noClientCertCfg.ClientKeyFile = ""
+	if err = get(&noClientCertCfg); err == nil {
+		t.Fatal("Client connects to merchant without client certificate")
+	}
+
+	serverCertCfg := *tlsCfg
+	serverCertCfg.ClientCertFile = filepath.Join(dir, "karst.crt")
+	serverCertCfg.ClientKeyFile = filepath.Join(dir, "karst.key")
+	if _, err = serverCertCfg.GetClientTLSConfig(); err == nil {
+		t.Fatal("The certificate only for servers is loaded as client certificate")
+	}
+}
@@ -1,6 +1,8 @@
 package filesystem
 
 import (
+	"bufio"
+	"io"
 	"karst/config"
 	"karst/filesystem/fastdfs"
 )
@@ -24,6 +26,10 @@ func (this *Fastdfs) Close() {
 	this.client.Destory()
 }
 
+func (this *Fastdfs) Check() error {
+	return this.client.ActiveTest()
+}
+
 func (this *Fastdfs) Put(fileName string) (string, error) {
 	return this.client.UploadByFilename(fileName)
 }
@@ -39,3 +45,31 @@ func (this *Fastdfs) Delete(key string) error {
 func (this *Fastdfs) GetToBuffer(key string, size uint64) ([]byte, error) {
 	return this.client.DownloadToBuffer(key, 0, int64(size))
 }
+
+func (this *Fastdfs) PutReader(reader io.Reader, size uint64) (string, error) {
+	return this.client.UploadByReader(reader, int64(size), "")
+}
+
+func (this *Fastdfs) GetReader(key string) (io.ReadCloser, error) {
+	return this.GetRangeReader(key, 0, 0)
+}
+
+// The part is downloaded into a pipe, so it is read while being received from storage
+func (this *Fastdfs) GetRangeReader(key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(this.client.DownloadToWriter(key, pipeWriter, int64(offset), int64(length)))
+	}()
+
+	// Wait for the first bytes, so that errors like nonexistent part are returned here
+	bufReader := bufio.NewReader(pipeReader)
+	if _, err := bufReader.Peek(1); err != nil && err != io.EOF {
+		pipeReader.Close()
+		return nil, err
+	}
+
+	return &rangeReadCloser{
+		Reader: bufReader,
+		Closer: pipeReader,
+	}, nil
+}
@@ -1,35 +1,82 @@
 package fastdfs
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"karst/config"
+	"karst/logger"
 	"net"
 	"sync"
+	"time"
 )
 
+const (
+	trackerCheckInterval = 20 * time.Second
+)
+
+type tracker struct {
+	addr  string
+	pool  *connPool
+	alive bool
+}
+
 type Client struct {
-	trackerPools    map[string]*connPool
+	trackers        []*tracker
+	nextTracker     int
+	trackerLock     *sync.RWMutex
 	storagePools    map[string]*connPool
 	storagePoolLock *sync.RWMutex
 	config          *config.Configuration
+	connectTimeout  time.Duration
+	networkTimeout  time.Duration
+	finish          chan bool
 }
 
 func NewClientWithConfig(cfg *config.Configuration) (*Client, error) {
+	if len(cfg.Fs.Fastdfs.TrackerAddrs) == 0 {
+		return nil, fmt.Errorf("no tracker address")
+	}
+
 	client := &Client{
 		config:          cfg,
+		trackerLock:     &sync.RWMutex{},
 		storagePoolLock: &sync.RWMutex{},
+		connectTimeout:  cfg.Fs.Fastdfs.ConnectTimeout,
+		networkTimeout:  cfg.Fs.Fastdfs.NetworkTimeout,
+		finish:          make(chan bool),
 	}
-	client.trackerPools = make(map[string]*connPool)
 	client.storagePools = make(map[string]*connPool)
+	if client.connectTimeout <= 0 {
+		client.connectTimeout = config.DefaultFastdfsConnectTimeout
+	}
+	if client.networkTimeout <= 0 {
+		client.networkTimeout = config.DefaultFastdfsNetworkTimeout
+	}
 
+	// The tracker which can't be connected now will be checked later
+	var lastErr error
+	aliveNum := 0
 	for _, addr := range client.config.Fs.Fastdfs.TrackerAddrs {
-		trackerPool, err := newConnPool(addr, client.config.Fs.Fastdfs.MaxConns)
+		trackerPool, err := client.newPool(addr)
 		if err != nil {
-			return nil, err
+			logger.Warn("(Fastdfs) Tracker '%s' can't be connected: %s", addr, err)
+			lastErr = err
+		} else {
+			aliveNum++
 		}
-		client.trackerPools[addr] = trackerPool
+		client.trackers = append(client.trackers, &tracker{
+			addr:  addr,
+			pool:  trackerPool,
+			alive: err == nil,
+		})
+	}
+
+	if aliveNum == 0 {
+		return nil, fmt.Errorf("no tracker can be connected: %s", lastErr)
 	}
 
+	go client.checkTrackers()
 	return client, nil
 }
 
@@ -37,10 +84,28 @@ func (this *Client) Destory() {
 	if this == nil {
 		return
 	}
-	for _, pool := range this.trackerPools {
-		pool.Destory()
+	this.finish <- true
+
+	// Pools are destroyed out of locks, destroying waits for the check of connections
+	pools := make([]*connPool, 0)
+	this.trackerLock.Lock()
+	for _, tracker := range this.trackers {
+		if tracker.pool != nil {
+			pools = append(pools, tracker.pool)
+			tracker.pool = nil
+		}
+		tracker.alive = false
+	}
+	this.trackerLock.Unlock()
+
+	this.storagePoolLock.Lock()
+	for addr, pool := range this.storagePools {
+		pools = append(pools, pool)
+		delete(this.storagePools, addr)
 	}
-	for _, pool := range this.storagePools {
+	this.storagePoolLock.Unlock()
+
+	for _, pool := range pools {
 		pool.Destory()
 	}
 }
@@ -91,6 +156,33 @@ func (this *Client) UploadByBuffer(buffer []byte, fileExtName string) (string, e
 	return task.fileId, nil
 }
 
+// Upload 'fileSize' bytes from reader, the data is sent to storage without being buffered
+func (this *Client) UploadByReader(reader io.Reader, fileSize int64, fileExtName string) (string, error) {
+	if len(fileExtName) > 6 {
+		fileExtName = fileExtName[:6]
+	}
+	fileInfo := &fileInfo{
+		fileSize:    fileSize,
+		reader:      reader,
+		fileExtName: fileExtName,
+	}
+
+	storageInfo, err := this.queryStorageInfoWithTracker(TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	task := &storageUploadTask{}
+	//req
+	task.fileInfo = fileInfo
+	task.storagePathIndex = storageInfo.storagePathIndex
+
+	if err := this.doStorage(task, storageInfo); err != nil {
+		return "", err
+	}
+	return task.fileId, nil
+}
+
 func (this *Client) DownloadToFile(fileId string, localFilename string, offset int64, downloadBytes int64) error {
 	groupName, remoteFilename, err := splitFileId(fileId)
 	if err != nil {
@@ -114,6 +206,30 @@ func (this *Client) DownloadToFile(fileId string, localFilename string, offset i
 	return this.doStorage(task, storageInfo)
 }
 
+// Download data into writer while it is received from storage
+func (this *Client) DownloadToWriter(fileId string, writer io.Writer, offset int64, downloadBytes int64) error {
+	groupName, remoteFilename, err := splitFileId(fileId)
+	if err != nil {
+		return err
+	}
+	storageInfo, err := this.queryStorageInfoWithTracker(TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE, groupName, remoteFilename)
+	if err != nil {
+		return err
+	}
+
+	task := &storageDownloadTask{}
+	//req
+	task.groupName = groupName
+	task.remoteFilename = remoteFilename
+	task.offset = offset
+	task.downloadBytes = downloadBytes
+
+	//res
+	task.writer = writer
+
+	return this.doStorage(task, storageInfo)
+}
+
 //deprecated
 func (this *Client) DownloadToBuffer(fileId string, offset int64, downloadBytes int64) ([]byte, error) {
 	groupName, remoteFilename, err := splitFileId(fileId)
@@ -182,21 +298,53 @@ func (this *Client) DeleteFile(fileId string) error {
 	return this.doStorage(task, storageInfo)
 }
 
-func (this *Client) doTracker(task task) error {
-	trackerConn, err := this.getTrackerConn()
-	if err != nil {
-		return err
+// Run the active test on alive trackers, the client is usable if any tracker passes it
+func (this *Client) ActiveTest() error {
+	trackers := this.getAliveTrackers()
+	if len(trackers) == 0 {
+		return fmt.Errorf("no tracker can be used")
 	}
-	defer trackerConn.Close()
 
-	if err := task.SendReq(trackerConn); err != nil {
-		return err
+	var err error
+	for _, tracker := range trackers {
+		trackerPool := this.getTrackerPool(tracker)
+		if trackerPool == nil {
+			continue
+		}
+
+		var conn net.Conn
+		conn, err = trackerPool.get()
+		// All connections are in use, so the tracker is working
+		var busyErr *poolBusyError
+		if errors.As(err, &busyErr) {
+			return nil
+		}
+		if err != nil {
+			this.markTrackerDown(tracker, trackerPool, err)
+			continue
+		}
+
+		if err = activeTest(conn.(pConn).Conn, this.networkTimeout); err != nil {
+			conn.(pConn).discard()
+			this.markTrackerDown(tracker, trackerPool, err)
+			continue
+		}
+		return conn.Close()
+	}
+
+	if err == nil {
+		err = fmt.Errorf("no tracker can be used")
 	}
-	if err := task.RecvRes(trackerConn); err != nil {
+	return err
+}
+
+func (this *Client) doTracker(task task, trackerPool *connPool) error {
+	trackerConn, err := trackerPool.get()
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return doTask(task, trackerConn)
 }
 
 func (this *Client) doStorage(task task, storageInfo *storageInfo) error {
@@ -204,51 +352,166 @@ func (this *Client) doStorage(task task, storageInfo *storageInfo) error {
 	if err != nil {
 		return err
 	}
-	defer storageConn.Close()
 
-	if err := task.SendReq(storageConn); err != nil {
+	return doTask(task, storageConn)
+}
+
+// The connection is put back only after the whole task succeeds, otherwise the rest of
+// the response may be left in the stream, so the connection is discarded
+func doTask(task task, conn net.Conn) error {
+	if err := task.SendReq(conn); err != nil {
+		conn.(pConn).discard()
 		return err
 	}
-	if err := task.RecvRes(storageConn); err != nil {
+	if err := task.RecvRes(conn); err != nil {
+		conn.(pConn).discard()
 		return err
 	}
 
-	return nil
+	return conn.Close()
 }
 
+// Query trackers in turn, the query is retried against the next tracker if the tracker goes down
 func (this *Client) queryStorageInfoWithTracker(cmd int8, groupName string, remoteFilename string) (*storageInfo, error) {
-	task := &trackerTask{}
-	task.cmd = cmd
-	task.groupName = groupName
-	task.remoteFilename = remoteFilename
+	trackers := this.getAliveTrackers()
+	if len(trackers) == 0 {
+		return nil, fmt.Errorf("no tracker can be used")
+	}
 
-	if err := this.doTracker(task); err != nil {
-		return nil, err
+	var err error
+	for _, tracker := range trackers {
+		trackerPool := this.getTrackerPool(tracker)
+		if trackerPool == nil {
+			continue
+		}
+
+		task := &trackerTask{}
+		task.cmd = cmd
+		task.groupName = groupName
+		task.remoteFilename = remoteFilename
+
+		if err = this.doTracker(task, trackerPool); err == nil {
+			return &storageInfo{
+				addr:             fmt.Sprintf("%s:%d", task.ipAddr, task.port),
+				storagePathIndex: task.storePathIndex,
+			}, nil
+		}
+
+		// The tracker works but refuses the query, like the file doesn't exist, or all connections are in use
+		var statusErr *statusError
+		var busyErr *poolBusyError
+		if errors.As(err, &statusErr) || errors.As(err, &busyErr) {
+			return nil, err
+		}
+
+		this.markTrackerDown(tracker, trackerPool, err)
+	}
+
+	if err == nil {
+		err = fmt.Errorf("no tracker can be used")
 	}
-	return &storageInfo{
-		addr:             fmt.Sprintf("%s:%d", task.ipAddr, task.port),
-		storagePathIndex: task.storePathIndex,
-	}, nil
+	return nil, err
 }
 
-func (this *Client) getTrackerConn() (net.Conn, error) {
-	var trackerConn net.Conn
-	var err error
-	var getOne bool
-	for _, trackerPool := range this.trackerPools {
-		trackerConn, err = trackerPool.get()
-		if err == nil {
-			getOne = true
-			break
+// Get alive trackers from the next one, so that queries are spread over trackers
+func (this *Client) getAliveTrackers() []*tracker {
+	this.trackerLock.Lock()
+	defer this.trackerLock.Unlock()
+
+	trackers := make([]*tracker, 0, len(this.trackers))
+	for i := range this.trackers {
+		tracker := this.trackers[(this.nextTracker+i)%len(this.trackers)]
+		if tracker.alive {
+			trackers = append(trackers, tracker)
 		}
 	}
-	if getOne {
-		return trackerConn, nil
+	this.nextTracker = (this.nextTracker + 1) % len(this.trackers)
+	return trackers
+}
+
+func (this *Client) getTrackerPool(tracker *tracker) *connPool {
+	this.trackerLock.RLock()
+	defer this.trackerLock.RUnlock()
+	return tracker.pool
+}
+
+// Take the tracker out of rotation, it will be back after passing the health check
+func (this *Client) markTrackerDown(tracker *tracker, trackerPool *connPool, err error) {
+	this.trackerLock.Lock()
+	// The tracker has been marked down or checked again by others
+	if !tracker.alive || tracker.pool != trackerPool {
+		this.trackerLock.Unlock()
+		return
+	}
+
+	logger.Warn("(Fastdfs) Tracker '%s' is down: %s", tracker.addr, err)
+	tracker.alive = false
+	tracker.pool = nil
+	this.trackerLock.Unlock()
+
+	// Destroying waits for the check of connections, which can take long on a down tracker, so it is out of the lock
+	trackerPool.Destory()
+}
+
+func (this *Client) checkTrackers() {
+	timer := time.NewTimer(trackerCheckInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-this.finish:
+			return
+		case <-timer.C:
+			this.trackerLock.RLock()
+			downTrackers := make([]*tracker, 0)
+			for _, tracker := range this.trackers {
+				if !tracker.alive {
+					downTrackers = append(downTrackers, tracker)
+				}
+			}
+			this.trackerLock.RUnlock()
+
+			for _, tracker := range downTrackers {
+				this.checkTracker(tracker)
+			}
+			timer.Reset(trackerCheckInterval)
+		}
 	}
+}
+
+// Connect the down tracker again and put it back into rotation if it passes the active test
+func (this *Client) checkTracker(tracker *tracker) {
+	trackerPool, err := this.newPool(tracker.addr)
+	if err != nil {
+		logger.Debug("(Fastdfs) Tracker '%s' is still down: %s", tracker.addr, err)
+		return
+	}
+
+	conn, err := trackerPool.get()
 	if err == nil {
-		return nil, fmt.Errorf("no connPool can be use")
+		err = activeTest(conn.(pConn).Conn, this.networkTimeout)
+		conn.Close()
 	}
-	return nil, err
+	if err != nil {
+		logger.Debug("(Fastdfs) Tracker '%s' is still down: %s", tracker.addr, err)
+		trackerPool.Destory()
+		return
+	}
+
+	this.trackerLock.Lock()
+	// The tracker has been put back by others
+	if tracker.alive {
+		this.trackerLock.Unlock()
+		trackerPool.Destory()
+		return
+	}
+	tracker.pool = trackerPool
+	tracker.alive = true
+	this.trackerLock.Unlock()
+	logger.Info("(Fastdfs) Tracker '%s' is back", tracker.addr)
+}
+
+func (this *Client) newPool(addr string) (*connPool, error) {
+	return newConnPool(addr, this.config.Fs.Fastdfs.MaxConns, this.connectTimeout, this.networkTimeout)
 }
 
 func (this *Client) getStorageConn(storageInfo *storageInfo) (net.Conn, error) {
@@ -258,7 +521,7 @@ func (this *Client) getStorageConn(storageInfo *storageInfo) (net.Conn, error) {
 		this.storagePoolLock.Unlock()
 		return storagePool.get()
 	}
-	storagePool, err := newConnPool(storageInfo.addr, this.config.Fs.Fastdfs.MaxConns)
+	storagePool, err := this.newPool(storageInfo.addr)
 	if err != nil {
 		this.storagePoolLock.Unlock()
 		return nil, err
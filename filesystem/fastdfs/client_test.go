@@ -0,0 +1,251 @@
+package fastdfs
+
+import (
+	"bytes"
+	"errors"
+	"karst/config"
+	"net"
+	"testing"
+	"time"
+)
+
+func startFakeServer(t *testing.T, address string) *FakeServer {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewFakeServer(listener, "group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	return server
+}
+
+func newTestClient(t *testing.T, trackerAddrs ...string) *Client {
+	cfg := &config.Configuration{}
+	cfg.Fs.Fastdfs.TrackerAddrs = trackerAddrs
+	cfg.Fs.Fastdfs.MaxConns = config.FastdfsMinConns
+	cfg.Fs.Fastdfs.ConnectTimeout = time.Second
+	cfg.Fs.Fastdfs.NetworkTimeout = time.Second
+
+	client, err := NewClientWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestUploadDownloadAndDelete(t *testing.T) {
+	server := startFakeServer(t, "127.0.0.1:0")
+	defer server.Close()
+
+	client := newTestClient(t, server.Addr())
+	defer client.Destory()
+
+	content := bytes.Repeat([]byte("karst"), 1000)
+	fileId, err := client.UploadByBuffer(content, "")
+	if err != nil {
+		t.Fatalf("Upload failed: %s", err)
+	}
+
+	fileIds := server.FileIds()
+	if len(fileIds) != 1 || fileIds[0] != fileId {
+		t.Fatalf("Files in server are %v, expected '%s'", fileIds, fileId)
+	}
+
+	downloaded, err := client.DownloadToBuffer(fileId, 0, 0)
+	if err != nil {
+		t.Fatalf("Download failed: %s", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatal("Downloaded file is different")
+	}
+
+	part, err := client.DownloadToBuffer(fileId, 5, 10)
+	if err != nil {
+		t.Fatalf("Ranged download failed: %s", err)
+	}
+	if !bytes.Equal(part, content[5:15]) {
+		t.Fatalf("Ranged download returns '%s', expected '%s'", part, content[5:15])
+	}
+
+	readerFileId, err := client.UploadByReader(bytes.NewReader(content), int64(len(content)), "")
+	if err != nil {
+		t.Fatalf("Upload by reader failed: %s", err)
+	}
+
+	var writer bytes.Buffer
+	if err = client.DownloadToWriter(readerFileId, &writer, 0, 0); err != nil {
+		t.Fatalf("Download to writer failed: %s", err)
+	}
+	if !bytes.Equal(writer.Bytes(), content) {
+		t.Fatal("Downloaded file uploaded by reader is different")
+	}
+
+	if err = client.DeleteFile(fileId); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if err = client.DeleteFile(fileId); err == nil {
+		t.Fatal("Delete of deleted file succeeded")
+	}
+	if _, err = client.DownloadToBuffer(fileId, 0, 0); err == nil {
+		t.Fatal("Download of deleted file succeeded")
+	}
+
+	if err = client.ActiveTest(); err != nil {
+		t.Fatalf("Active test failed: %s", err)
+	}
+}
+
+func TestTrackerFailover(t *testing.T) {
+	downServer := startFakeServer(t, "127.0.0.1:0")
+	downAddr := downServer.Addr()
+	server := startFakeServer(t, "127.0.0.1:0")
+	defer server.Close()
+
+	client := newTestClient(t, downAddr, server.Addr())
+	defer client.Destory()
+
+	// The pooled connections to the first tracker are broken
+	downServer.Close()
+
+	content := []byte("failover")
+	for i := 0; i < 2; i++ {
+		fileId, err := client.UploadByBuffer(content, "")
+		if err != nil {
+			t.Fatalf("Upload failed after one tracker goes down: %s", err)
+		}
+
+		downloaded, err := client.DownloadToBuffer(fileId, 0, 0)
+		if err != nil {
+			t.Fatalf("Download failed after one tracker goes down: %s", err)
+		}
+		if !bytes.Equal(downloaded, content) {
+			t.Fatal("Downloaded file is different")
+		}
+	}
+
+	if len(client.getAliveTrackers()) != 1 {
+		t.Fatal("The down tracker is still alive")
+	}
+
+	if err := client.ActiveTest(); err != nil {
+		t.Fatalf("Active test failed after one tracker goes down: %s", err)
+	}
+
+	// The tracker is back after passing the health check
+	backServer := startFakeServer(t, downAddr)
+	defer backServer.Close()
+	client.checkTracker(client.trackers[0])
+	if len(client.getAliveTrackers()) != 2 {
+		t.Fatal("The tracker isn't back after the health check")
+	}
+
+	// No tracker can be used
+	backServer.Close()
+	server.Close()
+	if _, err := client.UploadByBuffer(content, ""); err == nil {
+		t.Fatal("Upload succeeded while all trackers are down")
+	}
+	if len(client.getAliveTrackers()) != 0 {
+		t.Fatal("Trackers are still alive after they go down")
+	}
+	if err := client.ActiveTest(); err == nil {
+		t.Fatal("Active test succeeded while all trackers are down")
+	}
+}
+
+func TestMarkTrackerDownDoesNotBlockLookups(t *testing.T) {
+	server := startFakeServer(t, "127.0.0.1:0")
+	defer server.Close()
+	otherServer := startFakeServer(t, "127.0.0.1:0")
+	defer otherServer.Close()
+
+	client := newTestClient(t, server.Addr(), otherServer.Addr())
+	defer client.Destory()
+
+	// The pool is checking connections, so destroying it waits
+	tracker := client.trackers[0]
+	trackerPool := client.getTrackerPool(tracker)
+	trackerPool.lock.Lock()
+
+	marked := make(chan bool)
+	go func() {
+		client.markTrackerDown(tracker, trackerPool, errors.New("down"))
+		marked <- true
+	}()
+
+	looked := make(chan int)
+	go func() {
+		for len(client.getAliveTrackers()) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		looked <- len(client.getAliveTrackers())
+	}()
+
+	blocked := false
+	select {
+	case <-looked:
+	case <-time.After(5 * time.Second):
+		blocked = true
+	}
+
+	trackerPool.lock.Unlock()
+	<-marked
+	if blocked {
+		t.Fatal("Tracker lookups are blocked while the pool of the down tracker is destroyed")
+	}
+}
+
+func TestFullPoolKeepsTrackerAlive(t *testing.T) {
+	server := startFakeServer(t, "127.0.0.1:0")
+	defer server.Close()
+
+	client := newTestClient(t, server.Addr())
+	defer client.Destory()
+
+	// Take all connections of the tracker
+	trackerPool := client.getTrackerPool(client.trackers[0])
+	conns := make([]net.Conn, 0, config.FastdfsMinConns)
+	for i := 0; i < config.FastdfsMinConns; i++ {
+		conn, err := trackerPool.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+
+	content := []byte("busy")
+	_, err := client.UploadByBuffer(content, "")
+	var busyErr *poolBusyError
+	if !errors.As(err, &busyErr) {
+		t.Fatalf("Upload with full pool returns '%v', expected busy error", err)
+	}
+	if len(client.getAliveTrackers()) != 1 {
+		t.Fatal("The tracker is marked down because its pool is full")
+	}
+	if err = client.ActiveTest(); err != nil {
+		t.Fatalf("Active test failed with full pool: %s", err)
+	}
+
+	// The waiting upload goes on after a connection is put back
+	done := make(chan error)
+	go func() {
+		_, err := client.UploadByBuffer(content, "")
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	conns[0].Close()
+	if err = <-done; err != nil {
+		t.Fatalf("Upload failed after a connection is put back: %s", err)
+	}
+
+	for _, conn := range conns[1:] {
+		conn.Close()
+	}
+	if len(client.getAliveTrackers()) != 1 {
+		t.Fatal("The tracker isn't alive")
+	}
+}
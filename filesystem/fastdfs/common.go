@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path"
@@ -18,6 +19,7 @@ const (
 	STORAGE_PROTO_CMD_UPLOAD_FILE   = 11
 	STORAGE_PROTO_CMD_DELETE_FILE   = 12
 	STORAGE_PROTO_CMD_DOWNLOAD_FILE = 14
+	STORAGE_PROTO_CMD_RESP          = 100
 	FDFS_PROTO_CMD_ACTIVE_TEST      = 111
 )
 
@@ -34,6 +36,7 @@ type fileInfo struct {
 	fileSize    int64
 	buffer      []byte
 	file        *os.File
+	reader      io.Reader
 	fileExtName string
 }
 
@@ -108,7 +111,7 @@ func (this *header) SendHeader(conn net.Conn) error {
 
 func (this *header) RecvHeader(conn net.Conn) error {
 	buf := make([]byte, 10)
-	if _, err := conn.Read(buf); err != nil {
+	if _, err := io.ReadFull(conn, buf); err != nil {
 		return err
 	}
 
@@ -125,14 +128,36 @@ func (this *header) RecvHeader(conn net.Conn) error {
 	if err != nil {
 		return err
 	}
+	if this.pkgLen < 0 {
+		return fmt.Errorf("recv resp pkgLen %d < 0", this.pkgLen)
+	}
 	if status != 0 {
-		return fmt.Errorf("recv resp status %d != 0", status)
+		return &statusError{status: int8(status)}
 	}
 	this.cmd = int8(cmd)
 	this.status = int8(status)
 	return nil
 }
 
+// The error status in response, it means that the server works but refuses the request
+type statusError struct {
+	status int8
+}
+
+func (this *statusError) Error() string {
+	return fmt.Sprintf("recv resp status %d != 0", this.status)
+}
+
+// The pool is full and no connection is put back in time, the server may be alive, so the task can be retried later
+type poolBusyError struct {
+	addr     string
+	maxConns int
+}
+
+func (this *poolBusyError) Error() string {
+	return fmt.Sprintf("reach maxConns %d of %s", this.maxConns, this.addr)
+}
+
 func splitFileId(fileId string) (string, string, error) {
 	str := strings.SplitN(fileId, "/", 2)
 	if len(str) < 2 {
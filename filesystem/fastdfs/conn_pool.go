@@ -3,13 +3,14 @@ package fastdfs
 import (
 	"container/list"
 	"fmt"
+	"karst/config"
 	"net"
 	"sync"
 	"time"
 )
 
 const (
-	MAXCONNS_LEAST = 5
+	MAXCONNS_LEAST = config.FastdfsMinConns
 )
 
 type pConn struct {
@@ -17,29 +18,65 @@ type pConn struct {
 	pool *connPool
 }
 
+// Put the connection back to pool, it must be called only after a whole task succeeds
 func (c pConn) Close() error {
 	return c.pool.put(c)
 }
 
+// Each read or write must finish in the network timeout, so a broken server can't block tasks
+func (c pConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.pool.networkTimeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c pConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.pool.networkTimeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// Close the connection instead of putting it back, the stream may be broken after errors
+func (c pConn) discard() error {
+	return c.pool.discard(c)
+}
+
 type connPool struct {
-	conns    *list.List
-	addr     string
-	maxConns int
-	count    int
-	lock     *sync.RWMutex
+	conns          *list.List
+	addr           string
+	maxConns       int
+	count          int
+	connectTimeout time.Duration
+	networkTimeout time.Duration
+	lock           *sync.RWMutex
+	// Signaled when a connection is put back or discarded, tasks waiting for the full pool wake up by it
+	released *sync.Cond
 	finish   chan bool
 }
 
-func newConnPool(addr string, maxConns int) (*connPool, error) {
+func newConnPool(addr string, maxConns int, connectTimeout time.Duration, networkTimeout time.Duration) (*connPool, error) {
 	if maxConns < MAXCONNS_LEAST {
 		return nil, fmt.Errorf("too little maxConns < %d", MAXCONNS_LEAST)
 	}
 	connPool := &connPool{
-		conns:    list.New(),
-		addr:     addr,
-		maxConns: maxConns,
-		lock:     &sync.RWMutex{},
-		finish:   make(chan bool),
+		conns:          list.New(),
+		addr:           addr,
+		maxConns:       maxConns,
+		connectTimeout: connectTimeout,
+		networkTimeout: networkTimeout,
+		lock:           &sync.RWMutex{},
+		finish:         make(chan bool),
+	}
+	connPool.released = sync.NewCond(connPool.lock)
+	connPool.lock.Lock()
+	defer connPool.lock.Unlock()
+	for i := 0; i < MAXCONNS_LEAST; i++ {
+		if err := connPool.makeConn(); err != nil {
+			connPool.closeConns()
+			return nil, err
+		}
 	}
 	go func() {
 		timer := time.NewTimer(time.Second * 20)
@@ -55,13 +92,6 @@ func newConnPool(addr string, maxConns int) (*connPool, error) {
 			}
 		}
 	}()
-	connPool.lock.Lock()
-	defer connPool.lock.Unlock()
-	for i := 0; i < MAXCONNS_LEAST; i++ {
-		if err := connPool.makeConn(); err != nil {
-			return nil, err
-		}
-	}
 	return connPool, nil
 }
 
@@ -70,6 +100,21 @@ func (this *connPool) Destory() {
 		return
 	}
 	this.finish <- true
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.closeConns()
+}
+
+// Close idle connections, the connections in use are closed when they are put back
+func (this *connPool) closeConns() {
+	for e := this.conns.Front(); e != nil; e = this.conns.Front() {
+		this.conns.Remove(e)
+		e.Value.(pConn).Conn.Close()
+		this.count--
+	}
+	this.maxConns = 0
+	this.released.Broadcast()
 }
 
 func (this *connPool) CheckConns() error {
@@ -79,20 +124,8 @@ func (this *connPool) CheckConns() error {
 	for e := this.conns.Front(); e != nil; e = next {
 		next = e.Next()
 		conn := e.Value.(pConn)
-		header := &header{
-			cmd: FDFS_PROTO_CMD_ACTIVE_TEST,
-		}
-		if err := header.SendHeader(conn.Conn); err != nil {
-			this.conns.Remove(e)
-			this.count--
-			continue
-		}
-		if err := header.RecvHeader(conn.Conn); err != nil {
-			this.conns.Remove(e)
-			this.count--
-			continue
-		}
-		if header.cmd != TRACKER_PROTO_CMD_RESP || header.status != 0 {
+		if err := activeTest(conn.Conn, this.networkTimeout); err != nil {
+			conn.Conn.Close()
 			this.conns.Remove(e)
 			this.count--
 			continue
@@ -101,8 +134,30 @@ func (this *connPool) CheckConns() error {
 	return nil
 }
 
+// Check if the tracker or storage is alive by the connection
+func activeTest(conn net.Conn, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	header := &header{
+		cmd: FDFS_PROTO_CMD_ACTIVE_TEST,
+	}
+	if err := header.SendHeader(conn); err != nil {
+		return err
+	}
+	if err := header.RecvHeader(conn); err != nil {
+		return err
+	}
+	if header.cmd != TRACKER_PROTO_CMD_RESP || header.status != 0 {
+		return fmt.Errorf("active test cmd %d status %d", header.cmd, header.status)
+	}
+	return nil
+}
+
 func (this *connPool) makeConn() error {
-	conn, err := net.DialTimeout("tcp", this.addr, time.Second*10)
+	conn, err := net.DialTimeout("tcp", this.addr, this.connectTimeout)
 	if err != nil {
 		return err
 	}
@@ -114,14 +169,32 @@ func (this *connPool) makeConn() error {
 	return nil
 }
 
+// Wait for a connection to be put back if the pool is full, the wait is bounded by the network timeout
 func (this *connPool) get() (net.Conn, error) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
+	deadline := time.Now().Add(this.networkTimeout)
 	for {
 		e := this.conns.Front()
 		if e == nil {
+			if this.maxConns == 0 {
+				return nil, fmt.Errorf("the pool of %s is destroyed", this.addr)
+			}
+
 			if this.count >= this.maxConns {
-				return nil, fmt.Errorf("reach maxConns %d", this.maxConns)
+				waitTime := time.Until(deadline)
+				if waitTime <= 0 {
+					return nil, &poolBusyError{addr: this.addr, maxConns: this.maxConns}
+				}
+
+				timer := time.AfterFunc(waitTime, func() {
+					this.lock.Lock()
+					this.released.Broadcast()
+					this.lock.Unlock()
+				})
+				this.released.Wait()
+				timer.Stop()
+				continue
 			}
 
 			err := this.makeConn()
@@ -136,9 +209,23 @@ func (this *connPool) get() (net.Conn, error) {
 	}
 }
 
+func (this *connPool) discard(pConn pConn) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.count--
+	this.released.Signal()
+	return pConn.Conn.Close()
+}
+
 func (this *connPool) put(pConn pConn) error {
 	this.lock.Lock()
 	defer this.lock.Unlock()
+	// The pool has been destoryed
+	if this.maxConns == 0 {
+		this.count--
+		return pConn.Conn.Close()
+	}
 	pConn.pool.conns.PushBack(pConn)
+	this.released.Signal()
 	return nil
 }
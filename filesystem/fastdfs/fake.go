@@ -0,0 +1,283 @@
+package fastdfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"karst/logger"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	fakeStatusNotFound     = 2  // ENOENT
+	fakeStatusInvalid      = 22 // EINVAL
+	fakeIpAddrMaxLen       = 15
+	fakeFileExtNameMaxLen  = 6
+	fakeMaxReqPkgLen       = 256 * (1 << 20) // 256 MB
+	fakeDefaultStoragePath = 0
+)
+
+// FakeServer is an in-process fastdfs tracker and storage on the same address, it speaks the commands
+// used by karst (101, 102, 11, 12, 14 and 111) and keeps files in memory, so that fastdfs can be tested
+// without a real cluster
+type FakeServer struct {
+	lock      sync.Mutex
+	groupName string
+	listener  net.Listener
+	ipAddr    string
+	port      int64
+	files     map[string][]byte
+	conns     map[net.Conn]bool
+}
+
+// Create fake server on the listener, the address of listener is returned as the storage address by tracker
+func NewFakeServer(listener net.Listener, groupName string) (*FakeServer, error) {
+	tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("the listener of fake server must be tcp")
+	}
+
+	ipAddr := tcpAddr.IP.String()
+	if tcpAddr.IP.IsUnspecified() {
+		ipAddr = "127.0.0.1"
+	}
+	if len(ipAddr) > fakeIpAddrMaxLen {
+		return nil, fmt.Errorf("the ip address '%s' is too long for fastdfs", ipAddr)
+	}
+
+	if groupName == "" || len(groupName) > FDFS_GROUP_NAME_MAX_LEN {
+		return nil, fmt.Errorf("wrong group name '%s'", groupName)
+	}
+
+	return &FakeServer{
+		groupName: groupName,
+		listener:  listener,
+		ipAddr:    ipAddr,
+		port:      int64(tcpAddr.Port),
+		files:     make(map[string][]byte),
+		conns:     make(map[net.Conn]bool),
+	}, nil
+}
+
+// Accept connections until the listener is closed
+func (this *FakeServer) Serve() error {
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		this.lock.Lock()
+		this.conns[conn] = true
+		this.lock.Unlock()
+		go this.serveConn(conn)
+	}
+}
+
+// Close the listener and all connections, like the server goes down
+func (this *FakeServer) Close() error {
+	err := this.listener.Close()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for conn := range this.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// The address for clients to use as tracker address
+func (this *FakeServer) Addr() string {
+	return this.ipAddr + ":" + strconv.FormatInt(this.port, 10)
+}
+
+// Get sorted file ids in the fake server
+func (this *FakeServer) FileIds() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fileIds := make([]string, 0, len(this.files))
+	for remoteFilename := range this.files {
+		fileIds = append(fileIds, this.groupName+"/"+remoteFilename)
+	}
+	sort.Strings(fileIds)
+	return fileIds
+}
+
+func (this *FakeServer) serveConn(conn net.Conn) {
+	defer func() {
+		this.lock.Lock()
+		delete(this.conns, conn)
+		this.lock.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		reqHeader := &header{}
+		headerBytes := make([]byte, 10)
+		if _, err := io.ReadFull(conn, headerBytes); err != nil {
+			return
+		}
+		reqHeader.pkgLen = int64(binary.BigEndian.Uint64(headerBytes[0:8]))
+		reqHeader.cmd = int8(headerBytes[8])
+
+		if reqHeader.pkgLen < 0 || reqHeader.pkgLen > fakeMaxReqPkgLen {
+			logger.Debug("(FakeFastdfs) Wrong pkgLen %d", reqHeader.pkgLen)
+			return
+		}
+
+		body := make([]byte, reqHeader.pkgLen)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+
+		logger.Debug("(FakeFastdfs) Cmd %d, pkgLen %d", reqHeader.cmd, reqHeader.pkgLen)
+		respBody, status := this.handle(reqHeader.cmd, body)
+		if err := writeFakeResponse(conn, respBody, status); err != nil {
+			return
+		}
+	}
+}
+
+// Return the response body and status of the request
+func (this *FakeServer) handle(cmd int8, body []byte) ([]byte, int8) {
+	switch cmd {
+	case FDFS_PROTO_CMD_ACTIVE_TEST:
+		return nil, 0
+	case TRACKER_PROTO_CMD_SERVICE_QUERY_STORE_WITHOUT_GROUP_ONE:
+		respBuffer := this.getStorageInfoBuffer()
+		respBuffer.WriteByte(byte(fakeDefaultStoragePath))
+		return respBuffer.Bytes(), 0
+	case TRACKER_PROTO_CMD_SERVICE_QUERY_FETCH_ONE:
+		if _, status := this.getFile(body); status != 0 {
+			return nil, status
+		}
+		return this.getStorageInfoBuffer().Bytes(), 0
+	case STORAGE_PROTO_CMD_UPLOAD_FILE:
+		// store path index (1) + file size (8) + file ext name (6) + file content
+		if len(body) < 1+8+fakeFileExtNameMaxLen {
+			return nil, fakeStatusInvalid
+		}
+		fileSize := int64(binary.BigEndian.Uint64(body[1:9]))
+		fileExtName := string(bytes.TrimRight(body[9:9+fakeFileExtNameMaxLen], "\x00"))
+		fileContent := body[9+fakeFileExtNameMaxLen:]
+		if fileSize != int64(len(fileContent)) {
+			return nil, fakeStatusInvalid
+		}
+
+		randBytes := make([]byte, 16)
+		if _, err := rand.Read(randBytes); err != nil {
+			return nil, fakeStatusInvalid
+		}
+		remoteFilename := "M00/00/00/" + hex.EncodeToString(randBytes)
+		if fileExtName != "" {
+			remoteFilename = remoteFilename + "." + fileExtName
+		}
+
+		this.lock.Lock()
+		this.files[remoteFilename] = fileContent
+		this.lock.Unlock()
+
+		respBuffer := new(bytes.Buffer)
+		respBuffer.Write(getFakeFixedBytes(this.groupName, FDFS_GROUP_NAME_MAX_LEN))
+		respBuffer.WriteString(remoteFilename)
+		return respBuffer.Bytes(), 0
+	case STORAGE_PROTO_CMD_DELETE_FILE:
+		remoteFilename, status := this.getFile(body)
+		if status != 0 {
+			return nil, status
+		}
+
+		this.lock.Lock()
+		delete(this.files, remoteFilename)
+		this.lock.Unlock()
+		return nil, 0
+	case STORAGE_PROTO_CMD_DOWNLOAD_FILE:
+		// offset (8) + download bytes (8) + group name + remote filename
+		if len(body) < 16 {
+			return nil, fakeStatusInvalid
+		}
+		offset := int64(binary.BigEndian.Uint64(body[0:8]))
+		downloadBytes := int64(binary.BigEndian.Uint64(body[8:16]))
+
+		remoteFilename, status := this.getFile(body[16:])
+		if status != 0 {
+			return nil, status
+		}
+
+		this.lock.Lock()
+		fileContent := this.files[remoteFilename]
+		this.lock.Unlock()
+
+		if offset < 0 || offset > int64(len(fileContent)) || downloadBytes < 0 {
+			return nil, fakeStatusInvalid
+		}
+		end := int64(len(fileContent))
+		if downloadBytes > 0 && offset+downloadBytes < end {
+			end = offset + downloadBytes
+		}
+		return fileContent[offset:end], 0
+	default:
+		logger.Debug("(FakeFastdfs) Unsupported cmd %d", cmd)
+		return nil, fakeStatusInvalid
+	}
+}
+
+// Group name (16) + ip address (15) + port (8)
+func (this *FakeServer) getStorageInfoBuffer() *bytes.Buffer {
+	respBuffer := new(bytes.Buffer)
+	respBuffer.Write(getFakeFixedBytes(this.groupName, FDFS_GROUP_NAME_MAX_LEN))
+	respBuffer.Write(getFakeFixedBytes(this.ipAddr, fakeIpAddrMaxLen))
+	_ = binary.Write(respBuffer, binary.BigEndian, this.port)
+	return respBuffer
+}
+
+// Parse the group name (16) + remote filename in body and check if the file exists
+func (this *FakeServer) getFile(body []byte) (string, int8) {
+	if len(body) <= FDFS_GROUP_NAME_MAX_LEN {
+		return "", fakeStatusInvalid
+	}
+
+	groupName := string(bytes.TrimRight(body[:FDFS_GROUP_NAME_MAX_LEN], "\x00"))
+	remoteFilename := string(body[FDFS_GROUP_NAME_MAX_LEN:])
+	if groupName != this.groupName {
+		return "", fakeStatusNotFound
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.files[remoteFilename]; !ok {
+		return "", fakeStatusNotFound
+	}
+	return remoteFilename, 0
+}
+
+func getFakeFixedBytes(s string, size int) []byte {
+	fixedBytes := make([]byte, size)
+	copy(fixedBytes, s)
+	return fixedBytes
+}
+
+// The response of error status has no body
+func writeFakeResponse(conn net.Conn, body []byte, status int8) error {
+	if status != 0 {
+		body = nil
+	}
+
+	respHeader := &header{
+		pkgLen: int64(len(body)),
+		cmd:    STORAGE_PROTO_CMD_RESP,
+		status: status,
+	}
+	if err := respHeader.SendHeader(conn); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(conn, bytes.NewReader(body))
+	return err
+}
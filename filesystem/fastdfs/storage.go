@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"os"
 )
@@ -43,9 +44,11 @@ func (this *storageUploadTask) SendReq(conn net.Conn) error {
 	}
 
 	var err error
-	//send file
+	//send file, exactly 'fileSize' bytes are sent to keep the framing
 	if this.fileInfo.file != nil {
-		_, err = conn.(pConn).Conn.(*net.TCPConn).ReadFrom(this.fileInfo.file)
+		_, err = io.CopyN(conn, this.fileInfo.file, this.fileInfo.fileSize)
+	} else if this.fileInfo.reader != nil {
+		_, err = io.CopyN(conn, this.fileInfo.reader, this.fileInfo.fileSize)
 	} else {
 		_, err = conn.Write(this.fileInfo.buffer)
 	}
@@ -69,7 +72,7 @@ func (this *storageUploadTask) RecvRes(conn net.Conn) error {
 	}
 
 	buf := make([]byte, this.pkgLen)
-	if _, err := conn.Read(buf); err != nil {
+	if _, err := io.ReadFull(conn, buf); err != nil {
 		return err
 	}
 
@@ -97,6 +100,7 @@ type storageDownloadTask struct {
 	//res
 	localFilename string
 	buffer        []byte
+	writer        io.Writer
 }
 
 func (this *storageDownloadTask) SendReq(conn net.Conn) error {
@@ -129,12 +133,19 @@ func (this *storageDownloadTask) SendReq(conn net.Conn) error {
 
 func (this *storageDownloadTask) RecvRes(conn net.Conn) error {
 	if err := this.RecvHeader(conn); err != nil {
-		return fmt.Errorf("StorageDownloadTask RecvRes %v", err)
+		return fmt.Errorf("StorageDownloadTask RecvRes %w", err)
+	}
+	if this.downloadBytes > 0 && this.pkgLen > this.downloadBytes {
+		return fmt.Errorf("StorageDownloadTask RecvRes pkgLen %d > downloadBytes %d", this.pkgLen, this.downloadBytes)
 	}
 	if this.localFilename != "" {
 		if err := this.recvFile(conn); err != nil {
 			return fmt.Errorf("StorageDownloadTask RecvRes %v", err)
 		}
+	} else if this.writer != nil {
+		if err := writeFromConn(conn, this.writer, this.pkgLen); err != nil {
+			return fmt.Errorf("StorageDownloadTask RecvRes %v", err)
+		}
 	} else {
 		if err := this.recvBuffer(conn); err != nil {
 			return fmt.Errorf("StorageDownloadTask RecvRes %v", err)
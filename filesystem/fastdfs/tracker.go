@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 )
 
@@ -43,13 +44,13 @@ func (this *trackerTask) SendReq(conn net.Conn) error {
 
 func (this *trackerTask) RecvRes(conn net.Conn) error {
 	if err := this.RecvHeader(conn); err != nil {
-		return fmt.Errorf("TrackerTask RecvHeader %v", err)
+		return fmt.Errorf("TrackerTask RecvHeader %w", err)
 	}
 	if this.pkgLen != 39 && this.pkgLen != 40 {
 		return fmt.Errorf("recvStorageInfo pkgLen %d invaild", this.pkgLen)
 	}
 	buf := make([]byte, this.pkgLen)
-	if _, err := conn.Read(buf); err != nil {
+	if _, err := io.ReadFull(conn, buf); err != nil {
 		return err
 	}
 
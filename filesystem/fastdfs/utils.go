@@ -2,12 +2,13 @@ package fastdfs
 
 import (
 	"bytes"
+	"io"
 	"net"
 )
 
 func readCStrFromByteBuffer(buffer *bytes.Buffer, size int) (string, error) {
 	buf := make([]byte, size)
-	if _, err := buffer.Read(buf); err != nil {
+	if _, err := io.ReadFull(buffer, buf); err != nil {
 		return "", err
 	}
 
@@ -25,53 +26,15 @@ type writer interface {
 }
 
 func writeFromConnToBuffer(conn net.Conn, buffer []byte, size int64) error {
-	var (
-		err      error
-		recv     int
-		needRecv int64
-	)
-	sizeRecv, sizeAll := int64(0), size
-
-	for {
-		needRecv = sizeAll - sizeRecv
-		if needRecv <= 0 {
-			break
-		}
-		recv, err = conn.Read(buffer[sizeRecv : sizeRecv+needRecv])
-		if err != nil {
-			return err
-		}
-		sizeRecv += int64(recv)
-	}
-	return nil
+	_, err := io.ReadFull(conn, buffer[:size])
+	return err
 }
 
+// Exactly 'size' bytes are read from conn, io.ErrUnexpectedEOF is returned if conn is closed before
 func writeFromConn(conn net.Conn, writer writer, size int64) error {
-	var (
-		err      error
-		recv     int
-		needRecv int64
-	)
-	sizeRecv, sizeAll := int64(0), size
-	buf := make([]byte, 4096)
-
-	for {
-		needRecv = sizeAll - sizeRecv
-		if needRecv <= 0 {
-			break
-		}
-		if needRecv > 4096 {
-			needRecv = 4096
-		}
-		recv, err = conn.Read(buf[:needRecv])
-		if err != nil {
-			return err
-		}
-		_, err = writer.Write(buf[:recv])
-		if err != nil {
-			return err
-		}
-		sizeRecv += int64(recv)
+	n, err := io.CopyN(writer, conn, size)
+	if err == io.EOF && n < size {
+		return io.ErrUnexpectedEOF
 	}
-	return nil
+	return err
 }
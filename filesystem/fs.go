@@ -1,9 +1,14 @@
 package filesystem
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"karst/config"
 	"karst/merkletree"
+	"os"
+	"strings"
 )
 
 type FsInterface interface {
@@ -13,17 +18,48 @@ type FsInterface interface {
 	Delete(key string) error
 
 	GetToBuffer(key string, size uint64) ([]byte, error)
+
+	// Put 'size' bytes from reader, the data is streamed into fs without temp files
+	PutReader(reader io.Reader, size uint64) (string, error)
+	// Get the reader of the whole part, the caller must close it
+	GetReader(key string) (io.ReadCloser, error)
+	// Get the reader of 'length' bytes from 'offset', the rest of part is read if 'length' is 0
+	GetRangeReader(key string, offset uint64, length uint64) (io.ReadCloser, error)
+
+	// Check if fs can be reached and used, it is called by health checks
+	Check() error
+}
+
+// FileFs is implemented by fs which keeps parts as files on the disk of karst, so parts can be linked out of it and
+// files can be moved into it without copies when they are on the same disk
+type FileFs interface {
+	// Link the part to the file in 'path'
+	LinkPart(key string, path string) error
+	// Move the file of 'size' bytes in 'path' into fs, the file is gone after it is moved
+	MovePart(path string, size uint64) (string, error)
 }
 
+// The fs of merchant is instrumented for prometheus
 func GetFs(cfg *config.Configuration) (FsInterface, error) {
+	var fs FsInterface
+	var err error
 	switch cfg.Fs.FsFlag {
 	case config.FASTDFS_FLAG:
-		return OpenFastdfs(cfg)
+		fs, err = OpenFastdfs(cfg)
 	case config.IPFS_FLAG:
-		return OpenIpfs(cfg)
+		fs, err = OpenIpfs(cfg)
+	case config.LOCAL_FLAG:
+		fs, err = OpenLocal(cfg)
+	case config.S3_FLAG:
+		fs, err = OpenS3(cfg)
 	default:
 		return nil, fmt.Errorf("No fs configuration")
 	}
+
+	if err != nil {
+		return nil, err
+	}
+	return newMetricsFs(fs, cfg.Fs.FsFlag), nil
 }
 
 func DeleteMerkletreeFile(fs FsInterface, mt *merkletree.MerkleTreeNode) error {
@@ -31,11 +67,163 @@ func DeleteMerkletreeFile(fs FsInterface, mt *merkletree.MerkleTreeNode) error {
 		return fmt.Errorf("'MerkleTree' is nil")
 	}
 
-	for i := range mt.Links {
-		err := fs.Delete(mt.Links[i].StoredKey)
+	for _, leaf := range mt.Leaves() {
+		err := fs.Delete(leaf.StoredKey)
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// Get the part into the file in 'path', sworker reads parts from files, the part is linked if fs keeps it as a file
+// on the same disk, otherwise it is streamed into the file
+func GetPartToFile(fs FsInterface, key string, path string, size uint64) error {
+	if fileFs, ok := fs.(FileFs); ok {
+		if err := fileFs.LinkPart(key, path); err == nil {
+			stat, err := os.Stat(path)
+			if err == nil && uint64(stat.Size()) == size {
+				return nil
+			}
+			os.Remove(path)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("The size of part '%s' is %d, not %d", key, stat.Size(), size)
+		}
+	}
+
+	partReader, err := fs.GetReader(key)
+	if err != nil {
+		return err
+	}
+	defer partReader.Close()
+
+	partFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(partFile, partReader)
+	if err != nil {
+		partFile.Close()
+		return err
+	}
+
+	if uint64(n) != size {
+		partFile.Close()
+		return fmt.Errorf("The size of part '%s' is %d, not %d", key, n, size)
+	}
+	return partFile.Close()
+}
+
+// Put the file in 'path' written by sworker into fs, the file is moved if fs keeps parts as files on the same disk,
+// otherwise it is streamed into fs
+func PutPartFromFile(fs FsInterface, path string, size uint64) (string, error) {
+	if fileFs, ok := fs.(FileFs); ok {
+		if key, err := fileFs.MovePart(path, size); err == nil {
+			return key, nil
+		}
+	}
+
+	partFile, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer partFile.Close()
+	return fs.PutReader(partFile, size)
+}
+
+// sizedReader returns an error if the data ends before 'size' bytes, and never reads more than 'size' bytes
+type sizedReader struct {
+	reader io.Reader
+	left   uint64
+}
+
+func newSizedReader(reader io.Reader, size uint64) *sizedReader {
+	return &sizedReader{reader: reader, left: size}
+}
+
+func (sr *sizedReader) Read(p []byte) (int, error) {
+	if sr.left == 0 {
+		return 0, io.EOF
+	}
+
+	if uint64(len(p)) > sr.left {
+		p = p[:sr.left]
+	}
+
+	n, err := sr.reader.Read(p)
+	sr.left = sr.left - uint64(n)
+	if err == io.EOF && sr.left != 0 {
+		// Not io.ErrUnexpectedEOF, which is treated as the normal end by io.ReadFull callers
+		err = fmt.Errorf("The data ends %d bytes before its size", sr.left)
+	}
+	return n, err
+}
+
+// The reader which is limited to a range of part, closing it closes the underlying part
+type rangeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func newRangeReadCloser(readCloser io.ReadCloser, length uint64) io.ReadCloser {
+	if length == 0 {
+		return readCloser
+	}
+	return &rangeReadCloser{
+		Reader: io.LimitReader(readCloser, int64(length)),
+		Closer: readCloser,
+	}
+}
+
+const (
+	remoteFastdfsMaxConns = 10
+)
+
+type RemoteAddress struct {
+	Fastdfs string
+	Ipfs    string
+	Local   string
+}
+
+// Open the fs of other node (merchant) by its outer addresses, 'tlsConfig' is used by https addresses
+// and requests to local fs are signed by 'localSigner'
+func OpenRemoteFs(address RemoteAddress, tlsConfig *tls.Config, localSigner RemoteLocalSigner) (FsInterface, error) {
+	remoteCfg := &config.Configuration{}
+
+	switch {
+	case address.Fastdfs != "":
+		remoteCfg.Fs.FsFlag = config.FASTDFS_FLAG
+		// Trackers are separated by ','
+		for _, trackerAddr := range strings.Split(address.Fastdfs, ",") {
+			if trackerAddr = strings.TrimSpace(trackerAddr); trackerAddr != "" {
+				remoteCfg.Fs.Fastdfs.TrackerAddrs = append(remoteCfg.Fs.Fastdfs.TrackerAddrs, trackerAddr)
+			}
+		}
+		remoteCfg.Fs.Fastdfs.MaxConns = remoteFastdfsMaxConns
+		return OpenFastdfs(remoteCfg)
+	case address.Ipfs != "":
+		remoteCfg.Fs.FsFlag = config.IPFS_FLAG
+		remoteCfg.Fs.Ipfs.BaseUrl = address.Ipfs
+		return OpenIpfs(remoteCfg)
+	case address.Local != "":
+		return OpenRemoteLocal(address.Local, tlsConfig, localSigner)
+	default:
+		return nil, fmt.Errorf("No outer fs address")
+	}
+}
+
+// Read the part of 'size' bytes from remote fs, at most 'size' + 1 bytes are read, so a wrong part can't exhaust the memory
+func readPartToBuffer(partReader io.Reader, key string, size uint64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(partReader, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("The size of part '%s' is wrong, %d bytes are read, expected %d", key, len(data), size)
+	}
+	return data, nil
+}
@@ -1,7 +1,9 @@
 package filesystem
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"karst/config"
 	"os"
@@ -25,6 +27,13 @@ func (this *Ipfs) Close() {
 
 }
 
+func (this *Ipfs) Check() error {
+	if !this.sh.IsUp() {
+		return fmt.Errorf("Ipfs dosen't respond")
+	}
+	return nil
+}
+
 func (this *Ipfs) Put(fileName string) (string, error) {
 	f, err := os.Open(fileName)
 	if err != nil {
@@ -50,3 +59,28 @@ func (this *Ipfs) GetToBuffer(key string, size uint64) ([]byte, error) {
 	defer dataReader.Close()
 	return ioutil.ReadAll(dataReader)
 }
+
+func (this *Ipfs) PutReader(reader io.Reader, size uint64) (string, error) {
+	return this.sh.Add(newSizedReader(reader, size))
+}
+
+func (this *Ipfs) GetReader(key string) (io.ReadCloser, error) {
+	return this.sh.Cat(key)
+}
+
+func (this *Ipfs) GetRangeReader(key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	req := this.sh.Request("cat", key).Option("offset", offset)
+	if length != 0 {
+		req = req.Option("length", length)
+	}
+
+	resp, err := req.Send(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		resp.Close()
+		return nil, resp.Error
+	}
+	return resp.Output, nil
+}
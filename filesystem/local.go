@@ -0,0 +1,539 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"karst/config"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	localTmpDir             = ".tmp"
+	localRefsSuffix         = ".refs"
+	remoteLocalTimeout      = 10 * time.Minute
+	remoteLocalErrorSize    = 1024
+	remoteLocalCheckTimeout = 10 * time.Second
+)
+
+// Local stores file parts in the directory by their sha256 hashes, like 'path/ab/cd/abcd...', the same part is
+// stored once and its reference count is kept in the '.refs' file beside it
+type Local struct {
+	basePath string
+	lock     sync.Mutex
+}
+
+func OpenLocal(cfg *config.Configuration) (*Local, error) {
+	basePath, err := filepath.Abs(cfg.Fs.Local.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files in tmp directory are half-written parts
+	tmpPath := filepath.Join(basePath, localTmpDir)
+	if err = os.RemoveAll(tmpPath); err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(tmpPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &Local{basePath: basePath}, nil
+}
+
+func (this *Local) Close() {
+
+}
+
+// Parts are written into tmp directory first, so it must be there
+func (this *Local) Check() error {
+	stat, err := os.Stat(filepath.Join(this.basePath, localTmpDir))
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("'%s' isn't a directory", stat.Name())
+	}
+	return nil
+}
+
+func (this *Local) Put(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return this.PutReader(f, uint64(stat.Size()))
+}
+
+// Write data into tmp directory and rename it to its hash after fsync, so a part is never half-written
+func (this *Local) PutReader(reader io.Reader, size uint64) (string, error) {
+	tmpFile, err := ioutil.TempFile(filepath.Join(this.basePath, localTmpDir), "part_")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	hasher := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(tmpFile, hasher), newSizedReader(reader, size)); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return "", err
+	}
+
+	key := hex.EncodeToString(hasher.Sum(nil))
+	partPath := this.getPath(key)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if err = os.MkdirAll(filepath.Dir(partPath), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	refs, err := this.getRefs(key)
+	if err != nil {
+		return "", err
+	}
+
+	if refs == 0 {
+		if err = os.Rename(tmpFile.Name(), partPath); err != nil {
+			return "", err
+		}
+	}
+
+	if err = this.setRefs(key, refs+1); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Link the part without copies, it fails if 'path' is on another disk. The part is shared with the link,
+// so the linked file must be only read
+func (this *Local) LinkPart(key string, path string) error {
+	if !isLocalKey(key) {
+		return fmt.Errorf("Illegal key '%s'", key)
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return os.Link(this.getPath(key), path)
+}
+
+// Hash the file in place and rename it to its hash, it fails without changing the file if 'path' is on another disk
+func (this *Local) MovePart(path string, size uint64) (string, error) {
+	partFile, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, partFile)
+	if err == nil {
+		err = partFile.Sync()
+	}
+	partFile.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if uint64(n) != size {
+		return "", fmt.Errorf("The size of '%s' is %d, not %d", path, n, size)
+	}
+
+	key := hex.EncodeToString(hasher.Sum(nil))
+	partPath := this.getPath(key)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if err = os.MkdirAll(filepath.Dir(partPath), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	refs, err := this.getRefs(key)
+	if err != nil {
+		return "", err
+	}
+
+	if refs == 0 {
+		if err = os.Rename(path, partPath); err != nil {
+			return "", err
+		}
+	} else {
+		os.Remove(path)
+	}
+
+	if err = this.setRefs(key, refs+1); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+func (this *Local) Get(key string, outFileName string) error {
+	if !isLocalKey(key) {
+		return fmt.Errorf("Illegal key '%s'", key)
+	}
+
+	partFile, err := os.Open(this.getPath(key))
+	if err != nil {
+		return err
+	}
+	defer partFile.Close()
+
+	outFile, err := os.Create(outFileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(outFile, partFile); err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
+
+func (this *Local) GetReader(key string) (io.ReadCloser, error) {
+	return this.GetRangeReader(key, 0, 0)
+}
+
+func (this *Local) GetRangeReader(key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	if !isLocalKey(key) {
+		return nil, fmt.Errorf("Illegal key '%s'", key)
+	}
+
+	partFile, err := os.Open(this.getPath(key))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = partFile.Seek(int64(offset), io.SeekStart); err != nil {
+		partFile.Close()
+		return nil, err
+	}
+	return newRangeReadCloser(partFile, length), nil
+}
+
+// Decrease the reference count of the part, the part is removed when no one refers to it
+func (this *Local) Delete(key string) error {
+	if !isLocalKey(key) {
+		return fmt.Errorf("Illegal key '%s'", key)
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	refs, err := this.getRefs(key)
+	if err != nil {
+		return err
+	}
+
+	if refs == 0 {
+		return fmt.Errorf("Part '%s' doesn't exist", key)
+	}
+
+	if refs > 1 {
+		return this.setRefs(key, refs-1)
+	}
+
+	partPath := this.getPath(key)
+	if err = os.Remove(partPath); err != nil {
+		return err
+	}
+	if err = os.Remove(partPath + localRefsSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(filepath.Dir(partPath))
+}
+
+func (this *Local) GetToBuffer(key string, size uint64) ([]byte, error) {
+	if !isLocalKey(key) {
+		return nil, fmt.Errorf("Illegal key '%s'", key)
+	}
+
+	data, err := ioutil.ReadFile(this.getPath(key))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("The size of part '%s' is %d, not %d", key, len(data), size)
+	}
+	return data, nil
+}
+
+func (this *Local) getPath(key string) string {
+	return filepath.Join(this.basePath, key[0:2], key[2:4], key)
+}
+
+// The part without '.refs' file is referred once, 0 means the part doesn't exist
+func (this *Local) getRefs(key string) (uint64, error) {
+	partPath := this.getPath(key)
+	if _, err := os.Stat(partPath); err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	refsBytes, err := ioutil.ReadFile(partPath + localRefsSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 1, nil
+		}
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(refsBytes)), 10, 64)
+}
+
+func (this *Local) setRefs(key string, refs uint64) error {
+	partPath := this.getPath(key)
+	tmpFile, err := ioutil.TempFile(filepath.Join(this.basePath, localTmpDir), "refs_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = tmpFile.WriteString(strconv.FormatUint(refs, 10)); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpFile.Name(), partPath+localRefsSuffix); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(partPath))
+}
+
+// Make renames in the directory durable
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func isLocalKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// RemoteLocal is the local fs of other node (merchant), which is served by http
+// RemoteLocalSigner signs the request to local fs served by karst, 'key' is the part to get and 'size' is the size of part to put
+type RemoteLocalSigner func(req *http.Request, key string, size uint64) error
+
+type RemoteLocal struct {
+	baseUrl string
+	client  *http.Client
+	signer  RemoteLocalSigner
+}
+
+// Requests aren't signed if 'signer' is nil
+func OpenRemoteLocal(baseUrl string, tlsConfig *tls.Config, signer RemoteLocalSigner) (*RemoteLocal, error) {
+	if _, err := url.Parse(baseUrl); err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: remoteLocalTimeout,
+	}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}
+	}
+
+	return &RemoteLocal{
+		baseUrl: baseUrl,
+		client:  client,
+		signer:  signer,
+	}, nil
+}
+
+func (this *RemoteLocal) Close() {
+
+}
+
+// Any response means that karst of the merchant is reachable
+func (this *RemoteLocal) Check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteLocalCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodHead, this.baseUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := this.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (this *RemoteLocal) Put(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return this.PutReader(f, uint64(stat.Size()))
+}
+
+func (this *RemoteLocal) PutReader(reader io.Reader, size uint64) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, this.baseUrl, newSizedReader(reader, size))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = int64(size)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if err = this.sign(req, "", size); err != nil {
+		return "", err
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err = checkRemoteLocalResponse(resp); err != nil {
+		return "", err
+	}
+
+	keyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(keyBytes)), nil
+}
+
+func (this *RemoteLocal) Get(key string, outFileName string) error {
+	partReader, err := this.GetReader(key)
+	if err != nil {
+		return err
+	}
+	defer partReader.Close()
+
+	outFile, err := os.Create(outFileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(outFile, partReader); err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
+
+func (this *RemoteLocal) Delete(key string) error {
+	return fmt.Errorf("Can't delete '%s' from remote local fs", key)
+}
+
+func (this *RemoteLocal) GetToBuffer(key string, size uint64) ([]byte, error) {
+	partReader, err := this.GetReader(key)
+	if err != nil {
+		return nil, err
+	}
+	defer partReader.Close()
+	return readPartToBuffer(partReader, key, size)
+}
+
+func (this *RemoteLocal) GetReader(key string) (io.ReadCloser, error) {
+	return this.GetRangeReader(key, 0, 0)
+}
+
+// The range is passed by 'offset' and 'length' parameters, which are handled by karst of the merchant
+func (this *RemoteLocal) GetRangeReader(key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	query := url.Values{"key": []string{key}}
+	if offset != 0 {
+		query.Set("offset", strconv.FormatUint(offset, 10))
+	}
+	if length != 0 {
+		query.Set("length", strconv.FormatUint(length, 10))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, this.baseUrl+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = this.sign(req, key, 0); err != nil {
+		return nil, err
+	}
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkRemoteLocalResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (this *RemoteLocal) sign(req *http.Request, key string, size uint64) error {
+	if this.signer == nil {
+		return nil
+	}
+	return this.signer(req, key, size)
+}
+
+func checkRemoteLocalResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	errBytes, _ := ioutil.ReadAll(io.LimitReader(resp.Body, remoteLocalErrorSize))
+	return fmt.Errorf("Error code is: %d, %s", resp.StatusCode, strings.TrimSpace(string(errBytes)))
+}
@@ -0,0 +1,132 @@
+package filesystem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"karst/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestLocal(t *testing.T) (FsInterface, string) {
+	dir, err := ioutil.TempDir("", "karst-fs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Configuration{}
+	cfg.Fs.FsFlag = config.LOCAL_FLAG
+	cfg.Fs.Local.Path = filepath.Join(dir, "fs")
+	fs, err := GetFs(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return fs, dir
+}
+
+func TestLocalPartsWithoutCopies(t *testing.T) {
+	fs, dir := newTestLocal(t)
+	defer os.RemoveAll(dir)
+	defer fs.Close()
+
+	part := bytes.Repeat([]byte("karst"), 100)
+	partPath := filepath.Join(dir, "part")
+	if err := ioutil.WriteFile(partPath, part, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is moved into fs
+	key, err := PutPartFromFile(fs, partPath, uint64(len(part)))
+	if err != nil {
+		t.Fatalf("Put part from file failed: %s", err)
+	}
+	if _, err = os.Stat(partPath); !os.IsNotExist(err) {
+		t.Fatal("The part file is still there after it is moved into fs")
+	}
+
+	// The part is linked out of fs
+	linkPath := filepath.Join(dir, "link")
+	if err = GetPartToFile(fs, key, linkPath, uint64(len(part))); err != nil {
+		t.Fatalf("Get part to file failed: %s", err)
+	}
+	linkStat, err := os.Stat(linkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedStat, err := os.Stat(fs.(*metricsFs).FsInterface.(*Local).getPath(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(linkStat, storedStat) {
+		t.Fatal("The part is copied, not linked")
+	}
+
+	linked, err := ioutil.ReadFile(linkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(linked, part) {
+		t.Fatal("The linked part is different")
+	}
+
+	// Wrong size
+	if err = GetPartToFile(fs, key, filepath.Join(dir, "wrong"), uint64(len(part))+1); err == nil {
+		t.Fatal("Get part succeeded with wrong size")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "wrong")); !os.IsNotExist(err) {
+		t.Fatal("The file of wrong size is left")
+	}
+
+	// The same part moved again shares the stored file
+	if err = ioutil.WriteFile(partPath, part, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if sameKey, err := PutPartFromFile(fs, partPath, uint64(len(part))); err != nil || sameKey != key {
+		t.Fatalf("Put the same part returns '%s', '%v'", sameKey, err)
+	}
+
+	if err = fs.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.GetToBuffer(key, uint64(len(part))); err != nil {
+		t.Fatal("The part is deleted while it is still referenced")
+	}
+	if err = fs.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.GetToBuffer(key, uint64(len(part))); err == nil {
+		t.Fatal("The part is still in fs after all references are deleted")
+	}
+}
+
+func TestRemoteLocalGetToBufferChecksSize(t *testing.T) {
+	part := bytes.Repeat([]byte("karst"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(part)
+	}))
+	defer server.Close()
+
+	fs, err := OpenRemoteLocal(server.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.GetToBuffer("key", uint64(len(part)))
+	if err != nil {
+		t.Fatalf("Get part to buffer failed: %s", err)
+	}
+	if !bytes.Equal(data, part) {
+		t.Fatal("The part got to buffer is different")
+	}
+
+	if _, err = fs.GetToBuffer("key", uint64(len(part))-1); err == nil {
+		t.Fatal("Get part to buffer succeeded with smaller size")
+	}
+	if _, err = fs.GetToBuffer("key", uint64(len(part))+1); err == nil {
+		t.Fatal("Get part to buffer succeeded with larger size")
+	}
+}
@@ -0,0 +1,172 @@
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"karst/metrics"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	fsOperationPut    = "put"
+	fsOperationGet    = "get"
+	fsOperationDelete = "delete"
+)
+
+// metricsFs records latency, errors and bytes of the fs operations for prometheus
+type metricsFs struct {
+	FsInterface
+	backend string
+}
+
+func newMetricsFs(fs FsInterface, backend string) *metricsFs {
+	return &metricsFs{
+		FsInterface: fs,
+		backend:     backend,
+	}
+}
+
+func (this *metricsFs) Put(fileName string) (string, error) {
+	timeStart := time.Now()
+	key, err := this.FsInterface.Put(fileName)
+	if err == nil {
+		if stat, statErr := os.Stat(fileName); statErr == nil {
+			this.addBytes(fsOperationPut, uint64(stat.Size()))
+		}
+	}
+	this.observe(fsOperationPut, timeStart, err)
+	return key, err
+}
+
+func (this *metricsFs) Get(key string, outFileName string) error {
+	timeStart := time.Now()
+	err := this.FsInterface.Get(key, outFileName)
+	if err == nil {
+		if stat, statErr := os.Stat(outFileName); statErr == nil {
+			this.addBytes(fsOperationGet, uint64(stat.Size()))
+		}
+	}
+	this.observe(fsOperationGet, timeStart, err)
+	return err
+}
+
+func (this *metricsFs) Delete(key string) error {
+	timeStart := time.Now()
+	err := this.FsInterface.Delete(key)
+	this.observe(fsOperationDelete, timeStart, err)
+	return err
+}
+
+func (this *metricsFs) GetToBuffer(key string, size uint64) ([]byte, error) {
+	timeStart := time.Now()
+	data, err := this.FsInterface.GetToBuffer(key, size)
+	if err == nil {
+		this.addBytes(fsOperationGet, uint64(len(data)))
+	}
+	this.observe(fsOperationGet, timeStart, err)
+	return data, err
+}
+
+func (this *metricsFs) PutReader(reader io.Reader, size uint64) (string, error) {
+	timeStart := time.Now()
+	key, err := this.FsInterface.PutReader(reader, size)
+	if err == nil {
+		this.addBytes(fsOperationPut, size)
+	}
+	this.observe(fsOperationPut, timeStart, err)
+	return key, err
+}
+
+func (this *metricsFs) GetReader(key string) (io.ReadCloser, error) {
+	timeStart := time.Now()
+	readCloser, err := this.FsInterface.GetReader(key)
+	if err != nil {
+		this.observe(fsOperationGet, timeStart, err)
+		return nil, err
+	}
+	return this.newMetricsReadCloser(readCloser, timeStart), nil
+}
+
+func (this *metricsFs) GetRangeReader(key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	timeStart := time.Now()
+	readCloser, err := this.FsInterface.GetRangeReader(key, offset, length)
+	if err != nil {
+		this.observe(fsOperationGet, timeStart, err)
+		return nil, err
+	}
+	return this.newMetricsReadCloser(readCloser, timeStart), nil
+}
+
+func (this *metricsFs) LinkPart(key string, path string) error {
+	fileFs, ok := this.FsInterface.(FileFs)
+	if !ok {
+		return fmt.Errorf("The parts of '%s' aren't files", this.backend)
+	}
+
+	timeStart := time.Now()
+	err := fileFs.LinkPart(key, path)
+	this.observe(fsOperationGet, timeStart, err)
+	return err
+}
+
+func (this *metricsFs) MovePart(path string, size uint64) (string, error) {
+	fileFs, ok := this.FsInterface.(FileFs)
+	if !ok {
+		return "", fmt.Errorf("The parts of '%s' aren't files", this.backend)
+	}
+
+	timeStart := time.Now()
+	key, err := fileFs.MovePart(path, size)
+	if err == nil {
+		this.addBytes(fsOperationPut, size)
+	}
+	this.observe(fsOperationPut, timeStart, err)
+	return key, err
+}
+
+func (this *metricsFs) observe(operation string, timeStart time.Time, err error) {
+	metrics.FsOperationDuration.WithLabelValues(this.backend, operation).Observe(time.Since(timeStart).Seconds())
+	if err != nil {
+		metrics.FsOperationErrors.WithLabelValues(this.backend, operation).Inc()
+	}
+}
+
+func (this *metricsFs) addBytes(operation string, size uint64) {
+	metrics.FsBytes.WithLabelValues(this.backend, operation).Add(float64(size))
+}
+
+// Bytes are counted while reading, the get is observed when the reader is closed
+type metricsReadCloser struct {
+	readCloser io.ReadCloser
+	fs         *metricsFs
+	timeStart  time.Time
+	readErr    error
+	closeOnce  sync.Once
+}
+
+func (this *metricsFs) newMetricsReadCloser(readCloser io.ReadCloser, timeStart time.Time) *metricsReadCloser {
+	return &metricsReadCloser{
+		readCloser: readCloser,
+		fs:         this,
+		timeStart:  timeStart,
+	}
+}
+
+func (mrc *metricsReadCloser) Read(p []byte) (int, error) {
+	n, err := mrc.readCloser.Read(p)
+	mrc.fs.addBytes(fsOperationGet, uint64(n))
+	if err != nil && err != io.EOF {
+		mrc.readErr = err
+	}
+	return n, err
+}
+
+func (mrc *metricsReadCloser) Close() error {
+	err := mrc.readCloser.Close()
+	mrc.closeOnce.Do(func() {
+		mrc.fs.observe(fsOperationGet, mrc.timeStart, mrc.readErr)
+	})
+	return err
+}
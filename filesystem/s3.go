@@ -0,0 +1,94 @@
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"karst/config"
+	"karst/filesystem/s3"
+	"os"
+)
+
+type S3 struct {
+	client *s3.Client
+}
+
+func OpenS3(cfg *config.Configuration) (*S3, error) {
+	client, err := s3.NewClientWithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = client.HeadBucket(); err != nil {
+		return nil, fmt.Errorf("Can't reach bucket '%s': %s", cfg.Fs.S3.Bucket, err)
+	}
+
+	return &S3{
+		client: client,
+	}, nil
+}
+
+func (this *S3) Close() {
+
+}
+
+func (this *S3) Check() error {
+	return this.client.HeadBucket()
+}
+
+func (this *S3) Put(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return this.client.PutObject(f, fileInfo.Size())
+}
+
+func (this *S3) PutReader(reader io.Reader, size uint64) (string, error) {
+	return this.client.PutObject(newSizedReader(reader, size), int64(size))
+}
+
+func (this *S3) Get(key string, outFileName string) error {
+	reader, err := this.client.GetObject(key, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	outFile, err := os.Create(outFileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(outFile, reader); err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
+
+func (this *S3) GetReader(key string) (io.ReadCloser, error) {
+	return this.client.GetObject(key, 0, 0)
+}
+
+func (this *S3) GetRangeReader(key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	return this.client.GetObject(key, int64(offset), int64(length))
+}
+
+func (this *S3) Delete(key string) error {
+	return this.client.DeleteObject(key)
+}
+
+func (this *S3) GetToBuffer(key string, size uint64) ([]byte, error) {
+	reader, err := this.client.GetObject(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readPartToBuffer(reader, key, size)
+}
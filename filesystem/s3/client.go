@@ -0,0 +1,299 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"karst/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRegion   = "us-east-1"
+	DefaultPartSize = 16 * (1 << 20) // 16 MB
+	MinPartSize     = 5 * (1 << 20)  // 5 MB, the limit of s3 for all parts except the last one
+	requestTimeout  = 10 * time.Minute
+	errorBodySize   = 4096
+)
+
+// Client of s3 compatible object storage, objects are addressed by path style like 'endpoint/bucket/key'
+type Client struct {
+	endpoint   *url.URL
+	region     string
+	bucket     string
+	prefix     string
+	accessKey  string
+	secretKey  string
+	partSize   int64
+	httpClient *http.Client
+}
+
+type responseError struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (respErr *responseError) Error() string {
+	if respErr.Code == "" {
+		return fmt.Sprintf("Error code is: %d", respErr.StatusCode)
+	}
+	return fmt.Sprintf("Error code is: %d, %s: %s", respErr.StatusCode, respErr.Code, respErr.Message)
+}
+
+type initiateMultipartUploadResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func NewClientWithConfig(cfg *config.Configuration) (*Client, error) {
+	s3Cfg := cfg.Fs.S3
+	endpoint, err := url.Parse(s3Cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("The endpoint of s3 must start with 'http://' or 'https://'")
+	}
+
+	if s3Cfg.Bucket == "" || s3Cfg.AccessKey == "" || s3Cfg.SecretKey == "" {
+		return nil, fmt.Errorf("The bucket, access key and secret key of s3 are needed")
+	}
+
+	client := &Client{
+		endpoint:  endpoint,
+		region:    s3Cfg.Region,
+		bucket:    s3Cfg.Bucket,
+		prefix:    s3Cfg.Prefix,
+		accessKey: s3Cfg.AccessKey,
+		secretKey: s3Cfg.SecretKey,
+		partSize:  int64(s3Cfg.PartSize),
+		httpClient: &http.Client{
+			Timeout: requestTimeout,
+		},
+	}
+
+	if client.region == "" {
+		client.region = DefaultRegion
+	}
+
+	if client.partSize == 0 {
+		client.partSize = DefaultPartSize
+	} else if client.partSize < MinPartSize {
+		return nil, fmt.Errorf("The part size of s3 must be at least %d", MinPartSize)
+	}
+
+	return client, nil
+}
+
+// Check if the bucket can be reached with the credentials
+func (client *Client) HeadBucket() error {
+	resp, err := client.do(http.MethodHead, "", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Upload 'size' bytes as a new object and return its key, data larger than part size is uploaded by multipart upload
+func (client *Client) PutObject(reader io.Reader, size int64) (string, error) {
+	if size < 0 {
+		return "", fmt.Errorf("Wrong object size %d", size)
+	}
+
+	key, err := client.newKey()
+	if err != nil {
+		return "", err
+	}
+
+	// Small object is uploaded at once, the buffer is only as large as the object
+	if size <= client.partSize {
+		buffer := make([]byte, size)
+		if _, err = io.ReadFull(reader, buffer); err != nil {
+			return "", err
+		}
+
+		resp, err := client.do(http.MethodPut, key, nil, nil, buffer)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		return key, nil
+	}
+
+	buffer := make([]byte, client.partSize)
+	if _, err = io.ReadFull(reader, buffer); err != nil {
+		return "", err
+	}
+
+	if err = client.putMultipartObject(key, reader, buffer); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Get object from 'offset', the whole rest of object is got if 'length' is 0, the caller must close the reader
+func (client *Client) GetObject(key string, offset int64, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.do(http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (client *Client) DeleteObject(key string) error {
+	resp, err := client.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Upload the first part in 'buffer' and the rest of data in reader, the upload is aborted after any error
+func (client *Client) putMultipartObject(key string, reader io.Reader, buffer []byte) error {
+	resp, err := client.do(http.MethodPost, key, url.Values{"uploads": []string{""}}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	var initResult initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initResult)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("Unmarshal initiate multipart upload result failed: %s", err)
+	}
+
+	uploadId := initResult.UploadId
+	complete := completeMultipartUpload{}
+	partData := buffer
+	for partNumber := 1; ; partNumber++ {
+		query := url.Values{
+			"partNumber": []string{strconv.Itoa(partNumber)},
+			"uploadId":   []string{uploadId},
+		}
+		resp, err := client.do(http.MethodPut, key, query, nil, partData)
+		if err != nil {
+			client.abortMultipartUpload(key, uploadId)
+			return err
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{
+			PartNumber: partNumber,
+			ETag:       resp.Header.Get("ETag"),
+		})
+
+		n, err := io.ReadFull(reader, buffer)
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			client.abortMultipartUpload(key, uploadId)
+			return err
+		}
+		partData = buffer[:n]
+	}
+
+	completeBytes, _ := xml.Marshal(complete)
+	resp, err = client.do(http.MethodPost, key, url.Values{"uploadId": []string{uploadId}}, nil, completeBytes)
+	if err != nil {
+		client.abortMultipartUpload(key, uploadId)
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Complete request may fail with 200
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(respBytes, []byte("<Error>")) {
+		respErr := &responseError{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(respBytes, respErr)
+		return respErr
+	}
+	return nil
+}
+
+func (client *Client) abortMultipartUpload(key string, uploadId string) {
+	resp, err := client.do(http.MethodDelete, key, url.Values{"uploadId": []string{uploadId}}, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// Send signed request, the error will be returned if the status code isn't 2xx
+func (client *Client) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	reqUrl := *client.endpoint
+	reqUrl.Path = strings.TrimSuffix(reqUrl.Path, "/") + "/" + client.bucket
+	if key != "" {
+		reqUrl.Path = reqUrl.Path + "/" + key
+	}
+	reqUrl.RawPath = uriEncode(reqUrl.Path, false)
+	if query != nil {
+		reqUrl.RawQuery = getCanonicalQuery(query)
+	}
+
+	req, err := http.NewRequest(method, reqUrl.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadSha
+	if len(body) != 0 {
+		payloadHash = sha256Hex(body)
+	}
+	signRequest(req, payloadHash, client.accessKey, client.secretKey, client.region, time.Now())
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respErr := &responseError{StatusCode: resp.StatusCode}
+		errBytes, _ := ioutil.ReadAll(io.LimitReader(resp.Body, errorBodySize))
+		_ = xml.Unmarshal(errBytes, respErr)
+		return nil, respErr
+	}
+	return resp, nil
+}
+
+// Keys are random, so the same data put twice can be deleted separately
+func (client *Client) newKey() (string, error) {
+	randBytes := make([]byte, 16)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", err
+	}
+	return client.prefix + hex.EncodeToString(randBytes), nil
+}
@@ -0,0 +1,203 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"karst/config"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const (
+	testAccessKey = "access"
+	testSecretKey = "secret"
+	testBucket    = "karst"
+)
+
+// Record the apis called on the fake server, like 'PUT' or 'PUT part'
+type recordedServer struct {
+	lock   sync.Mutex
+	server *FakeServer
+	apis   []string
+}
+
+func (rs *recordedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api := r.Method
+	switch {
+	case hasQuery(r, "uploads"):
+		api = api + " uploads"
+	case r.URL.Query().Get("partNumber") != "":
+		api = api + " part"
+	case r.URL.Query().Get("uploadId") != "":
+		api = api + " uploadId"
+	}
+
+	rs.lock.Lock()
+	rs.apis = append(rs.apis, api)
+	rs.lock.Unlock()
+	rs.server.ServeHTTP(w, r)
+}
+
+func (rs *recordedServer) takeApis() []string {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	apis := rs.apis
+	rs.apis = nil
+	return apis
+}
+
+func newTestClient(t *testing.T, secretKey string) (*Client, *recordedServer, func()) {
+	rs := &recordedServer{server: NewFakeServer(testAccessKey, testSecretKey, testBucket)}
+	httpServer := httptest.NewServer(rs)
+
+	cfg := &config.Configuration{}
+	cfg.Fs.S3.Endpoint = httpServer.URL
+	cfg.Fs.S3.Bucket = testBucket
+	cfg.Fs.S3.Prefix = "parts/"
+	cfg.Fs.S3.AccessKey = testAccessKey
+	cfg.Fs.S3.SecretKey = secretKey
+	cfg.Fs.S3.PartSize = MinPartSize
+
+	client, err := NewClientWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, rs, httpServer.Close
+}
+
+func newTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func getTestObject(t *testing.T, client *Client, key string, offset int64, length int64) []byte {
+	reader, err := client.GetObject(key, offset, length)
+	if err != nil {
+		t.Fatalf("Get '%s' failed: %s", key, err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func checkApis(t *testing.T, apis []string, expected ...string) {
+	if len(apis) != len(expected) {
+		t.Fatalf("Called apis are %v, expected %v", apis, expected)
+	}
+	for i := range apis {
+		if apis[i] != expected[i] {
+			t.Fatalf("Called apis are %v, expected %v", apis, expected)
+		}
+	}
+}
+
+func TestPutSingleObject(t *testing.T) {
+	client, rs, closeServer := newTestClient(t, testSecretKey)
+	defer closeServer()
+
+	for _, size := range []int{0, 1 << 20, MinPartSize} {
+		data := newTestData(size)
+		rs.takeApis()
+		key, err := client.PutObject(bytes.NewReader(data), int64(size))
+		if err != nil {
+			t.Fatalf("Put object of %d bytes failed: %s", size, err)
+		}
+		checkApis(t, rs.takeApis(), http.MethodPut)
+
+		if !bytes.Equal(getTestObject(t, client, key, 0, 0), data) {
+			t.Fatalf("The object of %d bytes is different", size)
+		}
+	}
+
+	data := newTestData(1000)
+	key, err := client.PutObject(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(getTestObject(t, client, key, 100, 50), data[100:150]) {
+		t.Fatal("Ranged get returns wrong data")
+	}
+	if !bytes.Equal(getTestObject(t, client, key, 900, 0), data[900:]) {
+		t.Fatal("Get from offset returns wrong data")
+	}
+
+	if err = client.DeleteObject(key); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	_, err = client.GetObject(key, 0, 0)
+	var respErr *responseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusNotFound || respErr.Code != "NoSuchKey" {
+		t.Fatalf("Get of deleted object returns '%v', expected NoSuchKey", err)
+	}
+
+	// The reader ends before the size
+	if _, err = client.PutObject(bytes.NewReader(data), int64(len(data))+1); err == nil {
+		t.Fatal("Put succeeded with short data")
+	}
+}
+
+func TestPutMultipartObject(t *testing.T) {
+	client, rs, closeServer := newTestClient(t, testSecretKey)
+	defer closeServer()
+
+	data := newTestData(2*MinPartSize + 123)
+	key, err := client.PutObject(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Multipart put failed: %s", err)
+	}
+	checkApis(t, rs.takeApis(), "POST uploads", "PUT part", "PUT part", "PUT part", "POST uploadId")
+
+	keys := rs.server.Keys(testBucket)
+	if len(keys) != 1 || keys[0] != key {
+		t.Fatalf("Keys in bucket are %v, expected '%s'", keys, key)
+	}
+
+	if !bytes.Equal(getTestObject(t, client, key, 0, 0), data) {
+		t.Fatal("The multipart object is different")
+	}
+
+	// The upload is aborted if the reader fails in the middle
+	rs.takeApis()
+	brokenReader := io.MultiReader(bytes.NewReader(data[:MinPartSize+100]), &errorReader{})
+	if _, err = client.PutObject(brokenReader, int64(len(data))); err == nil {
+		t.Fatal("Multipart put succeeded with broken reader")
+	}
+	checkApis(t, rs.takeApis(), "POST uploads", "PUT part", "DELETE uploadId")
+
+	if len(rs.server.Keys(testBucket)) != 1 {
+		t.Fatal("The aborted object is stored")
+	}
+}
+
+func TestWrongSignature(t *testing.T) {
+	client, _, closeServer := newTestClient(t, "wrong")
+	defer closeServer()
+
+	err := client.HeadBucket()
+	var respErr *responseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("Head bucket with wrong secret key returns '%v', expected 403", err)
+	}
+
+	if _, err = client.PutObject(bytes.NewReader([]byte("data")), 4); err == nil {
+		t.Fatal("Put succeeded with wrong secret key")
+	}
+}
+
+type errorReader struct{}
+
+func (er *errorReader) Read(p []byte) (int, error) {
+	return 0, errors.New("broken reader")
+}
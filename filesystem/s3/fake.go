@@ -0,0 +1,255 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"karst/logger"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fakeMultipartUpload struct {
+	bucket string
+	key    string
+	parts  map[int][]byte
+}
+
+// FakeServer is an in-memory stand-in of s3 compatible object storage, it checks signatures and
+// supports the object and multipart apis used by karst
+type FakeServer struct {
+	lock       sync.Mutex
+	accessKey  string
+	secretKey  string
+	region     string
+	buckets    map[string]map[string][]byte
+	uploads    map[string]*fakeMultipartUpload
+	uploadsNum uint64
+}
+
+func NewFakeServer(accessKey string, secretKey string, buckets ...string) *FakeServer {
+	server := &FakeServer{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		region:    DefaultRegion,
+		buckets:   make(map[string]map[string][]byte),
+		uploads:   make(map[string]*fakeMultipartUpload),
+	}
+
+	for _, bucket := range buckets {
+		server.buckets[bucket] = make(map[string][]byte)
+	}
+	return server
+}
+
+func (server *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("(FakeS3) %s %s?%s", r.Method, r.URL.Path, r.URL.RawQuery)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	if err = verifyRequest(r, server.accessKey, server.secretKey, server.region); err != nil {
+		writeFakeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	payloadHash := r.Header.Get("x-amz-content-sha256")
+	if (len(body) == 0 && payloadHash != emptyPayloadSha) || (len(body) != 0 && payloadHash != sha256Hex(body)) {
+		writeFakeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The payload hash doesn't match")
+		return
+	}
+
+	pathItems := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := pathItems[0]
+	key := ""
+	if len(pathItems) == 2 {
+		key = pathItems[1]
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	objects, ok := server.buckets[bucket]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NoSuchBucket", fmt.Sprintf("The bucket '%s' doesn't exist", bucket))
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "":
+		writeFakeError(w, http.StatusNotImplemented, "NotImplemented", "Only object apis are supported")
+	case r.Method == http.MethodPost && hasQuery(r, "uploads"):
+		server.uploadsNum++
+		uploadId := strconv.FormatUint(server.uploadsNum, 10)
+		server.uploads[uploadId] = &fakeMultipartUpload{
+			bucket: bucket,
+			key:    key,
+			parts:  make(map[int][]byte),
+		}
+		writeFakeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string   `xml:"Bucket"`
+			Key      string   `xml:"Key"`
+			UploadId string   `xml:"UploadId"`
+		}{Bucket: bucket, Key: key, UploadId: uploadId})
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		upload, ok := server.uploads[query.Get("uploadId")]
+		partNumber, err := strconv.Atoi(query.Get("partNumber"))
+		if !ok || upload.key != key || upload.bucket != bucket {
+			writeFakeError(w, http.StatusNotFound, "NoSuchUpload", "The upload doesn't exist")
+			return
+		}
+		if err != nil || partNumber < 1 {
+			writeFakeError(w, http.StatusBadRequest, "InvalidArgument", "Wrong part number")
+			return
+		}
+		upload.parts[partNumber] = body
+		w.Header().Set("ETag", getFakeETag(body))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		upload, ok := server.uploads[query.Get("uploadId")]
+		if !ok || upload.key != key || upload.bucket != bucket {
+			writeFakeError(w, http.StatusNotFound, "NoSuchUpload", "The upload doesn't exist")
+			return
+		}
+
+		var complete completeMultipartUpload
+		if err := xml.Unmarshal(body, &complete); err != nil || len(complete.Parts) == 0 {
+			writeFakeError(w, http.StatusBadRequest, "MalformedXML", "Wrong complete request")
+			return
+		}
+
+		object := make([]byte, 0)
+		for index, part := range complete.Parts {
+			partData, ok := upload.parts[part.PartNumber]
+			if !ok || part.PartNumber != index+1 || part.ETag != getFakeETag(partData) {
+				writeFakeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Wrong part %d", part.PartNumber))
+				return
+			}
+			if index != len(complete.Parts)-1 && len(partData) < MinPartSize {
+				writeFakeError(w, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("Part %d is too small", part.PartNumber))
+				return
+			}
+			object = append(object, partData...)
+		}
+
+		objects[key] = object
+		delete(server.uploads, query.Get("uploadId"))
+		writeFakeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string   `xml:"Bucket"`
+			Key     string   `xml:"Key"`
+		}{Bucket: bucket, Key: key})
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		delete(server.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		objects[key] = body
+		w.Header().Set("ETag", getFakeETag(body))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet:
+		object, ok := objects[key]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey", fmt.Sprintf("The key '%s' doesn't exist", key))
+			return
+		}
+
+		// Range like 'bytes=begin-end' or 'bytes=begin-'
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			begin, end, err := parseFakeRange(rangeHeader, int64(len(object)))
+			if err != nil {
+				writeFakeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", err.Error())
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", begin, end, len(object)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(object[begin : end+1])
+			return
+		}
+		_, _ = w.Write(object)
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeError(w, http.StatusNotImplemented, "NotImplemented", "The api isn't supported")
+	}
+}
+
+func hasQuery(r *http.Request, name string) bool {
+	_, ok := r.URL.Query()[name]
+	return ok
+}
+
+func parseFakeRange(rangeHeader string, size int64) (int64, int64, error) {
+	items := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)
+	if len(items) != 2 {
+		return 0, 0, fmt.Errorf("Wrong range '%s'", rangeHeader)
+	}
+
+	begin, err := strconv.ParseInt(items[0], 10, 64)
+	if err != nil || begin >= size {
+		return 0, 0, fmt.Errorf("Wrong range '%s'", rangeHeader)
+	}
+
+	end := size - 1
+	if items[1] != "" {
+		end, err = strconv.ParseInt(items[1], 10, 64)
+		if err != nil || end < begin {
+			return 0, 0, fmt.Errorf("Wrong range '%s'", rangeHeader)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return begin, end, nil
+}
+
+func getFakeETag(data []byte) string {
+	hash := md5.Sum(data)
+	return "\"" + hex.EncodeToString(hash[:]) + "\""
+}
+
+func writeFakeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	_, _ = w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("(FakeS3) Write err: %s", err)
+	}
+}
+
+func writeFakeError(w http.ResponseWriter, statusCode int, code string, message string) {
+	logger.Debug("(FakeS3) %s: %s", code, message)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+// Get sorted keys of objects in the bucket
+func (server *FakeServer) Keys(bucket string) []string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	keys := make([]string, 0, len(server.buckets[bucket]))
+	for key := range server.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,162 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signAlgorithm   = "AWS4-HMAC-SHA256"
+	signService     = "s3"
+	signTimeFormat  = "20060102T150405Z"
+	signDateFormat  = "20060102"
+	emptyPayloadSha = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Sign request by AWS signature version 4, 'payloadHash' is the hex sha256 of body
+func signRequest(req *http.Request, payloadHash string, accessKey string, secretKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format(signTimeFormat)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders, signature := computeSignature(req, payloadHash, secretKey, region, amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, accessKey, getCredentialScope(amzDate, region), signedHeaders, signature))
+}
+
+// Check the signature of request, return the error if the signature is wrong
+func verifyRequest(req *http.Request, accessKey string, secretKey string, region string) error {
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, signAlgorithm+" ") {
+		return fmt.Errorf("Unsupported authorization")
+	}
+
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, signAlgorithm+" "), ",") {
+		items := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(items) == 2 {
+			fields[items[0]] = items[1]
+		}
+	}
+
+	amzDate := req.Header.Get("x-amz-date")
+	if fields["Credential"] != accessKey+"/"+getCredentialScope(amzDate, region) {
+		return fmt.Errorf("Wrong credential")
+	}
+
+	signedHeaders, signature := computeSignature(req, req.Header.Get("x-amz-content-sha256"), secretKey, region, amzDate)
+	if fields["SignedHeaders"] != signedHeaders || !hmac.Equal([]byte(fields["Signature"]), []byte(signature)) {
+		return fmt.Errorf("Signature doesn't match")
+	}
+	return nil
+}
+
+func computeSignature(req *http.Request, payloadHash string, secretKey string, region string, amzDate string) (string, string) {
+	// Only host and amz headers are signed, so that proxies can't break the signature
+	headerNames := []string{"host"}
+	for name := range req.Header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-amz-") {
+			headerNames = append(headerNames, lowerName)
+		}
+	}
+	sort.Strings(headerNames)
+
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		canonicalHeaders = canonicalHeaders + name + ":" + strings.TrimSpace(value) + "\n"
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		getCanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		signAlgorithm,
+		amzDate,
+		getCredentialScope(amzDate, region),
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	date := amzDate
+	if len(date) > len(signDateFormat) {
+		date = date[:len(signDateFormat)]
+	}
+	signingKey := hmacSha256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSha256(signingKey, region)
+	signingKey = hmacSha256(signingKey, signService)
+	signingKey = hmacSha256(signingKey, "aws4_request")
+
+	return signedHeaders, hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+}
+
+func getCredentialScope(amzDate string, region string) string {
+	date := amzDate
+	if len(date) > len(signDateFormat) {
+		date = date[:len(signDateFormat)]
+	}
+	return date + "/" + region + "/" + signService + "/aws4_request"
+}
+
+func getCanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Encode string like RFC 3986, '/' is kept unless 'encodeSlash' is true
+func uriEncode(s string, encodeSlash bool) string {
+	var builder strings.Builder
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !encodeSlash) {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return builder.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
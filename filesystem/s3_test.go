@@ -0,0 +1,45 @@
+package filesystem
+
+import (
+	"bytes"
+	"karst/config"
+	"karst/filesystem/s3"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestS3GetToBufferChecksSize(t *testing.T) {
+	server := httptest.NewServer(s3.NewFakeServer("access", "secret", "karst"))
+	defer server.Close()
+
+	cfg := &config.Configuration{}
+	cfg.Fs.S3.Endpoint = server.URL
+	cfg.Fs.S3.Bucket = "karst"
+	cfg.Fs.S3.AccessKey = "access"
+	cfg.Fs.S3.SecretKey = "secret"
+	fs, err := OpenS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	part := bytes.Repeat([]byte("karst"), 100)
+	key, err := fs.PutReader(bytes.NewReader(part), uint64(len(part)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.GetToBuffer(key, uint64(len(part)))
+	if err != nil {
+		t.Fatalf("Get part to buffer failed: %s", err)
+	}
+	if !bytes.Equal(data, part) {
+		t.Fatal("The part got to buffer is different")
+	}
+
+	if _, err = fs.GetToBuffer(key, uint64(len(part))-1); err == nil {
+		t.Fatal("Get part to buffer succeeded with smaller size")
+	}
+	if _, err = fs.GetToBuffer(key, uint64(len(part))+1); err == nil {
+		t.Fatal("Get part to buffer succeeded with larger size")
+	}
+}
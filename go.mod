@@ -3,15 +3,19 @@ module karst
 go 1.13
 
 require (
+	github.com/ChainSafe/go-schnorrkel v0.0.0-20200405005733-88cbf1b4c40d
 	github.com/cheggaaa/pb v2.0.7+incompatible
 	github.com/cheggaaa/pb/v3 v3.0.4 // indirect
 	github.com/gorilla/websocket v1.4.0
 	github.com/imroc/req v0.3.0
 	github.com/ipfs/go-ipfs-api v0.1.0
+	github.com/mr-tron/base58 v1.1.3
+	github.com/prometheus/client_golang v1.7.1
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/viper v1.4.0
 	github.com/syndtr/goleveldb v1.0.0
-	golang.org/x/sys v0.0.0-20191128015809-6d18c012aee9
+	golang.org/x/crypto v0.0.0-20191206172530-e9b2fee46413
+	golang.org/x/sys v0.0.0-20200615200032-f1bc736245b1
 	gopkg.in/VividCortex/ewma.v1 v1.1.1 // indirect
 	gopkg.in/cheggaaa/pb.v2 v2.0.7 // indirect
 	gopkg.in/fatih/color.v1 v1.7.0 // indirect
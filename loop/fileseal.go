@@ -1,146 +1,490 @@
 package loop
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"karst/cache"
 	"karst/config"
 	"karst/filesystem"
 	"karst/logger"
+	"karst/metrics"
 	"karst/model"
 	"karst/sworker"
 	"karst/utils"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
 const (
-	fileSealJobQueueLimit = 1000
+	fileSealJobQueueLimit        = 1000
+	fileSealJobRecordKeepTime    = 7 * 24 * time.Hour
+	fileSealJobMaxAttempts       = 5
+	fileSealJobRetryBaseInterval = 30 * time.Second
+	fileSealJobRetryMaxInterval  = 30 * time.Minute
 )
 
-var fileSealJobs chan model.FileSealMessage = nil
+var fileSealJobs *fileSealScheduler = nil
+var fileSealDb *leveldb.DB = nil
+var fileSealCtx context.Context = nil
+
+// The check of accepted jobs and the enqueue must be done together, or the same job may be enqueued twice
+var fileSealEnqueueLock sync.Mutex
+
+// Jobs with the same merkle tree hash share the same seal directory, so they must be dealt one by one
+type sealHashLock struct {
+	lock sync.Mutex
+	refs int
+}
+
+var sealHashLocks = make(map[string]*sealHashLock)
+var sealHashLocksLock sync.Mutex
+
+type FileSealLoop struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func StartFileSealLoop(cfg *config.Configuration, db *leveldb.DB, fs filesystem.FsInterface, sworkerClient sworker.Client) *FileSealLoop {
+	// Unfinished jobs
+	sealJobList, err := model.GetSealJobList(db)
+	if err != nil {
+		logger.Error("Fatal error in reading seal jobs from db: %s", err)
+		sealJobList = make([]*model.SealJob, 0)
+	}
+
+	unfinishedJobList := make([]*model.SealJob, 0)
+	for _, sealJob := range sealJobList {
+		if !sealJob.IsFinished() {
+			unfinishedJobList = append(unfinishedJobList, sealJob)
+		} else if time.Since(time.Unix(0, sealJob.FinishTime)) > fileSealJobRecordKeepTime {
+			// Remove expired records of finished jobs
+			sealJob.ClearDb(db)
+		}
+	}
 
-func StartFileSealLoop(cfg *config.Configuration, db *leveldb.DB, fs filesystem.FsInterface) {
 	// Seal jobs queue
-	fileSealJobs = make(chan model.FileSealMessage, fileSealJobQueueLimit)
-	go fileSealLoop(cfg, db, fs)
+	fileSealJobs = newFileSealScheduler(fileSealJobQueueLimit, cfg.Sworker.SealSmallFilesFirst, cfg.Sworker.SealClientMaxInFlightSize)
+	fileSealDb = db
+
+	// Replay unfinished jobs
+	clearSealFiles(cfg)
+	for _, sealJob := range unfinishedJobList {
+		if sealJob.Stage != model.SealJobStageStored {
+			// The sealed file hasn't been stored, so seal again
+			sealJob.ClearSealedFile()
+			sealJob.MerkleTreeSealed = nil
+			sealJob.SealedPath = ""
+			sealJob.SealedHash = ""
+			if err := sealJob.Checkpoint(model.SealJobStageAccepted, db); err != nil {
+				logger.Error("Fatal error in saving seal job '%s': %s", sealJob.Message.StoreOrderHash, err)
+			}
+		}
+
+		if sealJob.Canceled {
+			markSealJobCanceled(sealJob)
+		}
+
+		logger.Info("Replay file seal job: store order hash -> %s, stage -> %s", sealJob.Message.StoreOrderHash, sealJob.Stage)
+		_ = fileSealJobs.push(sealJob, true)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fileSealCtx = ctx
+	sealLoop := &FileSealLoop{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	logger.Info("Start %d file seal workers", cfg.Sworker.SealWorkersNum)
+	for i := 0; i < cfg.Sworker.SealWorkersNum; i++ {
+		sealLoop.wg.Add(1)
+		go sealLoop.work(cfg, db, fs, sworkerClient)
+	}
+
+	return sealLoop
 }
 
-func TryEnqueueFileSealJob(job model.FileSealMessage) bool {
+// Stop all workers, each worker will stop at the next checkpoint of its current job and
+// the unfinished jobs will be replayed after restart
+func (sealLoop *FileSealLoop) Stop() {
+	logger.Info("Stopping file seal loop, waiting for the current jobs to reach checkpoints")
+	sealLoop.cancel()
+	sealLoop.wg.Wait()
+	logger.Info("File seal loop is stopped")
+}
+
+func TryEnqueueFileSealJob(job model.FileSealMessage) error {
 	if fileSealJobs == nil {
-		return false
+		return fmt.Errorf("The seal loop doesn't start")
 	}
 
-	select {
-	case fileSealJobs <- job:
-		return true
-	default:
-		return false
+	fileSealEnqueueLock.Lock()
+	defer fileSealEnqueueLock.Unlock()
+
+	// A failed or canceled job can be submitted again
+	if sealJob, err := model.GetSealJobFromDb(job.StoreOrderHash, fileSealDb); err == nil && sealJob.Stage != model.SealJobStageFailed && sealJob.Stage != model.SealJobStageCanceled {
+		return fmt.Errorf("The seal job of '%s' has been accepted, its stage is '%s'", job.StoreOrderHash, sealJob.Stage)
+	}
+
+	if ok, _ := fileSealDb.Has([]byte(model.SealDeadJobFlagInDb+job.StoreOrderHash), nil); ok {
+		return fmt.Errorf("The seal job of '%s' is in dead list, please wait for merchant to deal it", job.StoreOrderHash)
+	}
+
+	// Save job before enqueue, so it can be replayed after restart
+	sealJob := model.NewSealJob(job)
+	if err := sealJob.SaveToDb(fileSealDb); err != nil {
+		return err
+	}
+
+	if err := fileSealJobs.push(sealJob, false); err != nil {
+		sealJob.ClearDb(fileSealDb)
+		return err
 	}
+	return nil
 }
 
-func fileSealLoop(cfg *config.Configuration, db *leveldb.DB, fs filesystem.FsInterface) {
+// Get the number and size of queued and in-flight jobs of each client
+func GetFileSealQueueStatus() []*model.SealQueueStatus {
+	if fileSealJobs == nil {
+		return make([]*model.SealQueueStatus, 0)
+	}
+	return fileSealJobs.status()
+}
+
+// Remove files in 'seal_files' directory which are left by unfinished jobs
+func clearSealFiles(cfg *config.Configuration) {
+	sealFileInfos, err := ioutil.ReadDir(cfg.KarstPaths.SealFilesPath)
+	if err != nil {
+		return
+	}
+
+	for _, sealFileInfo := range sealFileInfos {
+		sealFilePath := filepath.FromSlash(cfg.KarstPaths.SealFilesPath + "/" + sealFileInfo.Name())
+		logger.Debug("Remove half-written seal file '%s'", sealFilePath)
+		os.RemoveAll(sealFilePath)
+	}
+}
+
+func (sealLoop *FileSealLoop) work(cfg *config.Configuration, db *leveldb.DB, fs filesystem.FsInterface, sworkerClient sworker.Client) {
+	defer sealLoop.wg.Done()
 	for {
-		select {
-		case job := <-fileSealJobs:
-			timeStart := time.Now()
-			logger.Info("File seal job: client -> %s, store order hash -> %s, file hash -> %s\n", job.Client, job.StoreOrderHash, job.MerkleTree.Hash)
-
-			// TODO: Use cache to speed up get method
-			// TODO: Add mechanism to prevent malicious deletion
-			// File info
-			fileInfo := &model.FileInfo{
-				MerkleTree: job.MerkleTree,
-			}
+		job := fileSealJobs.pop(sealLoop.ctx)
+		if job == nil {
+			return
+		}
 
-			// Check if the file has been stored locally
-			if ok, _ := db.Has([]byte(model.FileFlagInDb+job.MerkleTree.Hash), nil); ok {
-				logger.Info("The file '%s' has been stored already", job.MerkleTree.Hash)
-				_ = fileInfo.DeleteOriginalFileFromFs(fs)
-				continue
-			}
+		lockSealHash(job.Message.MerkleTree.Hash)
+		jobCtx := startSealJobContext(sealLoop.ctx, job)
+		dealFileSealJob(jobCtx, job, cfg, db, fs, sworkerClient)
+		stopSealJobContext(job)
+		unlockSealHash(job.Message.MerkleTree.Hash)
+		fileSealJobs.done(job)
+	}
+}
 
-			// Create file directory
-			originalPath := filepath.FromSlash(cfg.KarstPaths.SealFilesPath + "/" + job.MerkleTree.Hash)
-			if utils.IsDirOrFileExist(originalPath) {
-				logger.Info("The file '%s' is being sealed", job.MerkleTree.Hash)
-				_ = fileInfo.DeleteOriginalFileFromFs(fs)
-				continue
-			}
+// Deal the seal job from its stage, the stage will be saved after each step
+func dealFileSealJob(ctx context.Context, job *model.SealJob, cfg *config.Configuration, db *leveldb.DB, fs filesystem.FsInterface, sworkerClient sworker.Client) {
+	// TODO: Use cache to speed up get method
+	// TODO: Add mechanism to prevent malicious deletion
+	// File info
+	fileInfo := &model.FileInfo{
+		MerkleTree:       job.Message.MerkleTree,
+		MerkleTreeSealed: job.MerkleTreeSealed,
+		SealedPath:       job.SealedPath,
+	}
 
-			if err := os.MkdirAll(originalPath, os.ModePerm); err != nil {
-				logger.Error("Fatal error in creating file store directory: %s", err)
-				_ = fileInfo.DeleteOriginalFileFromFs(fs)
-				continue
-			}
-			fileInfo.OriginalPath = originalPath
-
-			// Lock cache
-			if err := cache.WaitLock(fileInfo.MerkleTree.Size); err != nil {
-				logger.Error(err.Error())
-				_ = fileInfo.DeleteOriginalFileFromFs(fs)
-				fileInfo.ClearOriginalFile()
-				continue
-			}
+	if isSealJobStopped(ctx, job, fileInfo, db, fs) {
+		return
+	}
 
-			// Get file from fs
-			err := fileInfo.GetOriginalFileFromFs(fs)
-			if err != nil {
-				logger.Error("Get whole file failed, error is %s", err)
-				_ = fileInfo.DeleteOriginalFileFromFs(fs)
-				fileInfo.ClearOriginalFile()
-				cache.Unlock(fileInfo.MerkleTree.Size)
-				continue
-			}
+	timeStart := time.Now()
+	stageStart := timeStart
+	logger.Info("File seal job: client -> %s, store order hash -> %s, file hash -> %s, stage -> %s, attempts -> %d\n", job.Message.Client, job.Message.StoreOrderHash, job.Message.MerkleTree.Hash, job.Stage, job.Attempts)
 
-			// Send merkle tree to sworker for sealing
-			merkleTreeSealed, sealedPath, err := sworker.Seal(cfg, fileInfo.OriginalPath, fileInfo.MerkleTree)
-			if err != nil {
-				logger.Error("Fatal error in sealing file '%s' : %s", fileInfo.MerkleTree.Hash, err)
-				_ = fileInfo.DeleteOriginalFileFromFs(fs)
-				fileInfo.ClearOriginalFile()
-				cache.Unlock(fileInfo.MerkleTree.Size)
-				continue
-			} else {
-				fileInfo.MerkleTreeSealed = merkleTreeSealed
-				fileInfo.SealedPath = sealedPath
+	if job.Stage == model.SealJobStageAccepted {
+		// Check if the file has been stored locally
+		if ok, _ := db.Has([]byte(model.FileFlagInDb+job.Message.MerkleTree.Hash), nil); ok {
+			logger.Info("The file '%s' has been stored already", job.Message.MerkleTree.Hash)
+			deleteSealJobOriginalFile(job, fileInfo, db, fs)
+			if storedFileInfo, err := model.GetFileInfoFromDb(job.Message.MerkleTree.Hash, db, model.FileFlagInDb); err == nil {
+				job.SealedHash = storedFileInfo.MerkleTreeSealed.Hash
 			}
+			saveSealJob(job, model.SealJobStageConfirmed, db)
+			return
+		}
 
-			// Save sealed file into fs
-			if err = fileInfo.PutSealedFileIntoFs(fs); err != nil {
-				logger.Error("Put whole file failed, error is %s", err)
-				_ = fileInfo.DeleteOriginalFileFromFs(fs)
-				fileInfo.ClearSealedFile()
-				cache.Unlock(fileInfo.MerkleTree.Size)
-				continue
-			}
+		// Create file directory, the directory left by a failed job can be removed because of the seal hash lock
+		originalPath := filepath.FromSlash(cfg.KarstPaths.SealFilesPath + "/" + job.Message.MerkleTree.Hash)
+		if utils.IsDirOrFileExist(originalPath) {
+			logger.Debug("Remove stale seal directory '%s'", originalPath)
+			os.RemoveAll(originalPath)
+		}
+
+		if err := os.MkdirAll(originalPath, os.ModePerm); err != nil {
+			retryOrBurySealJob(job, fmt.Sprintf("Fatal error in creating file store directory: %s", err), true, db)
+			return
+		}
+		fileInfo.OriginalPath = originalPath
 
-			// Save to db
-			fileInfo.SaveToDb(db)
-			fileInfoBytes, _ := json.Marshal(fileInfo)
-			logger.Debug("File info is %s", string(fileInfoBytes))
-
-			// Notificate sworker can detect
-			if err = sworker.Confirm(cfg, fileInfo.MerkleTreeSealed.Hash); err != nil {
-				logger.Error("Sworker file confirm failed, error is %s", err)
-				_ = fileInfo.DeleteOriginalFileFromFs(fs)
-				fileInfo.ClearSealedFile()
-				fileInfo.ClearDb(db)
-				cache.Unlock(fileInfo.MerkleTree.Size)
-				continue
+		// Lock cache, the job can't be dealt if the file is larger than the whole cache
+		if err := cache.WaitLock(ctx, fileInfo.MerkleTree.Size); err != nil {
+			fileInfo.ClearOriginalFile()
+			if isSealJobStopped(ctx, job, fileInfo, db, fs) {
+				return
 			}
+			retryOrBurySealJob(job, err.Error(), cache.CanLock(fileInfo.MerkleTree.Size), db)
+			return
+		}
+		defer cache.Unlock(fileInfo.MerkleTree.Size)
 
-			// Delete original file from fs
-			_ = fileInfo.DeleteOriginalFileFromFs(fs)
+		// Get file from fs
+		err := fileInfo.GetOriginalFileFromFs(fs)
+		if err != nil {
+			fileInfo.ClearOriginalFile()
+			retryOrBurySealJob(job, fmt.Sprintf("Get whole file failed, error is %s", err), true, db)
+			return
+		}
+		saveSealJob(job, model.SealJobStageFetched, db)
+		observeSealJobStage(job, &stageStart)
+		if isSealJobStopped(ctx, job, fileInfo, db, fs) {
+			return
+		}
+
+		// Send merkle tree to sworker for sealing
+		merkleTreeSealed, sealedPath, err := sworkerClient.Seal(fileInfo.OriginalPath, fileInfo.MerkleTree)
+		if err != nil {
+			fileInfo.ClearOriginalFile()
+			retryOrBurySealJob(job, fmt.Sprintf("Fatal error in sealing file '%s' : %s", fileInfo.MerkleTree.Hash, err), isTransientSealError(err), db)
+			return
+		} else {
+			fileInfo.MerkleTreeSealed = merkleTreeSealed
+			fileInfo.SealedPath = sealedPath
+			job.MerkleTreeSealed = merkleTreeSealed
+			job.SealedPath = sealedPath
+			job.SealedHash = merkleTreeSealed.Hash
+		}
+		saveSealJob(job, model.SealJobStageSealed, db)
+		observeSealJobStage(job, &stageStart)
+		if isSealJobStopped(ctx, job, fileInfo, db, fs) {
+			return
+		}
+
+		// Save sealed file into fs
+		if err = fileInfo.PutSealedFileIntoFs(fs); err != nil {
+			fileInfo.ClearOriginalFile()
 			fileInfo.ClearSealedFile()
-			cache.Unlock(fileInfo.MerkleTree.Size)
+			retryOrBurySealJob(job, fmt.Sprintf("Put whole file failed, error is %s", err), true, db)
+			return
+		}
 
-			logger.Info("Seal '%s' successfully in %s ! Sealed root hash is '%s'", fileInfo.MerkleTree.Hash, time.Since(timeStart), fileInfo.MerkleTreeSealed.Hash)
+		// Save to db
+		fileInfo.SaveToDb(db)
+		fileInfoBytes, _ := json.Marshal(fileInfo)
+		logger.Debug("File info is %s", string(fileInfoBytes))
+		saveSealJob(job, model.SealJobStageStored, db)
+		observeSealJobStage(job, &stageStart)
+		if isSealJobStopped(ctx, job, fileInfo, db, fs) {
+			return
+		}
+	}
+
+	if job.Stage == model.SealJobStageStored {
+		// Notificate sworker can detect, the sealed file has been stored so only confirm is retried
+		if err := sworkerClient.Confirm(fileInfo.MerkleTreeSealed.Hash); err != nil {
+			fileInfo.ClearSealedFile()
+			retryOrBurySealJob(job, fmt.Sprintf("Sworker file confirm failed, error is %s", err), isTransientSealError(err), db)
+			return
+		}
+		saveSealJob(job, model.SealJobStageConfirmed, db)
+		observeSealJobStage(job, &stageStart)
+	}
+
+	// Delete original file from fs
+	deleteSealJobOriginalFile(job, fileInfo, db, fs)
+	fileInfo.ClearSealedFile()
+
+	logger.Info("Seal '%s' successfully in %s ! Sealed root hash is '%s'", fileInfo.MerkleTree.Hash, time.Since(timeStart), fileInfo.MerkleTreeSealed.Hash)
+}
+
+// Observe the time from 'stageStart' to the current stage of job, then restart the timer for the next stage
+func observeSealJobStage(job *model.SealJob, stageStart *time.Time) {
+	now := time.Now()
+	metrics.SealJobStageDuration.WithLabelValues(job.Stage).Observe(now.Sub(*stageStart).Seconds())
+	*stageStart = now
+}
+
+// Errors from fs and network are transient, sworker can refuse the request with 4xx error codes
+func isTransientSealError(err error) bool {
+	if statusErr, ok := err.(*sworker.StatusError); ok {
+		return !statusErr.IsPermanent()
+	}
+	return true
+}
+
+// Retry the job with exponential backoff when the error is transient, otherwise move it into dead list.
+// The original file is kept in fs in both cases, so that the dead job can be requeued by merchant
+func retryOrBurySealJob(job *model.SealJob, errString string, transient bool, db *leveldb.DB) {
+	logger.Error(errString)
+	job.Attempts++
+
+	// The sealed file hasn't been stored, so seal again
+	if job.Stage != model.SealJobStageStored {
+		job.Stage = model.SealJobStageAccepted
+		job.MerkleTreeSealed = nil
+		job.SealedPath = ""
+		job.SealedHash = ""
+	}
+
+	// The canceled job is put back at once and will be canceled when it is popped
+	canceled := isSealJobCanceled(job)
+	if !canceled && (!transient || job.Attempts >= fileSealJobMaxAttempts) {
+		logger.Warn("The seal job '%s' is moved into dead list after %d attempts", job.Message.StoreOrderHash, job.Attempts)
+		if err := job.Bury(errString, db); err != nil {
+			logger.Error("Fatal error in moving seal job '%s' into dead list: %s", job.Message.StoreOrderHash, err)
+		}
+		return
+	}
+
+	job.Error = errString
+	saveSealJob(job, job.Stage, db)
+
+	if canceled {
+		_ = fileSealJobs.push(job, true)
+		return
+	}
 
-		default:
-			time.Sleep(5 * time.Millisecond)
+	backoff := fileSealJobRetryBaseInterval << uint(job.Attempts-1)
+	if backoff > fileSealJobRetryMaxInterval {
+		backoff = fileSealJobRetryMaxInterval
+	}
+	logger.Info("The seal job '%s' will be retried in %s", job.Message.StoreOrderHash, backoff)
+
+	// The job is saved, so it will be replayed after restart if the loop is stopped, the canceled job is cleaned up
+	// by the canceler
+	go func() {
+		if waitSealJobRetry(job, backoff) {
+			_ = fileSealJobs.push(job, true)
 		}
+	}()
+}
+
+// Put the dead job back into seal loop
+func RequeueFileSealDeadJob(storeOrderHash string) error {
+	if fileSealJobs == nil {
+		return fmt.Errorf("The seal loop doesn't start")
+	}
+
+	sealJob, err := model.GetSealDeadJobFromDb(storeOrderHash, fileSealDb)
+	if err != nil {
+		return err
+	}
+
+	stage := model.SealJobStageAccepted
+	if sealJob.MerkleTreeSealed != nil {
+		stage = model.SealJobStageStored
+	}
+	sealJob.Attempts = 0
+	if err = sealJob.Revive(stage, fileSealDb); err != nil {
+		return err
+	}
+
+	if err = fileSealJobs.push(sealJob, false); err != nil {
+		_ = sealJob.Bury(sealJob.Error, fileSealDb)
+		return err
 	}
+	return nil
+}
+
+// Give up the dead job, the original file and the stored sealed file will be deleted
+func PurgeFileSealDeadJob(storeOrderHash string, fs filesystem.FsInterface) error {
+	if fileSealJobs == nil {
+		return fmt.Errorf("The seal loop doesn't start")
+	}
+
+	sealJob, err := model.GetSealDeadJobFromDb(storeOrderHash, fileSealDb)
+	if err != nil {
+		return err
+	}
+
+	return purgeFileSealDeadJob(sealJob, fs, model.SealJobStageFailed, fmt.Sprintf("Purged by merchant, the last error is: %s", sealJob.Error))
+}
+
+func purgeFileSealDeadJob(sealJob *model.SealJob, fs filesystem.FsInterface, stage string, errString string) error {
+	lockSealHash(sealJob.Message.MerkleTree.Hash)
+	defer unlockSealHash(sealJob.Message.MerkleTree.Hash)
+
+	fileInfo := &model.FileInfo{
+		MerkleTree:       sealJob.Message.MerkleTree,
+		MerkleTreeSealed: sealJob.MerkleTreeSealed,
+	}
+	if fileInfo.MerkleTreeSealed != nil {
+		_ = fileInfo.DeleteSealedFileFromFs(fs)
+		fileInfo.ClearDb(fileSealDb)
+	}
+	deleteSealJobOriginalFile(sealJob, fileInfo, fileSealDb, fs)
+
+	// Keep the record of failed job for clients
+	sealJob.Error = errString
+	return sealJob.Revive(stage, fileSealDb)
+}
+
+// The original file put by the client isn't needed any more, so the client's grants of its parts are revoked too
+func deleteSealJobOriginalFile(job *model.SealJob, fileInfo *model.FileInfo, db *leveldb.DB, fs filesystem.FsInterface) {
+	_ = fileInfo.DeleteOriginalFileFromFs(fs)
+	model.RevokeLocalFsKeys(job.Message.Client, job.Message.MerkleTree.StoredKeys(), db)
+}
+
+func saveSealJob(job *model.SealJob, stage string, db *leveldb.DB) {
+	// Keep the cancel mark which may be saved by others
+	job.Canceled = job.Canceled || isSealJobCanceled(job)
+	if err := job.Checkpoint(stage, db); err != nil {
+		logger.Error("Fatal error in saving seal job '%s' at stage '%s': %s", job.Message.StoreOrderHash, stage, err)
+	}
+}
+
+// The job is canceled or kept in its current stage when the loop is stopped
+func isSealJobStopped(ctx context.Context, job *model.SealJob, fileInfo *model.FileInfo, db *leveldb.DB, fs filesystem.FsInterface) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+
+	if isSealJobCanceled(job) {
+		cancelFileSealJob(job, fileInfo, db, fs)
+		return true
+	}
+
+	logger.Info("File seal loop is stopped, the seal job '%s' is kept in stage '%s'", job.Message.StoreOrderHash, job.Stage)
+	return true
+}
+
+func lockSealHash(hash string) {
+	sealHashLocksLock.Lock()
+	hashLock, ok := sealHashLocks[hash]
+	if !ok {
+		hashLock = &sealHashLock{}
+		sealHashLocks[hash] = hashLock
+	}
+	hashLock.refs++
+	sealHashLocksLock.Unlock()
+
+	hashLock.lock.Lock()
+}
+
+func unlockSealHash(hash string) {
+	sealHashLocksLock.Lock()
+	hashLock := sealHashLocks[hash]
+	hashLock.refs--
+	if hashLock.refs == 0 {
+		delete(sealHashLocks, hash)
+	}
+	sealHashLocksLock.Unlock()
+
+	hashLock.lock.Unlock()
 }
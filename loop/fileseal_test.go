@@ -0,0 +1,68 @@
+package loop
+
+import (
+	"io/ioutil"
+	"karst/merkletree"
+	"karst/model"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestEnqueueSameSealJobConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "karst-loop-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fileSealJobs = newFileSealScheduler(fileSealJobQueueLimit, false, 0)
+	fileSealDb = db
+	defer func() {
+		fileSealJobs = nil
+		fileSealDb = nil
+	}()
+
+	job := model.FileSealMessage{
+		Client:         "client",
+		StoreOrderHash: "store_order_hash",
+		MerkleTree:     merkletree.CreateMerkleTree([][]byte{[]byte("hash")}, []uint64{100}, 2),
+	}
+
+	// All requests are sent at the same time
+	start := make(chan bool)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	enqueuedNum := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := TryEnqueueFileSealJob(job); err == nil {
+				lock.Lock()
+				enqueuedNum++
+				lock.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if enqueuedNum != 1 {
+		t.Fatalf("The same seal job is enqueued %d times", enqueuedNum)
+	}
+
+	queueStatus := GetFileSealQueueStatus()
+	if len(queueStatus) != 1 || queueStatus[0].QueuedNum != 1 {
+		t.Fatalf("The seal queue is %+v, expected one job", queueStatus)
+	}
+}
@@ -0,0 +1,231 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"karst/metrics"
+	"karst/model"
+	"sort"
+	"sync"
+)
+
+// Seal jobs are queued by client and clients are served in turn, so that one client can't starve the others
+type fileSealScheduler struct {
+	lock            sync.Mutex
+	changed         chan struct{}
+	queues          map[string][]*model.SealJob
+	clients         []string
+	next            int
+	queuedNum       int
+	queueLimit      int
+	inFlightNums    map[string]int
+	inFlightSizes   map[string]uint64
+	smallFilesFirst bool
+	maxInFlightSize uint64
+}
+
+func newFileSealScheduler(queueLimit int, smallFilesFirst bool, maxInFlightSize uint64) *fileSealScheduler {
+	return &fileSealScheduler{
+		changed:         make(chan struct{}),
+		queues:          make(map[string][]*model.SealJob),
+		clients:         make([]string, 0),
+		queueLimit:      queueLimit,
+		inFlightNums:    make(map[string]int),
+		inFlightSizes:   make(map[string]uint64),
+		smallFilesFirst: smallFilesFirst,
+		maxInFlightSize: maxInFlightSize,
+	}
+}
+
+// Add job into the queue of its client, the queue limit is ignored when 'force' is true
+func (scheduler *fileSealScheduler) push(job *model.SealJob, force bool) error {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	if !force && scheduler.queuedNum >= scheduler.queueLimit {
+		return fmt.Errorf("The seal queue is full")
+	}
+
+	client := job.Message.Client
+	if _, ok := scheduler.queues[client]; !ok {
+		scheduler.clients = append(scheduler.clients, client)
+	}
+	scheduler.queues[client] = append(scheduler.queues[client], job)
+	scheduler.queuedNum++
+	scheduler.updateMetrics()
+	scheduler.broadcast()
+	return nil
+}
+
+// Wait for the next job which can be dealt, nil will be returned when the context is done
+func (scheduler *fileSealScheduler) pop(ctx context.Context) *model.SealJob {
+	for {
+		scheduler.lock.Lock()
+		job := scheduler.pick()
+		changed := scheduler.changed
+		scheduler.lock.Unlock()
+
+		if job != nil {
+			return job
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Release the in-flight size of the job after it is dealt
+func (scheduler *fileSealScheduler) done(job *model.SealJob) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	client := job.Message.Client
+	scheduler.inFlightNums[client]--
+	scheduler.inFlightSizes[client] -= job.Message.MerkleTree.Size
+	if scheduler.inFlightNums[client] <= 0 {
+		delete(scheduler.inFlightNums, client)
+		delete(scheduler.inFlightSizes, client)
+	}
+	scheduler.updateMetrics()
+	scheduler.broadcast()
+}
+
+// Remove the queued job, nil will be returned if the job isn't in queue
+func (scheduler *fileSealScheduler) remove(storeOrderHash string) *model.SealJob {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	for index, client := range scheduler.clients {
+		queue := scheduler.queues[client]
+		for jobIndex, job := range queue {
+			if job.Message.StoreOrderHash != storeOrderHash {
+				continue
+			}
+
+			queue = append(queue[:jobIndex], queue[jobIndex+1:]...)
+			if len(queue) == 0 {
+				delete(scheduler.queues, client)
+				scheduler.clients = append(scheduler.clients[:index], scheduler.clients[index+1:]...)
+				if scheduler.next > index {
+					scheduler.next--
+				}
+				if len(scheduler.clients) != 0 {
+					scheduler.next = scheduler.next % len(scheduler.clients)
+				} else {
+					scheduler.next = 0
+				}
+			} else {
+				scheduler.queues[client] = queue
+			}
+			scheduler.queuedNum--
+			scheduler.updateMetrics()
+			return job
+		}
+	}
+
+	return nil
+}
+
+func (scheduler *fileSealScheduler) status() []*model.SealQueueStatus {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	statusMap := make(map[string]*model.SealQueueStatus)
+	getStatus := func(client string) *model.SealQueueStatus {
+		if _, ok := statusMap[client]; !ok {
+			statusMap[client] = &model.SealQueueStatus{Client: client}
+		}
+		return statusMap[client]
+	}
+
+	for client, queue := range scheduler.queues {
+		queueStatus := getStatus(client)
+		for _, job := range queue {
+			queueStatus.QueuedNum++
+			queueStatus.QueuedSize += job.Message.MerkleTree.Size
+		}
+	}
+
+	for client, inFlightNum := range scheduler.inFlightNums {
+		queueStatus := getStatus(client)
+		queueStatus.InFlightNum = inFlightNum
+		queueStatus.InFlightSize = scheduler.inFlightSizes[client]
+	}
+
+	statusList := make([]*model.SealQueueStatus, 0, len(statusMap))
+	for _, queueStatus := range statusMap {
+		statusList = append(statusList, queueStatus)
+	}
+	sort.Slice(statusList, func(i, j int) bool {
+		return statusList[i].Client < statusList[j].Client
+	})
+	return statusList
+}
+
+// Pick job from clients in turn, a client can't get a new job if its in-flight size will exceed the limit,
+// but one job is always allowed so that the file larger than the limit can be dealt
+func (scheduler *fileSealScheduler) pick() *model.SealJob {
+	for i := 0; i < len(scheduler.clients); i++ {
+		index := (scheduler.next + i) % len(scheduler.clients)
+		client := scheduler.clients[index]
+		queue := scheduler.queues[client]
+
+		jobIndex := 0
+		if scheduler.smallFilesFirst {
+			for j := range queue {
+				if queue[j].Message.MerkleTree.Size < queue[jobIndex].Message.MerkleTree.Size {
+					jobIndex = j
+				}
+			}
+		}
+		job := queue[jobIndex]
+
+		inFlightSize := scheduler.inFlightSizes[client]
+		if scheduler.maxInFlightSize != 0 && inFlightSize != 0 && inFlightSize+job.Message.MerkleTree.Size > scheduler.maxInFlightSize {
+			continue
+		}
+
+		// Remove job from queue
+		queue = append(queue[:jobIndex], queue[jobIndex+1:]...)
+		if len(queue) == 0 {
+			delete(scheduler.queues, client)
+			scheduler.clients = append(scheduler.clients[:index], scheduler.clients[index+1:]...)
+			scheduler.next = index
+		} else {
+			scheduler.queues[client] = queue
+			scheduler.next = index + 1
+		}
+		if len(scheduler.clients) != 0 {
+			scheduler.next = scheduler.next % len(scheduler.clients)
+		} else {
+			scheduler.next = 0
+		}
+
+		scheduler.queuedNum--
+		scheduler.inFlightNums[client]++
+		scheduler.inFlightSizes[client] += job.Message.MerkleTree.Size
+		scheduler.updateMetrics()
+		return job
+	}
+
+	return nil
+}
+
+// Set the queue depth for prometheus, the lock must be held
+func (scheduler *fileSealScheduler) updateMetrics() {
+	inFlightNum := 0
+	for _, num := range scheduler.inFlightNums {
+		inFlightNum += num
+	}
+	metrics.SealQueueJobs.WithLabelValues("queued").Set(float64(scheduler.queuedNum))
+	metrics.SealQueueJobs.WithLabelValues("in_flight").Set(float64(inFlightNum))
+}
+
+// Wake up all workers waiting for jobs
+func (scheduler *fileSealScheduler) broadcast() {
+	close(scheduler.changed)
+	scheduler.changed = make(chan struct{})
+}
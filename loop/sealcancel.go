@@ -0,0 +1,188 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"karst/filesystem"
+	"karst/logger"
+	"karst/model"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Running jobs and jobs waiting for retry can be canceled by their contexts, the job between them is marked and canceled
+// when it is popped
+var runningSealJobs = make(map[string]context.CancelFunc)
+var retryingSealJobs = make(map[string]context.CancelFunc)
+var canceledSealJobs = make(map[string]bool)
+var sealJobsCancelLock sync.Mutex
+
+// Cancel the seal job, 'client' must be the owner of the job, the merchant can cancel any job with empty 'client'.
+// A queued job is removed at once and a running job is canceled at the next stage boundary
+func CancelFileSealJob(storeOrderHash string, client string, fs filesystem.FsInterface) (int, error) {
+	if fileSealJobs == nil {
+		return 500, fmt.Errorf("The seal loop doesn't start")
+	}
+
+	sealJob, err := model.GetSealJobFromDb(storeOrderHash, fileSealDb)
+	if err != nil {
+		// The job in dead list can be canceled directly
+		sealDeadJob, err := model.GetSealDeadJobFromDb(storeOrderHash, fileSealDb)
+		if err != nil {
+			return 404, fmt.Errorf("Can't find the seal job of '%s'", storeOrderHash)
+		}
+
+		if client != "" && client != sealDeadJob.Message.Client {
+			return 403, fmt.Errorf("The seal job of '%s' doesn't belong to '%s'", storeOrderHash, client)
+		}
+
+		if err = purgeFileSealDeadJob(sealDeadJob, fs, model.SealJobStageCanceled, fmt.Sprintf("Canceled in dead list, the last error is: %s", sealDeadJob.Error)); err != nil {
+			return 500, err
+		}
+		return 200, nil
+	}
+
+	if client != "" && client != sealJob.Message.Client {
+		return 403, fmt.Errorf("The seal job of '%s' doesn't belong to '%s'", storeOrderHash, client)
+	}
+
+	if sealJob.IsFinished() {
+		return 400, fmt.Errorf("The seal job of '%s' has been finished, its stage is '%s'", storeOrderHash, sealJob.Stage)
+	}
+
+	// Save the mark, so that the job will be canceled after restart
+	sealJob.Canceled = true
+	if err = sealJob.SaveToDb(fileSealDb); err != nil {
+		return 500, err
+	}
+
+	// The job waiting for retry is removed at once, the worker may still be running but it won't touch the job again
+	sealJobsCancelLock.Lock()
+	if cancel, ok := retryingSealJobs[storeOrderHash]; ok {
+		delete(retryingSealJobs, storeOrderHash)
+		cancel()
+		sealJobsCancelLock.Unlock()
+		cancelWaitingSealJob(sealJob, fs)
+		return 200, nil
+	}
+
+	if cancel, ok := runningSealJobs[storeOrderHash]; ok {
+		canceledSealJobs[storeOrderHash] = true
+		cancel()
+		sealJobsCancelLock.Unlock()
+		logger.Info("The running seal job '%s' will be canceled at the next stage boundary", storeOrderHash)
+		return 200, nil
+	}
+
+	queuedSealJob := fileSealJobs.remove(storeOrderHash)
+	if queuedSealJob == nil {
+		// The job is being popped or put back
+		canceledSealJobs[storeOrderHash] = true
+		sealJobsCancelLock.Unlock()
+		logger.Info("The seal job '%s' will be canceled when it is dealt", storeOrderHash)
+		return 200, nil
+	}
+	sealJobsCancelLock.Unlock()
+
+	cancelWaitingSealJob(queuedSealJob, fs)
+	return 200, nil
+}
+
+// Cancel the job which isn't dealt by workers
+func cancelWaitingSealJob(job *model.SealJob, fs filesystem.FsInterface) {
+	lockSealHash(job.Message.MerkleTree.Hash)
+	defer unlockSealHash(job.Message.MerkleTree.Hash)
+	fileInfo := &model.FileInfo{
+		MerkleTree:       job.Message.MerkleTree,
+		MerkleTreeSealed: job.MerkleTreeSealed,
+		SealedPath:       job.SealedPath,
+	}
+	cancelFileSealJob(job, fileInfo, fileSealDb, fs)
+}
+
+// Create the context of a running job, the context is canceled at once if the job has been canceled
+func startSealJobContext(ctx context.Context, job *model.SealJob) context.Context {
+	jobCtx, cancel := context.WithCancel(ctx)
+
+	sealJobsCancelLock.Lock()
+	defer sealJobsCancelLock.Unlock()
+	runningSealJobs[job.Message.StoreOrderHash] = cancel
+	if canceledSealJobs[job.Message.StoreOrderHash] {
+		cancel()
+	}
+	return jobCtx
+}
+
+// Wait for the backoff of job, false is returned if the job is canceled or the loop is stopped. The job is
+// unregistered before it is put back, so that it is either put back or canceled
+func waitSealJobRetry(job *model.SealJob, backoff time.Duration) bool {
+	retryCtx, cancel := context.WithCancel(fileSealCtx)
+	defer cancel()
+
+	// The job canceled while it was still running is put back at once and canceled when it is popped
+	sealJobsCancelLock.Lock()
+	if canceledSealJobs[job.Message.StoreOrderHash] {
+		sealJobsCancelLock.Unlock()
+		return true
+	}
+	retryingSealJobs[job.Message.StoreOrderHash] = cancel
+	sealJobsCancelLock.Unlock()
+
+	select {
+	case <-time.After(backoff):
+	case <-retryCtx.Done():
+	}
+
+	sealJobsCancelLock.Lock()
+	defer sealJobsCancelLock.Unlock()
+	if _, ok := retryingSealJobs[job.Message.StoreOrderHash]; !ok {
+		return false
+	}
+	delete(retryingSealJobs, job.Message.StoreOrderHash)
+	return retryCtx.Err() == nil
+}
+
+func stopSealJobContext(job *model.SealJob) {
+	sealJobsCancelLock.Lock()
+	defer sealJobsCancelLock.Unlock()
+	if cancel, ok := runningSealJobs[job.Message.StoreOrderHash]; ok {
+		cancel()
+		delete(runningSealJobs, job.Message.StoreOrderHash)
+	}
+}
+
+// Mark the job which has been canceled before restart
+func markSealJobCanceled(job *model.SealJob) {
+	sealJobsCancelLock.Lock()
+	defer sealJobsCancelLock.Unlock()
+	canceledSealJobs[job.Message.StoreOrderHash] = true
+}
+
+func isSealJobCanceled(job *model.SealJob) bool {
+	sealJobsCancelLock.Lock()
+	defer sealJobsCancelLock.Unlock()
+	return canceledSealJobs[job.Message.StoreOrderHash]
+}
+
+// Remove all data of the job, including the original file in fs, and keep the canceled record for clients
+func cancelFileSealJob(job *model.SealJob, fileInfo *model.FileInfo, db *leveldb.DB, fs filesystem.FsInterface) {
+	logger.Info("The seal job '%s' is canceled in stage '%s'", job.Message.StoreOrderHash, job.Stage)
+	fileInfo.ClearOriginalFile()
+	fileInfo.ClearSealedFile()
+	if job.Stage == model.SealJobStageStored {
+		_ = fileInfo.DeleteSealedFileFromFs(fs)
+		fileInfo.ClearDb(db)
+	}
+	deleteSealJobOriginalFile(job, fileInfo, db, fs)
+
+	job.MerkleTreeSealed = nil
+	job.SealedPath = ""
+	job.SealedHash = ""
+	saveSealJob(job, model.SealJobStageCanceled, db)
+
+	sealJobsCancelLock.Lock()
+	delete(canceledSealJobs, job.Message.StoreOrderHash)
+	sealJobsCancelLock.Unlock()
+}
@@ -0,0 +1,117 @@
+package loop
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"karst/cache"
+	"karst/config"
+	"karst/filesystem"
+	"karst/merkletree"
+	"karst/model"
+	"karst/sworker"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestCancelSealJobWaitingForRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "karst-loop-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Configuration{}
+	cfg.KarstPaths.SealFilesPath = filepath.Join(dir, "seal_files")
+	cfg.Fs.FsFlag = config.LOCAL_FLAG
+	cfg.Fs.Local.Path = filepath.Join(dir, "fs")
+	cfg.Sworker.SealWorkersNum = 1
+
+	db, err := leveldb.OpenFile(filepath.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fs, err := filesystem.GetFs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	cache.SetBasePath(dir)
+
+	// The second part isn't uploaded, so getting the file fails and the job waits for retry
+	parts := [][]byte{bytes.Repeat([]byte("karst"), 100), bytes.Repeat([]byte("crust"), 100)}
+	hashs := make([][]byte, 0)
+	sizes := make([]uint64, 0)
+	for _, part := range parts {
+		hash := sha256.Sum256(part)
+		hashs = append(hashs, hash[:])
+		sizes = append(sizes, uint64(len(part)))
+	}
+	mt := merkletree.CreateMerkleTree(hashs, sizes, 2)
+	leaves := mt.Leaves()
+	if leaves[0].StoredKey, err = fs.PutReader(bytes.NewReader(parts[0]), sizes[0]); err != nil {
+		t.Fatal(err)
+	}
+	leaves[1].StoredKey = leaves[1].Hash
+	if err = model.GrantLocalFsKeys("client", mt.StoredKeys(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	sealLoop := StartFileSealLoop(cfg, db, fs, sworker.NewClient(cfg))
+	defer func() {
+		sealLoop.Stop()
+		fileSealJobs = nil
+		fileSealDb = nil
+	}()
+
+	job := model.FileSealMessage{
+		Client:         "client",
+		StoreOrderHash: "store_order_hash",
+		MerkleTree:     mt,
+	}
+	if err = TryEnqueueFileSealJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	isRetrying := func() bool {
+		sealJobsCancelLock.Lock()
+		defer sealJobsCancelLock.Unlock()
+		_, ok := retryingSealJobs[job.StoreOrderHash]
+		return ok
+	}
+	for timeStart := time.Now(); !isRetrying(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(timeStart) > 5*time.Second {
+			t.Fatal("The seal job doesn't wait for retry")
+		}
+	}
+
+	// The job is cleaned up at once, not after the backoff
+	if status, err := CancelFileSealJob(job.StoreOrderHash, job.Client, fs); status != 200 {
+		t.Fatalf("Cancel seal job returns %d: %s", status, err)
+	}
+	if isRetrying() {
+		t.Fatal("The canceled seal job is still waiting for retry")
+	}
+
+	sealJob, err := model.GetSealJobFromDb(job.StoreOrderHash, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealJob.Stage != model.SealJobStageCanceled {
+		t.Fatalf("The stage of canceled seal job is '%s'", sealJob.Stage)
+	}
+	if _, err = fs.GetToBuffer(leaves[0].StoredKey, sizes[0]); err == nil {
+		t.Fatal("The uploaded part is still in fs after the seal job is canceled")
+	}
+	for _, key := range mt.StoredKeys() {
+		if model.IsLocalFsKeyGranted("client", key, db) {
+			t.Fatalf("The part '%s' is still granted to client after the seal job is canceled", key)
+		}
+	}
+}
@@ -3,6 +3,11 @@ package merkletree
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+)
+
+const (
+	DefaultMaxLinksNum = 1024
 )
 
 type MerkleTreeNode struct {
@@ -23,29 +28,55 @@ func NewMerkleTreeNode(hash []byte, size uint64) *MerkleTreeNode {
 	}
 }
 
-// TODO: Multiple depth tree, currently only supports single-layer tree
-func CreateMerkleTree(hashs [][]byte, sizes []uint64) *MerkleTreeNode {
+// Create a parent node whose hash is the sha256 of all links' hashes
+func newParentMerkleTreeNode(links []MerkleTreeNode) *MerkleTreeNode {
 	allHashs := make([]byte, 0)
 	var totalSize uint64 = 0
-	var linksNum uint64 = 0
-	links := make([]MerkleTreeNode, 0)
 
-	for index := range hashs {
-		links = append(links, *NewMerkleTreeNode(hashs[index], sizes[index]))
-		totalSize = totalSize + sizes[index]
-		linksNum = linksNum + 1
-		allHashs = append(allHashs, hashs[index]...)
+	for index := range links {
+		totalSize = totalSize + links[index].Size
+		allHashs = append(allHashs, links[index].HashBytes()...)
 	}
 
 	hashBytes := sha256.Sum256(allHashs)
 	return &MerkleTreeNode{
 		Hash:     hex.EncodeToString(hashBytes[:]),
 		Size:     totalSize,
-		LinksNum: linksNum,
+		LinksNum: uint64(len(links)),
 		Links:    links,
 	}
 }
 
+// Create a merkle tree whose every node has 'maxLinksNum' links at most, leaves are file parts
+func CreateMerkleTree(hashs [][]byte, sizes []uint64, maxLinksNum uint64) *MerkleTreeNode {
+	if maxLinksNum < 2 {
+		maxLinksNum = DefaultMaxLinksNum
+	}
+
+	nodes := make([]MerkleTreeNode, 0)
+	for index := range hashs {
+		nodes = append(nodes, *NewMerkleTreeNode(hashs[index], sizes[index]))
+	}
+
+	// Build intermediate layers until the root can hold all nodes
+	for uint64(len(nodes)) > maxLinksNum {
+		parents := make([]MerkleTreeNode, 0)
+		for begin := uint64(0); begin < uint64(len(nodes)); begin = begin + maxLinksNum {
+			end := begin + maxLinksNum
+			if end > uint64(len(nodes)) {
+				end = uint64(len(nodes))
+			}
+
+			links := make([]MerkleTreeNode, end-begin)
+			copy(links, nodes[begin:end])
+			parents = append(parents, *newParentMerkleTreeNode(links))
+		}
+		nodes = parents
+	}
+
+	return newParentMerkleTreeNode(nodes)
+}
+
 func (mt *MerkleTreeNode) HashBytes() []byte {
 	hashBytes, _ := hex.DecodeString(mt.Hash)
 	return hashBytes
@@ -56,6 +87,10 @@ func (mt *MerkleTreeNode) IsLegal() bool {
 		return true
 	}
 
+	if mt.LinksNum != uint64(len(mt.Links)) {
+		return false
+	}
+
 	allHashs := make([]byte, 0)
 	var totalSize uint64 = 0
 
@@ -72,3 +107,70 @@ func (mt *MerkleTreeNode) IsLegal() bool {
 	allHashsBytes := sha256.Sum256(allHashs)
 	return mt.Size == totalSize && mt.Hash == hex.EncodeToString(allHashsBytes[:])
 }
+
+// Return all leaves (file parts) under this node in order, the root itself is never a leaf
+func (mt *MerkleTreeNode) Leaves() []*MerkleTreeNode {
+	leaves := make([]*MerkleTreeNode, 0)
+	for index := range mt.Links {
+		if mt.Links[index].LinksNum == 0 {
+			leaves = append(leaves, &mt.Links[index])
+		} else {
+			leaves = append(leaves, mt.Links[index].Leaves()...)
+		}
+	}
+	return leaves
+}
+
+// Return the keys of leaves (file parts) stored in fs in order
+func (mt *MerkleTreeNode) StoredKeys() []string {
+	keys := make([]string, 0)
+	for _, leaf := range mt.Leaves() {
+		keys = append(keys, leaf.StoredKey)
+	}
+	return keys
+}
+
+// Return the number of leaves (file parts) under this node
+func (mt *MerkleTreeNode) LeavesNum() uint64 {
+	var leavesNum uint64 = 0
+	for index := range mt.Links {
+		if mt.Links[index].LinksNum == 0 {
+			leavesNum = leavesNum + 1
+		} else {
+			leavesNum = leavesNum + mt.Links[index].LeavesNum()
+		}
+	}
+	return leavesNum
+}
+
+// Return the max number of links of nodes, it is 'maxLinksNum' of CreateMerkleTree if the tree has more than two layers
+func (mt *MerkleTreeNode) MaxLinksNum() uint64 {
+	maxLinksNum := mt.LinksNum
+	for index := range mt.Links {
+		if linksNum := mt.Links[index].MaxLinksNum(); linksNum > maxLinksNum {
+			maxLinksNum = linksNum
+		}
+	}
+	return maxLinksNum
+}
+
+// Get the leaf (file part) by its index in all leaves
+func (mt *MerkleTreeNode) GetLeaf(index uint64) (*MerkleTreeNode, error) {
+	for i := range mt.Links {
+		if mt.Links[i].LinksNum == 0 {
+			if index == 0 {
+				return &mt.Links[i], nil
+			}
+			index = index - 1
+			continue
+		}
+
+		leavesNum := mt.Links[i].LeavesNum()
+		if index < leavesNum {
+			return mt.Links[i].GetLeaf(index)
+		}
+		index = index - leavesNum
+	}
+
+	return nil, fmt.Errorf("Leaf index is out of range")
+}
@@ -0,0 +1,159 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+type MerkleProofLink struct {
+	Hash string `json:"hash"`
+	Size uint64 `json:"size"`
+}
+
+// One layer of the path from leaf to root, 'Links' are the siblings of the node on the path
+// and 'Position' is where the node on the path is placed among them
+type MerkleProofLayer struct {
+	Position uint64            `json:"position"`
+	Links    []MerkleProofLink `json:"links"`
+}
+
+// Layers are ordered from leaf to root
+type MerkleProof struct {
+	Layers []MerkleProofLayer `json:"layers"`
+}
+
+// Generate the proof that the leaf with 'index' belongs to this tree
+func (mt *MerkleTreeNode) GenerateProof(index uint64) (*MerkleProof, error) {
+	if mt.LinksNum == 0 {
+		return nil, fmt.Errorf("Leaf index is out of range")
+	}
+
+	for i := range mt.Links {
+		leavesNum := uint64(1)
+		if mt.Links[i].LinksNum != 0 {
+			leavesNum = mt.Links[i].LeavesNum()
+		}
+
+		if index >= leavesNum {
+			index = index - leavesNum
+			continue
+		}
+
+		proof := &MerkleProof{
+			Layers: make([]MerkleProofLayer, 0),
+		}
+		if mt.Links[i].LinksNum != 0 {
+			subProof, err := mt.Links[i].GenerateProof(index)
+			if err != nil {
+				return nil, err
+			}
+			proof = subProof
+		}
+
+		layer := MerkleProofLayer{
+			Position: uint64(i),
+			Links:    make([]MerkleProofLink, 0),
+		}
+		for j := range mt.Links {
+			if j == i {
+				continue
+			}
+			layer.Links = append(layer.Links, MerkleProofLink{
+				Hash: mt.Links[j].Hash,
+				Size: mt.Links[j].Size,
+			})
+		}
+
+		proof.Layers = append(proof.Layers, layer)
+		return proof, nil
+	}
+
+	return nil, fmt.Errorf("Leaf index is out of range")
+}
+
+// Verify that the leaf ('leafHash', 'leafSize') is the leaf with 'index' of the tree whose root is ('rootHash', 'rootSize'),
+// the tree must be created by CreateMerkleTree with 'leavesNum' leaves and 'maxLinksNum', so the position and the number
+// of links in each layer are calculated from the index instead of being trusted from the proof
+func (proof *MerkleProof) Verify(rootHash string, rootSize uint64, leavesNum uint64, maxLinksNum uint64, leafHash string, leafSize uint64, index uint64) error {
+	if proof == nil || len(proof.Layers) == 0 {
+		return fmt.Errorf("The proof is empty")
+	}
+
+	if maxLinksNum < 2 {
+		maxLinksNum = DefaultMaxLinksNum
+	}
+
+	if index >= leavesNum {
+		return fmt.Errorf("Leaf index %d is out of range, the number of leaves is %d", index, leavesNum)
+	}
+
+	layerNodesNums := getLayerNodesNums(leavesNum, maxLinksNum)
+	if len(proof.Layers) != len(layerNodesNums) {
+		return fmt.Errorf("The proof has %d layers, but the tree has %d layers", len(proof.Layers), len(layerNodesNums))
+	}
+
+	hash := leafHash
+	var size uint64 = leafSize
+	nodeIndex := index
+
+	for l, layer := range proof.Layers {
+		// Nodes are grouped by 'maxLinksNum' in order, only the last group can be smaller
+		groupBegin := nodeIndex / maxLinksNum * maxLinksNum
+		groupEnd := groupBegin + maxLinksNum
+		if groupEnd > layerNodesNums[l] {
+			groupEnd = layerNodesNums[l]
+		}
+
+		if layer.Position != nodeIndex-groupBegin || uint64(len(layer.Links)) != groupEnd-groupBegin-1 {
+			return fmt.Errorf("The layer %d of proof doesn't match leaf %d", l, index)
+		}
+
+		hashBytes, err := hex.DecodeString(hash)
+		if err != nil {
+			return fmt.Errorf("Illegal hash '%s' in proof: %s", hash, err)
+		}
+
+		allHashs := make([]byte, 0)
+		for i := uint64(0); i <= uint64(len(layer.Links)); i++ {
+			if i == layer.Position {
+				allHashs = append(allHashs, hashBytes...)
+				continue
+			}
+
+			var link MerkleProofLink
+			if i < layer.Position {
+				link = layer.Links[i]
+			} else {
+				link = layer.Links[i-1]
+			}
+
+			linkHashBytes, err := hex.DecodeString(link.Hash)
+			if err != nil {
+				return fmt.Errorf("Illegal hash '%s' in proof: %s", link.Hash, err)
+			}
+			allHashs = append(allHashs, linkHashBytes...)
+			size = size + link.Size
+		}
+
+		allHashsBytes := sha256.Sum256(allHashs)
+		hash = hex.EncodeToString(allHashsBytes[:])
+		nodeIndex = nodeIndex / maxLinksNum
+	}
+
+	if hash != rootHash || size != rootSize {
+		return fmt.Errorf("The proof doesn't match root '%s', calculated root is '%s'", rootHash, hash)
+	}
+
+	return nil
+}
+
+// Return the number of nodes in each layer under root from leaves up, like CreateMerkleTree builds them
+func getLayerNodesNums(leavesNum uint64, maxLinksNum uint64) []uint64 {
+	layerNodesNums := []uint64{leavesNum}
+	for nodesNum := leavesNum; nodesNum > maxLinksNum; {
+		nodesNum = (nodesNum + maxLinksNum - 1) / maxLinksNum
+		layerNodesNums = append(layerNodesNums, nodesNum)
+	}
+	return layerNodesNums
+}
@@ -0,0 +1,155 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func createTestMerkleTree(leavesNum uint64, maxLinksNum uint64) *MerkleTreeNode {
+	hashs := make([][]byte, 0, leavesNum)
+	sizes := make([]uint64, 0, leavesNum)
+	for i := uint64(0); i < leavesNum; i++ {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, i)
+		hash := sha256.Sum256(data)
+		hashs = append(hashs, hash[:])
+		sizes = append(sizes, 100+i)
+	}
+	return CreateMerkleTree(hashs, sizes, maxLinksNum)
+}
+
+func TestProofOfEveryLeaf(t *testing.T) {
+	for _, maxLinksNum := range []uint64{2, 3, 4} {
+		for leavesNum := uint64(1); leavesNum <= 30; leavesNum++ {
+			mt := createTestMerkleTree(leavesNum, maxLinksNum)
+			if mt.MaxLinksNum() > maxLinksNum {
+				t.Fatalf("Max links num is %d, expected at most %d", mt.MaxLinksNum(), maxLinksNum)
+			}
+
+			for index, leaf := range mt.Leaves() {
+				proof, err := mt.GenerateProof(uint64(index))
+				if err != nil {
+					t.Fatalf("Generate proof of leaf %d in %d leaves failed: %s", index, leavesNum, err)
+				}
+
+				err = proof.Verify(mt.Hash, mt.Size, leavesNum, mt.MaxLinksNum(), leaf.Hash, leaf.Size, uint64(index))
+				if err != nil {
+					t.Fatalf("Verify proof of leaf %d in %d leaves (max links %d) failed: %s", index, leavesNum, maxLinksNum, err)
+				}
+			}
+
+			if _, err := mt.GenerateProof(leavesNum); err == nil {
+				t.Fatalf("Proof of leaf %d out of %d leaves is generated", leavesNum, leavesNum)
+			}
+		}
+	}
+}
+
+func TestProofOfWrongIndex(t *testing.T) {
+	mt := createTestMerkleTree(20, 3)
+	leaves := mt.Leaves()
+	for index, leaf := range leaves {
+		proof, err := mt.GenerateProof(uint64(index))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for claimedIndex := range leaves {
+			if claimedIndex == index {
+				continue
+			}
+			if err = proof.Verify(mt.Hash, mt.Size, 20, 3, leaf.Hash, leaf.Size, uint64(claimedIndex)); err == nil {
+				t.Fatalf("Proof of leaf %d is accepted as leaf %d", index, claimedIndex)
+			}
+		}
+	}
+}
+
+func TestTamperedProof(t *testing.T) {
+	const leavesNum, maxLinksNum = 20, 3
+	mt := createTestMerkleTree(leavesNum, maxLinksNum)
+	leaves := mt.Leaves()
+	const index = 10
+	leaf := leaves[index]
+
+	tamperCases := map[string]func(proof *MerkleProof){
+		"sibling hash": func(proof *MerkleProof) {
+			proof.Layers[0].Links[0].Hash = strings.Repeat("0", 64)
+		},
+		"sibling size": func(proof *MerkleProof) {
+			proof.Layers[1].Links[0].Size++
+		},
+		"position": func(proof *MerkleProof) {
+			proof.Layers[0].Position = (proof.Layers[0].Position + 1) % uint64(len(proof.Layers[0].Links)+1)
+		},
+		"swapped siblings": func(proof *MerkleProof) {
+			links := proof.Layers[1].Links
+			links[0], links[1] = links[1], links[0]
+		},
+		"missing sibling": func(proof *MerkleProof) {
+			proof.Layers[0].Links = proof.Layers[0].Links[1:]
+		},
+		"missing layer": func(proof *MerkleProof) {
+			proof.Layers = proof.Layers[:len(proof.Layers)-1]
+		},
+		"extra layer": func(proof *MerkleProof) {
+			proof.Layers = append(proof.Layers, MerkleProofLayer{Position: 0, Links: []MerkleProofLink{}})
+		},
+		"illegal hash": func(proof *MerkleProof) {
+			proof.Layers[2].Links[0].Hash = "not hex"
+		},
+	}
+
+	for name, tamper := range tamperCases {
+		proof, err := mt.GenerateProof(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(proof)
+		if err = proof.Verify(mt.Hash, mt.Size, leavesNum, maxLinksNum, leaf.Hash, leaf.Size, index); err == nil {
+			t.Fatalf("Proof with tampered %s is accepted", name)
+		}
+	}
+
+	proof, err := mt.GenerateProof(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = proof.Verify(mt.Hash, mt.Size, leavesNum, maxLinksNum, leaves[index+1].Hash, leaf.Size, index); err == nil {
+		t.Fatal("Proof is accepted for the wrong leaf hash")
+	}
+
+	if err = proof.Verify(mt.Hash, mt.Size, leavesNum, maxLinksNum, leaf.Hash, leaf.Size+1, index); err == nil {
+		t.Fatal("Proof is accepted for the wrong leaf size")
+	}
+
+	// The tree with more leaves has another layer
+	if err = proof.Verify(mt.Hash, mt.Size, leavesNum*maxLinksNum, maxLinksNum, leaf.Hash, leaf.Size, index); err == nil {
+		t.Fatal("Proof is accepted for the wrong number of leaves")
+	}
+
+	// Leaf 18 has one sibling in the last group, but it has no sibling if there are 19 leaves
+	lastProof, err := mt.GenerateProof(leavesNum - 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lastProof.Verify(mt.Hash, mt.Size, leavesNum-1, maxLinksNum, leaves[leavesNum-2].Hash, leaves[leavesNum-2].Size, leavesNum-2); err == nil {
+		t.Fatal("Proof of the last group is accepted for the wrong number of leaves")
+	}
+
+	if err = proof.Verify(mt.Hash, mt.Size, leavesNum, maxLinksNum+1, leaf.Hash, leaf.Size, index); err == nil {
+		t.Fatal("Proof is accepted for the wrong max links num")
+	}
+
+	if err = proof.Verify(mt.Hash, mt.Size, leavesNum, maxLinksNum, leaf.Hash, leaf.Size, leavesNum); err == nil {
+		t.Fatal("Proof is accepted for the index out of range")
+	}
+
+	var emptyProof *MerkleProof
+	if err = emptyProof.Verify(mt.Hash, mt.Size, leavesNum, maxLinksNum, leaf.Hash, leaf.Size, index); err == nil {
+		t.Fatal("Empty proof is accepted")
+	}
+}
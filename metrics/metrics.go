@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "karst"
+	Path      = "/metrics"
+)
+
+// Seal jobs
+var (
+	SealQueueJobs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "seal",
+		Name:      "queue_jobs",
+		Help:      "Number of seal jobs in the queue, state is 'queued' or 'in_flight'.",
+	}, []string{"state"})
+
+	SealJobStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "seal",
+		Name:      "job_stage_duration_seconds",
+		Help:      "Time spent by seal jobs to reach each stage from the previous one.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
+	}, []string{"stage"})
+)
+
+// Sworker
+var (
+	SworkerRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "sworker",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of sworker calls including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"api"})
+
+	SworkerResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sworker",
+		Name:      "responses_total",
+		Help:      "Responses of sworker by status code, code is 'error' if sworker can't be reached.",
+	}, []string{"api", "code"})
+)
+
+// Filesystem
+var (
+	FsOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "fs",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of fs operations, streamed gets are measured until the reader is closed.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"backend", "operation"})
+
+	FsOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "fs",
+		Name:      "operation_errors_total",
+		Help:      "Failed fs operations.",
+	}, []string{"backend", "operation"})
+
+	FsBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "fs",
+		Name:      "bytes_total",
+		Help:      "Bytes put into and got from fs.",
+	}, []string{"backend", "operation"})
+)
+
+// Cache
+var (
+	CacheLockedBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "locked_bytes",
+		Help:      "Disk space locked by files being sealed or unsealed.",
+	})
+)
+
+// Websocket
+var (
+	NodeDataParts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "node_data",
+		Name:      "parts_served_total",
+		Help:      "Parts served by /node/data.",
+	})
+
+	NodeDataBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "node_data",
+		Name:      "bytes_served_total",
+		Help:      "Bytes of parts served by /node/data.",
+	})
+
+	WsConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ws",
+		Name:      "active_connections",
+		Help:      "Active websocket connections by path.",
+	}, []string{"path"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Count the connection as active while the websocket handler is running
+func InstrumentWsHandler(path string, handler http.HandlerFunc) http.HandlerFunc {
+	connections := WsConnections.WithLabelValues(path)
+	return func(w http.ResponseWriter, r *http.Request) {
+		connections.Inc()
+		defer connections.Dec()
+		handler(w, r)
+	}
+}
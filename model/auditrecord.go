@@ -0,0 +1,50 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	AuditRecordFlagInDb = "audit"
+)
+
+// AuditRecord is saved by client when the file is finished, the parts which merchant returns in audits must be proved
+// to be the leaves of its sealed root. The shape of sealed tree is returned by merchant at finish, the sealed tree may be
+// built with another 'MaxLinksNum' by sworker
+type AuditRecord struct {
+	FileHash    string `json:"file_hash"`
+	Merchant    string `json:"merchant"`
+	SealedHash  string `json:"sealed_hash"`
+	SealedSize  uint64 `json:"sealed_size"`
+	LeavesNum   uint64 `json:"leaves_num"`
+	MaxLinksNum uint64 `json:"max_links_num"`
+}
+
+func GetAuditRecordFromDb(fileHash string, merchant string, db *leveldb.DB) (*AuditRecord, error) {
+	key := AuditRecordFlagInDb + merchant + fileHash
+	if ok, _ := db.Has([]byte(key), nil); !ok {
+		return nil, fmt.Errorf("The audit record of '%s' in '%s' not stored in db", fileHash, merchant)
+	}
+
+	auditRecordBytes, err := db.Get([]byte(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	auditRecord := AuditRecord{}
+	if err = json.Unmarshal(auditRecordBytes, &auditRecord); err != nil {
+		return nil, err
+	}
+	return &auditRecord, nil
+}
+
+func (auditRecord *AuditRecord) SaveToDb(db *leveldb.DB) error {
+	auditRecordBytes, err := json.Marshal(auditRecord)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(AuditRecordFlagInDb+auditRecord.Merchant+auditRecord.FileHash), auditRecordBytes, nil)
+}
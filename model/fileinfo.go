@@ -82,12 +82,12 @@ func (fileInfo *FileInfo) PutOriginalFileIntoFs(fs filesystem.FsInterface) error
 		return fmt.Errorf("'MerkleTree' or 'OriginalPath' is nil")
 	}
 
-	for i := range fileInfo.MerkleTree.Links {
-		key, err := fs.Put(filepath.FromSlash(fileInfo.OriginalPath + "/" + strconv.FormatInt(int64(i), 10) + "_" + fileInfo.MerkleTree.Links[i].Hash))
+	for i, leaf := range fileInfo.MerkleTree.Leaves() {
+		key, err := filesystem.PutPartFromFile(fs, filepath.FromSlash(fileInfo.OriginalPath+"/"+strconv.FormatInt(int64(i), 10)+"_"+leaf.Hash), leaf.Size)
 		if err != nil {
 			return err
 		}
-		fileInfo.MerkleTree.Links[i].StoredKey = key
+		leaf.StoredKey = key
 	}
 	return nil
 }
@@ -97,12 +97,12 @@ func (fileInfo *FileInfo) PutSealedFileIntoFs(fs filesystem.FsInterface) error {
 		return fmt.Errorf("'MerkleTreeSealed' or 'SealedPath' is nil")
 	}
 
-	for i := range fileInfo.MerkleTreeSealed.Links {
-		key, err := fs.Put(filepath.FromSlash(fileInfo.SealedPath + "/" + strconv.FormatInt(int64(i), 10) + "_" + fileInfo.MerkleTreeSealed.Links[i].Hash))
+	for i, leaf := range fileInfo.MerkleTreeSealed.Leaves() {
+		key, err := filesystem.PutPartFromFile(fs, filepath.FromSlash(fileInfo.SealedPath+"/"+strconv.FormatInt(int64(i), 10)+"_"+leaf.Hash), leaf.Size)
 		if err != nil {
 			return err
 		}
-		fileInfo.MerkleTreeSealed.Links[i].StoredKey = key
+		leaf.StoredKey = key
 	}
 	return nil
 }
@@ -112,8 +112,8 @@ func (fileInfo *FileInfo) GetOriginalFileFromFs(fs filesystem.FsInterface) error
 		return fmt.Errorf("'OriginalPath' or 'MerkleTree' is nil")
 	}
 
-	for i := range fileInfo.MerkleTree.Links {
-		if err := fs.Get(fileInfo.MerkleTree.Links[i].StoredKey, filepath.FromSlash(fileInfo.OriginalPath+"/"+strconv.FormatInt(int64(i), 10)+"_"+fileInfo.MerkleTree.Links[i].Hash)); err != nil {
+	for i, leaf := range fileInfo.MerkleTree.Leaves() {
+		if err := filesystem.GetPartToFile(fs, leaf.StoredKey, filepath.FromSlash(fileInfo.OriginalPath+"/"+strconv.FormatInt(int64(i), 10)+"_"+leaf.Hash), leaf.Size); err != nil {
 			return err
 		}
 	}
@@ -125,8 +125,8 @@ func (fileInfo *FileInfo) GetSealedFileFromFs(fs filesystem.FsInterface) error {
 		return fmt.Errorf("'SealedPath' or 'MerkleTreeSealed' is nil")
 	}
 
-	for i := range fileInfo.MerkleTreeSealed.Links {
-		if err := fs.Get(fileInfo.MerkleTreeSealed.Links[i].StoredKey, filepath.FromSlash(fileInfo.SealedPath+"/"+strconv.FormatInt(int64(i), 10)+"_"+fileInfo.MerkleTreeSealed.Links[i].Hash)); err != nil {
+	for i, leaf := range fileInfo.MerkleTreeSealed.Leaves() {
+		if err := filesystem.GetPartToFile(fs, leaf.StoredKey, filepath.FromSlash(fileInfo.SealedPath+"/"+strconv.FormatInt(int64(i), 10)+"_"+leaf.Hash), leaf.Size); err != nil {
 			return err
 		}
 	}
@@ -138,8 +138,8 @@ func (fileInfo *FileInfo) DeleteOriginalFileFromFs(fs filesystem.FsInterface) er
 		return fmt.Errorf("'MerkleTree' is nil")
 	}
 
-	for i := range fileInfo.MerkleTree.Links {
-		err := fs.Delete(fileInfo.MerkleTree.Links[i].StoredKey)
+	for _, leaf := range fileInfo.MerkleTree.Leaves() {
+		err := fs.Delete(leaf.StoredKey)
 		if err != nil {
 			return err
 		}
@@ -152,8 +152,8 @@ func (fileInfo *FileInfo) DeleteSealedFileFromFs(fs filesystem.FsInterface) erro
 		return fmt.Errorf("'MerkleTreeSealed' is nil")
 	}
 
-	for i := range fileInfo.MerkleTreeSealed.Links {
-		err := fs.Delete(fileInfo.MerkleTreeSealed.Links[i].StoredKey)
+	for _, leaf := range fileInfo.MerkleTreeSealed.Leaves() {
+		err := fs.Delete(leaf.StoredKey)
 		if err != nil {
 			return err
 		}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type FileStatus struct {
@@ -15,9 +16,9 @@ type FileStatus struct {
 
 func GetFileStatusList(db *leveldb.DB) ([]FileStatus, error) {
 	fileStatusList := make([]FileStatus, 0)
-	iter := db.NewIterator(nil, nil)
-	prefix := []byte(SealedFileFlagInDb)
-	for ok := iter.Seek(prefix); ok; ok = iter.Next() {
+	iter := db.NewIterator(util.BytesPrefix([]byte(SealedFileFlagInDb)), nil)
+	defer iter.Release()
+	for iter.Next() {
 		fileInfo := FileInfo{}
 		if err := json.Unmarshal(iter.Value(), &fileInfo); err != nil {
 			return nil, err
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	LocalFsSignPath      = "/api/v0/fs/local"
+	LocalFsRequestHeader = "X-Karst-Request"
+	LocalFsGrantFlagInDb = "local_fs_grant"
+)
+
+// LocalFsRequest is signed by client and sent in the header of each request to local fs served by karst,
+// 'Key' is the part to get and 'Size' is the size of part to put
+type LocalFsRequest struct {
+	Client string `json:"client"`
+	Method string `json:"method"`
+	Key    string `json:"key"`
+	Size   uint64 `json:"size"`
+	RequestSignature
+}
+
+func NewLocalFsRequest(header string) (*LocalFsRequest, error) {
+	if header == "" {
+		return nil, fmt.Errorf("The header '%s' is needed", LocalFsRequestHeader)
+	}
+
+	var localFsReq LocalFsRequest
+	if err := json.Unmarshal([]byte(header), &localFsReq); err != nil {
+		return nil, err
+	}
+	return &localFsReq, nil
+}
+
+// Parts in local fs can be got only by the client who put them or for whom they were unsealed
+func GrantLocalFsKeys(client string, keys []string, db *leveldb.DB) error {
+	batch := new(leveldb.Batch)
+	for _, key := range keys {
+		batch.Put([]byte(LocalFsGrantFlagInDb+client+"/"+key), []byte{})
+	}
+	return db.Write(batch, nil)
+}
+
+func RevokeLocalFsKeys(client string, keys []string, db *leveldb.DB) {
+	batch := new(leveldb.Batch)
+	for _, key := range keys {
+		batch.Delete([]byte(LocalFsGrantFlagInDb + client + "/" + key))
+	}
+	_ = db.Write(batch, nil)
+}
+
+func IsLocalFsKeyGranted(client string, key string, db *leveldb.DB) bool {
+	ok, _ := db.Has([]byte(LocalFsGrantFlagInDb+client+"/"+key), nil)
+	return ok
+}
@@ -13,6 +13,7 @@ type FileSealMessage struct {
 	Client         string                     `json:"client"`
 	StoreOrderHash string                     `json:"store_order_hash"`
 	MerkleTree     *merkletree.MerkleTreeNode `json:"merkle_tree"`
+	RequestSignature
 }
 
 func NewFileSealMessage(msg []byte) (*FileSealMessage, error) {
@@ -31,10 +32,34 @@ type FileSealReturnMessage struct {
 	Info   string `json:"info"`
 }
 
+// -------------------------FileSealCancelMessage----------------------------
+type FileSealCancelMessage struct {
+	Client         string `json:"client"`
+	StoreOrderHash string `json:"store_order_hash"`
+	RequestSignature
+}
+
+func NewFileSealCancelMessage(msg []byte) (*FileSealCancelMessage, error) {
+	var fscm FileSealCancelMessage
+	err := json.Unmarshal(msg, &fscm)
+	if err != nil {
+		logger.Error("Unmarshal failed: %s", err)
+		return nil, err
+	}
+	return &fscm, err
+}
+
+// ----------------------FileSealCancelReturnMessage-------------------------
+type FileSealCancelReturnMessage struct {
+	Status int    `json:"status"`
+	Info   string `json:"info"`
+}
+
 // ----------------------------FileUnsealMessage------------------------------
 type FileUnsealMessage struct {
 	Client   string `json:"client"`
 	FileHash string `json:"file_hash"`
+	RequestSignature
 }
 
 func NewFileUnsealMessage(msg []byte) (*FileUnsealMessage, error) {
@@ -58,6 +83,7 @@ type FileUnsealReturnMessage struct {
 type FileFinishMessage struct {
 	Client     string                     `json:"client"`
 	MerkleTree *merkletree.MerkleTreeNode `json:"merkle_tree"`
+	RequestSignature
 }
 
 func NewFileFinishMessage(msg []byte) (*FileFinishMessage, error) {
@@ -72,8 +98,35 @@ func NewFileFinishMessage(msg []byte) (*FileFinishMessage, error) {
 
 // --------------------------FileFinishReturnMessage--------------------------
 type FileFinishReturnMessage struct {
-	Status int    `json:"status"`
-	Info   string `json:"info"`
+	Status            int    `json:"status"`
+	Info              string `json:"info"`
+	SealedHash        string `json:"sealed_hash"`
+	SealedSize        uint64 `json:"sealed_size"`
+	SealedLeavesNum   uint64 `json:"sealed_leaves_num"`
+	SealedMaxLinksNum uint64 `json:"sealed_max_links_num"`
+}
+
+// ----------------------------FileStatusMessage------------------------------
+type FileStatusMessage struct {
+	Client         string `json:"client"`
+	StoreOrderHash string `json:"store_order_hash"`
+}
+
+func NewFileStatusMessage(msg []byte) (*FileStatusMessage, error) {
+	var fsm FileStatusMessage
+	err := json.Unmarshal(msg, &fsm)
+	if err != nil {
+		logger.Error("Unmarshal failed: %s", err)
+		return nil, err
+	}
+	return &fsm, err
+}
+
+// -------------------------FileStatusReturnMessage---------------------------
+type FileStatusReturnMessage struct {
+	Status        int            `json:"status"`
+	Info          string         `json:"info"`
+	SealJobStatus *SealJobStatus `json:"seal_job_status"`
 }
 
 // ------------------------------BackupMessage------------------------------
@@ -82,19 +135,44 @@ type BackupMessage struct {
 }
 
 // -----------------------------NodeDataMessage-----------------------------
+// The node hash can be empty if 'WithProof' is set, then the proof of node is sent before the node data
 type NodeDataMessage struct {
 	FileHash  string `json:"file_hash"`
 	NodeHash  string `json:"node_hash"`
 	NodeIndex uint64 `json:"node_index"`
+	WithProof bool   `json:"with_proof"`
+}
+
+// ----------------------------NodeProofMessage-----------------------------
+type NodeProofMessage struct {
+	Status int                     `json:"status"`
+	Info   string                  `json:"info"`
+	Proof  *merkletree.MerkleProof `json:"proof"`
+}
+
+// -----------------------------NodeAuditMessage-----------------------------
+type NodeAuditMessage struct {
+	Client   string `json:"client"`
+	FileHash string `json:"file_hash"`
+	RequestSignature
+}
+
+// --------------------------NodeAuditReturnMessage--------------------------
+type NodeAuditReturnMessage struct {
+	Status     int    `json:"status"`
+	Info       string `json:"info"`
+	SealedHash string `json:"sealed_hash"`
 }
 
 // -----------------------------NodeInfoReturnMessage-----------------------------
 type NodeInfoReturnMessage struct {
-	Status         int            `json:"status"`
-	Info           string         `json:"info"`
-	FastdfsAddress string         `json:"fastdfs_address"`
-	IpfsAddress    string         `json:"ipfs_address"`
-	StorageStatus  *StorageStatus `json:"storage_status"`
+	Status         int                `json:"status"`
+	Info           string             `json:"info"`
+	FastdfsAddress string             `json:"fastdfs_address"`
+	IpfsAddress    string             `json:"ipfs_address"`
+	LocalAddress   string             `json:"local_address"`
+	StorageStatus  *StorageStatus     `json:"storage_status"`
+	SealQueue      []*SealQueueStatus `json:"seal_queue"`
 }
 
 func SendTextMessage(c *websocket.Conn, msg interface{}) {
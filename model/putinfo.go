@@ -0,0 +1,71 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"karst/merkletree"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	PutFlagInDb = "put"
+)
+
+const (
+	PutStageNew         = ""
+	PutStageSplit       = "split"
+	PutStageUploaded    = "uploaded"
+	PutStageOrderPlaced = "order_placed"
+	PutStageDeclared    = "declared"
+	PutStageFinished    = "finished"
+)
+
+type PutInfo struct {
+	FilePath       string                     `json:"file_path"`
+	Merchant       string                     `json:"merchant"`
+	Duration       uint64                     `json:"duration"`
+	Stage          string                     `json:"stage"`
+	SplitPath      string                     `json:"split_path"`
+	MerkleTree     *merkletree.MerkleTreeNode `json:"merkle_tree"`
+	StoreOrderHash string                     `json:"store_order_hash"`
+	// The storage order expires after 'Duration' blocks from it
+	OrderTime int64 `json:"order_time"`
+}
+
+func GetPutInfoFromDb(filePath string, merchant string, db *leveldb.DB) (*PutInfo, error) {
+	key := PutFlagInDb + merchant + filePath
+	if ok, _ := db.Has([]byte(key), nil); !ok {
+		return nil, fmt.Errorf("The put of '%s' to '%s' not stored in db", filePath, merchant)
+	}
+
+	putInfoBytes, err := db.Get([]byte(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	putInfo := PutInfo{}
+	if err = json.Unmarshal(putInfoBytes, &putInfo); err != nil {
+		return nil, err
+	}
+	return &putInfo, nil
+}
+
+func (putInfo *PutInfo) SaveToDb(db *leveldb.DB) error {
+	putInfoBytes, err := json.Marshal(putInfo)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(PutFlagInDb+putInfo.Merchant+putInfo.FilePath), putInfoBytes, nil)
+}
+
+func (putInfo *PutInfo) ClearDb(db *leveldb.DB) {
+	_ = db.Delete([]byte(PutFlagInDb+putInfo.Merchant+putInfo.FilePath), nil)
+}
+
+func (putInfo *PutInfo) ClearSplitFile() {
+	if putInfo.SplitPath != "" {
+		os.RemoveAll(putInfo.SplitPath)
+	}
+}
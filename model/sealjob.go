@@ -0,0 +1,217 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"karst/merkletree"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	SealJobFlagInDb     = "seal_job"
+	SealDeadJobFlagInDb = "seal_dead_job"
+)
+
+const (
+	SealJobStageAccepted  = "accepted"
+	SealJobStageFetched   = "fetched"
+	SealJobStageSealed    = "sealed"
+	SealJobStageStored    = "stored"
+	SealJobStageConfirmed = "confirmed"
+	SealJobStageFailed    = "failed"
+	SealJobStageDead      = "dead"
+	SealJobStageCanceled  = "canceled"
+)
+
+// The record of seal job is kept after it is finished, so that clients can get the result
+type SealJob struct {
+	Message          FileSealMessage            `json:"message"`
+	Stage            string                     `json:"stage"`
+	MerkleTreeSealed *merkletree.MerkleTreeNode `json:"merkle_tree_sealed"`
+	SealedPath       string                     `json:"sealed_path"`
+	SealedHash       string                     `json:"sealed_hash"`
+	Error            string                     `json:"error"`
+	Attempts         int                        `json:"attempts"`
+	Canceled         bool                       `json:"canceled"`
+	AcceptTime       int64                      `json:"accept_time"`
+	UpdateTime       int64                      `json:"update_time"`
+	FinishTime       int64                      `json:"finish_time"`
+}
+
+type SealJobStatus struct {
+	StoreOrderHash string `json:"store_order_hash"`
+	Client         string `json:"client"`
+	FileHash       string `json:"file_hash"`
+	Stage          string `json:"stage"`
+	SealedHash     string `json:"sealed_hash"`
+	Error          string `json:"error"`
+	Attempts       int    `json:"attempts"`
+	AcceptTime     int64  `json:"accept_time"`
+	UpdateTime     int64  `json:"update_time"`
+	FinishTime     int64  `json:"finish_time"`
+	CanFinish      bool   `json:"can_finish"`
+}
+
+type SealQueueStatus struct {
+	Client       string `json:"client"`
+	QueuedNum    int    `json:"queued_num"`
+	QueuedSize   uint64 `json:"queued_size"`
+	InFlightNum  int    `json:"in_flight_num"`
+	InFlightSize uint64 `json:"in_flight_size"`
+}
+
+func NewSealJob(fileSealMsg FileSealMessage) *SealJob {
+	now := time.Now().UnixNano()
+	return &SealJob{
+		Message:    fileSealMsg,
+		Stage:      SealJobStageAccepted,
+		AcceptTime: now,
+		UpdateTime: now,
+	}
+}
+
+func GetSealJobFromDb(storeOrderHash string, db *leveldb.DB) (*SealJob, error) {
+	return getSealJobFromDb(storeOrderHash, db, SealJobFlagInDb)
+}
+
+func GetSealDeadJobFromDb(storeOrderHash string, db *leveldb.DB) (*SealJob, error) {
+	return getSealJobFromDb(storeOrderHash, db, SealDeadJobFlagInDb)
+}
+
+func getSealJobFromDb(storeOrderHash string, db *leveldb.DB, flag string) (*SealJob, error) {
+	key := flag + storeOrderHash
+	if ok, _ := db.Has([]byte(key), nil); !ok {
+		return nil, fmt.Errorf("This seal job '%s' not stored in db", storeOrderHash)
+	}
+
+	sealJobBytes, err := db.Get([]byte(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sealJob := SealJob{}
+	if err = json.Unmarshal(sealJobBytes, &sealJob); err != nil {
+		return nil, err
+	}
+	return &sealJob, nil
+}
+
+// Get all seal jobs in db, jobs are sorted by accept time
+func GetSealJobList(db *leveldb.DB) ([]*SealJob, error) {
+	return getSealJobList(db, SealJobFlagInDb)
+}
+
+// Get all seal jobs in dead list, jobs are sorted by accept time
+func GetSealDeadJobList(db *leveldb.DB) ([]*SealJob, error) {
+	return getSealJobList(db, SealDeadJobFlagInDb)
+}
+
+func getSealJobList(db *leveldb.DB, flag string) ([]*SealJob, error) {
+	sealJobList := make([]*SealJob, 0)
+	iter := db.NewIterator(util.BytesPrefix([]byte(flag)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		sealJob := SealJob{}
+		if err := json.Unmarshal(iter.Value(), &sealJob); err != nil {
+			return nil, err
+		}
+		sealJobList = append(sealJobList, &sealJob)
+	}
+
+	sort.Slice(sealJobList, func(i, j int) bool {
+		return sealJobList[i].AcceptTime < sealJobList[j].AcceptTime
+	})
+	return sealJobList, iter.Error()
+}
+
+func (sealJob *SealJob) SaveToDb(db *leveldb.DB) error {
+	sealJobBytes, err := json.Marshal(sealJob)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(SealJobFlagInDb+sealJob.Message.StoreOrderHash), sealJobBytes, nil)
+}
+
+// Move the job to 'stage' and save it, so that it can be resumed from this stage
+func (sealJob *SealJob) Checkpoint(stage string, db *leveldb.DB) error {
+	sealJob.Stage = stage
+	sealJob.UpdateTime = time.Now().UnixNano()
+	if sealJob.IsFinished() {
+		sealJob.FinishTime = sealJob.UpdateTime
+	}
+	return sealJob.SaveToDb(db)
+}
+
+func (sealJob *SealJob) IsFinished() bool {
+	return sealJob.Stage == SealJobStageConfirmed || sealJob.Stage == SealJobStageFailed || sealJob.Stage == SealJobStageCanceled
+}
+
+// The original file can be deleted by 'finish' after the sealed file is stored
+func (sealJob *SealJob) CanFinish() bool {
+	return sealJob.Stage == SealJobStageStored || sealJob.Stage == SealJobStageConfirmed
+}
+
+func (sealJob *SealJob) GetStatus() *SealJobStatus {
+	return &SealJobStatus{
+		StoreOrderHash: sealJob.Message.StoreOrderHash,
+		Client:         sealJob.Message.Client,
+		FileHash:       sealJob.Message.MerkleTree.Hash,
+		Stage:          sealJob.Stage,
+		SealedHash:     sealJob.SealedHash,
+		Error:          sealJob.Error,
+		Attempts:       sealJob.Attempts,
+		AcceptTime:     sealJob.AcceptTime,
+		UpdateTime:     sealJob.UpdateTime,
+		FinishTime:     sealJob.FinishTime,
+		CanFinish:      sealJob.CanFinish(),
+	}
+}
+
+func (sealJob *SealJob) ClearDb(db *leveldb.DB) {
+	_ = db.Delete([]byte(SealJobFlagInDb+sealJob.Message.StoreOrderHash), nil)
+}
+
+// Move the job into dead list, it won't be dealt until it is requeued
+func (sealJob *SealJob) Bury(errString string, db *leveldb.DB) error {
+	sealJob.Stage = SealJobStageDead
+	sealJob.Error = errString
+	sealJob.UpdateTime = time.Now().UnixNano()
+	sealJobBytes, err := json.Marshal(sealJob)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(SealDeadJobFlagInDb+sealJob.Message.StoreOrderHash), sealJobBytes)
+	batch.Delete([]byte(SealJobFlagInDb + sealJob.Message.StoreOrderHash))
+	return db.Write(batch, nil)
+}
+
+// Move the job from dead list back with 'stage'
+func (sealJob *SealJob) Revive(stage string, db *leveldb.DB) error {
+	sealJob.Stage = stage
+	sealJob.UpdateTime = time.Now().UnixNano()
+	if sealJob.IsFinished() {
+		sealJob.FinishTime = sealJob.UpdateTime
+	}
+	sealJobBytes, err := json.Marshal(sealJob)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(SealJobFlagInDb+sealJob.Message.StoreOrderHash), sealJobBytes)
+	batch.Delete([]byte(SealDeadJobFlagInDb + sealJob.Message.StoreOrderHash))
+	return db.Write(batch, nil)
+}
+
+func (sealJob *SealJob) ClearSealedFile() {
+	if sealJob.SealedPath != "" {
+		os.RemoveAll(sealJob.SealedPath)
+	}
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"karst/account"
+	"time"
+)
+
+const (
+	FileSealSignPath       = "/api/v0/file/seal"
+	FileSealCancelSignPath = "/api/v0/file/seal/cancel"
+	FileUnsealSignPath     = "/api/v0/file/unseal"
+	FileFinishSignPath     = "/api/v0/file/finish"
+	NodeAuditSignPath      = "/api/v0/node/data"
+	RequestValidDuration   = 5 * time.Minute
+	requestNonceByteCount  = 16
+)
+
+// RequestSignature is embedded in the messages which must be signed by the chain account of client
+type RequestSignature struct {
+	Nonce     string `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature"`
+}
+
+// SignedRequest is the message with embedded RequestSignature
+type SignedRequest interface {
+	GetRequestSignature() *RequestSignature
+}
+
+func (reqSig *RequestSignature) GetRequestSignature() *RequestSignature {
+	return reqSig
+}
+
+// Sign the request with a new nonce and timestamp, the path is signed too, so the signature of one
+// kind of request can't be used for another
+func SignRequest(path string, req SignedRequest, keypair *account.Keypair) error {
+	nonceBytes := make([]byte, requestNonceByteCount)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return err
+	}
+
+	reqSig := req.GetRequestSignature()
+	reqSig.Nonce = hex.EncodeToString(nonceBytes)
+	reqSig.Timestamp = time.Now().Unix()
+	reqSig.Signature = ""
+
+	payload, err := getSigningPayload(path, req)
+	if err != nil {
+		return err
+	}
+
+	signature, err := keypair.Sign(payload)
+	if err != nil {
+		return err
+	}
+	reqSig.Signature = signature
+	return nil
+}
+
+// Verify that the request is signed by the client in valid duration, the caller should reject the nonce used before
+func VerifyRequest(path string, client string, req SignedRequest) error {
+	reqSig := req.GetRequestSignature()
+	if reqSig.Nonce == "" || reqSig.Signature == "" {
+		return fmt.Errorf("The fields 'nonce' and 'signature' are needed")
+	}
+
+	requestTime := time.Unix(reqSig.Timestamp, 0)
+	if time.Since(requestTime) > RequestValidDuration || time.Until(requestTime) > RequestValidDuration {
+		return fmt.Errorf("The timestamp %d is out of valid duration %s", reqSig.Timestamp, RequestValidDuration)
+	}
+
+	signature := reqSig.Signature
+	reqSig.Signature = ""
+	payload, err := getSigningPayload(path, req)
+	reqSig.Signature = signature
+	if err != nil {
+		return err
+	}
+
+	return account.Verify(client, payload, signature)
+}
+
+// Path + '\n' + json of request without signature
+func getSigningPayload(path string, req SignedRequest) ([]byte, error) {
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(path+"\n"), reqBytes...), nil
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"karst/account"
+	"testing"
+	"time"
+)
+
+// Exported by polkadot-js, the password is '123456'
+const (
+	testAddress  = "5FqazaU79hjpEMiWTWZx81VjsYFst15eBuSBKdQLgQibD7CX"
+	testPassword = "123456"
+	testBackup   = `{"address":"5FqazaU79hjpEMiWTWZx81VjsYFst15eBuSBKdQLgQibD7CX","encoded":"0xc81537c9442bd1d3f4985531293d88f6d2a960969a88b1cf8413e7c9ec1d5f4955adf91d2d687d8493b70ef457532d505b9cee7a3d2b726a554242b75fb9bec7d4beab74da4bf65260e1d6f7a6b44af4505bf35aaae4cf95b1059ba0f03f1d63c5b7c3ccbacd6bd80577de71f35d0c4976b6e43fe0e1583530e773dfab3ab46c92ce3fa2168673ba52678407a3ef619b5e14155706d43bd329a5e72d36","encoding":{"content":["pkcs8","sr25519"],"type":"xsalsa20-poly1305","version":"2"},"meta":{"name":"Yang1","tags":[],"whenCreated":1580628430860}}`
+)
+
+type testRequest struct {
+	StoreOrderHash string `json:"store_order_hash"`
+	RequestSignature
+}
+
+func TestSignAndVerifyRequest(t *testing.T) {
+	keypair, err := account.LoadKeypair(testBackup, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := testRequest{StoreOrderHash: "0x01"}
+	if err = SignRequest(FileSealSignPath, &req, keypair); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyRequest(FileSealSignPath, testAddress, &req); err != nil {
+		t.Fatalf("Verify request failed: %s", err)
+	}
+
+	// The signature of one kind of request can't be used for another
+	if err = VerifyRequest(FileSealCancelSignPath, testAddress, &req); err == nil {
+		t.Fatal("Verify request of other path")
+	}
+
+	tampered := req
+	tampered.StoreOrderHash = "0x02"
+	if err = VerifyRequest(FileSealSignPath, testAddress, &tampered); err == nil {
+		t.Fatal("Verify tampered request")
+	}
+
+	// The timestamp is signed too, so it must be made by the client
+	expired := testRequest{StoreOrderHash: "0x01"}
+	expired.Nonce = "expired"
+	expired.Timestamp = time.Now().Add(-RequestValidDuration - time.Minute).Unix()
+	payload, err := getSigningPayload(FileSealSignPath, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired.Signature, err = keypair.Sign(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyRequest(FileSealSignPath, testAddress, &expired); err == nil {
+		t.Fatal("Verify request older than valid duration")
+	}
+
+	expired.Timestamp = time.Now().Unix()
+	if err = VerifyRequest(FileSealSignPath, testAddress, &expired); err == nil {
+		t.Fatal("Verify request with changed timestamp")
+	}
+}
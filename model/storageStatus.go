@@ -6,6 +6,7 @@ import (
 	"karst/utils"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type StorageStatus struct {
@@ -22,9 +23,9 @@ func GetStorageStatus(db *leveldb.DB) (*StorageStatus, error) {
 		FilesTotalNumber:        0,
 		FilesNumberDistribution: []uint64{0, 0, 0, 0, 0},
 	}
-	iter := db.NewIterator(nil, nil)
-	prefix := []byte(SealedFileFlagInDb)
-	for ok := iter.Seek(prefix); ok; ok = iter.Next() {
+	iter := db.NewIterator(util.BytesPrefix([]byte(SealedFileFlagInDb)), nil)
+	defer iter.Release()
+	for iter.Next() {
 		fileInfo := FileInfo{}
 		if err := json.Unmarshal(iter.Value(), &fileInfo); err != nil {
 			return nil, err
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"karst/merkletree"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	UnsealInfoFlagInDb = "unseal_info"
+)
+
+// UnsealInfo records the original parts which merchant puts into fs for the client by each unseal, 'finish' of
+// the client only deletes these parts, the original parts of put have been deleted by the seal job
+type UnsealInfo struct {
+	Client      string                       `json:"client"`
+	FileHash    string                       `json:"file_hash"`
+	MerkleTrees []*merkletree.MerkleTreeNode `json:"merkle_trees"`
+}
+
+func GetUnsealInfoFromDb(client string, fileHash string, db *leveldb.DB) (*UnsealInfo, error) {
+	key := UnsealInfoFlagInDb + client + fileHash
+	if ok, _ := db.Has([]byte(key), nil); !ok {
+		return nil, fmt.Errorf("The unseal of '%s' for '%s' not stored in db", fileHash, client)
+	}
+
+	unsealInfoBytes, err := db.Get([]byte(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	unsealInfo := UnsealInfo{}
+	if err = json.Unmarshal(unsealInfoBytes, &unsealInfo); err != nil {
+		return nil, err
+	}
+	return &unsealInfo, nil
+}
+
+func (unsealInfo *UnsealInfo) SaveToDb(db *leveldb.DB) error {
+	unsealInfoBytes, err := json.Marshal(unsealInfo)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(UnsealInfoFlagInDb+unsealInfo.Client+unsealInfo.FileHash), unsealInfoBytes, nil)
+}
+
+func (unsealInfo *UnsealInfo) ClearDb(db *leveldb.DB) {
+	_ = db.Delete([]byte(UnsealInfoFlagInDb+unsealInfo.Client+unsealInfo.FileHash), nil)
+}
@@ -0,0 +1,295 @@
+package sworker
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"karst/logger"
+	"karst/merkletree"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	mockSealKey              = "karst-mock-sworker"
+	mockSealedPathPrefix     = "sealed_"
+	mockUnsealPathPrefix     = "unsealed_"
+	mockSealedStateSealed    = "sealed"
+	mockSealedStateConfirmed = "confirmed"
+	mockEnclaveIdInfoPath    = "/api/v0/enclave/id_info"
+)
+
+// MockSworker serves the storage apis of sworker without SGX, the seal of each part is a reversible xor with
+// the key stream generated from a fixed key, so that merchant flows can run on machines without sworker
+type MockSworker struct {
+	lock          sync.Mutex
+	backup        string
+	updatingTimes int
+	rejectTimes   int
+	sealedFiles   map[string]string
+}
+
+// Create mock sworker, the 'backup' header of requests is checked if 'backup' isn't empty
+func NewMockSworker(backup string) *MockSworker {
+	return &MockSworker{
+		backup:      backup,
+		sealedFiles: make(map[string]string),
+	}
+}
+
+// Return 503 for the next 'times' requests, like sworker which is updating
+func (mock *MockSworker) SetUpdating(times int) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	mock.updatingTimes = times
+}
+
+// Return 400 for the next 'times' seal requests, like sworker which refuses the file
+func (mock *MockSworker) SetSealRejected(times int) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	mock.rejectTimes = times
+}
+
+func (mock *MockSworker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("(MockSworker) %s %s", r.Method, r.URL.Path)
+
+	// Probe of sworker
+	if r.Method == http.MethodGet && r.URL.Path == mockEnclaveIdInfoPath {
+		idInfoBytes, _ := json.Marshal(map[string]string{
+			"mrenclave": hex.EncodeToString([]byte(mockSealKey)),
+			"pub_key":   "",
+		})
+		_, _ = w.Write(idInfoBytes)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	if mock.backup != "" && r.Header.Get("backup") != mock.backup {
+		writeMockSworkerError(w, http.StatusUnauthorized, fmt.Errorf("Wrong backup"))
+		return
+	}
+
+	mock.lock.Lock()
+	if mock.updatingTimes > 0 {
+		mock.updatingTimes--
+		mock.lock.Unlock()
+		logger.Debug("(MockSworker) Sworker is updating")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	mock.lock.Unlock()
+
+	var reqBody struct {
+		Body *merkletree.MerkleTreeNode `json:"body"`
+		Path string                     `json:"path"`
+		Hash string                     `json:"hash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeMockSworkerError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/v0/storage/seal":
+		mock.lock.Lock()
+		if mock.rejectTimes > 0 {
+			mock.rejectTimes--
+			mock.lock.Unlock()
+			writeMockSworkerError(w, http.StatusBadRequest, fmt.Errorf("The file is refused"))
+			return
+		}
+		mock.lock.Unlock()
+
+		merkleTreeSealed, sealedPath, code, err := mock.seal(reqBody.Path, reqBody.Body)
+		if err != nil {
+			writeMockSworkerError(w, code, err)
+			return
+		}
+
+		merkleTreeSealedBytes, _ := json.Marshal(merkleTreeSealed)
+		sealedMsgBytes, _ := json.Marshal(sealedMessage{
+			Body: string(merkleTreeSealedBytes),
+			Path: sealedPath,
+		})
+		_, _ = w.Write(sealedMsgBytes)
+	case "/api/v0/storage/unseal":
+		originalPath, code, err := mock.unseal(reqBody.Path)
+		if err != nil {
+			writeMockSworkerError(w, code, err)
+			return
+		}
+		_, _ = w.Write([]byte(originalPath))
+	case "/api/v0/storage/confirm":
+		mock.lock.Lock()
+		defer mock.lock.Unlock()
+		if _, ok := mock.sealedFiles[reqBody.Hash]; !ok {
+			writeMockSworkerError(w, http.StatusNotFound, fmt.Errorf("Sealed file '%s' doesn't exist", reqBody.Hash))
+			return
+		}
+		mock.sealedFiles[reqBody.Hash] = mockSealedStateConfirmed
+		_, _ = w.Write([]byte("Confirm successfully"))
+	case "/api/v0/storage/delete":
+		mock.lock.Lock()
+		defer mock.lock.Unlock()
+		if _, ok := mock.sealedFiles[reqBody.Hash]; !ok {
+			writeMockSworkerError(w, http.StatusNotFound, fmt.Errorf("Sealed file '%s' doesn't exist", reqBody.Hash))
+			return
+		}
+		delete(mock.sealedFiles, reqBody.Hash)
+		_, _ = w.Write([]byte("Delete successfully"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Seal parts in 'path' and write sealed parts into the sibling directory, the sealed merkle tree has the same structure
+func (mock *MockSworker) seal(path string, merkleTree *merkletree.MerkleTreeNode) (*merkletree.MerkleTreeNode, string, int, error) {
+	if path == "" || merkleTree == nil || !merkleTree.IsLegal() {
+		return nil, "", http.StatusBadRequest, fmt.Errorf("Illegal seal request")
+	}
+
+	sealedPath := filepath.Join(filepath.Dir(path), mockSealedPathPrefix+merkleTree.Hash)
+	if err := os.MkdirAll(sealedPath, os.ModePerm); err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+
+	sealedLeaves := make([]merkletree.MerkleTreeNode, 0)
+	for i, leaf := range merkleTree.Leaves() {
+		partBytes, err := ioutil.ReadFile(filepath.Join(path, strconv.Itoa(i)+"_"+leaf.Hash))
+		if err != nil {
+			os.RemoveAll(sealedPath)
+			return nil, "", http.StatusBadRequest, err
+		}
+
+		partHash := sha256.Sum256(partBytes)
+		if hex.EncodeToString(partHash[:]) != leaf.Hash || uint64(len(partBytes)) != leaf.Size {
+			os.RemoveAll(sealedPath)
+			return nil, "", http.StatusBadRequest, fmt.Errorf("Part %d doesn't match its hash '%s'", i, leaf.Hash)
+		}
+
+		mockSealTransform(partBytes)
+		sealedHash := sha256.Sum256(partBytes)
+		sealedLeaf := merkletree.NewMerkleTreeNode(sealedHash[:], leaf.Size)
+		if err = ioutil.WriteFile(filepath.Join(sealedPath, strconv.Itoa(i)+"_"+sealedLeaf.Hash), partBytes, os.ModePerm); err != nil {
+			os.RemoveAll(sealedPath)
+			return nil, "", http.StatusInternalServerError, err
+		}
+		sealedLeaves = append(sealedLeaves, *sealedLeaf)
+	}
+
+	leafIndex := 0
+	merkleTreeSealed := buildMockSealedTree(merkleTree, sealedLeaves, &leafIndex)
+
+	mock.lock.Lock()
+	mock.sealedFiles[merkleTreeSealed.Hash] = mockSealedStateSealed
+	mock.lock.Unlock()
+
+	logger.Info("(MockSworker) Seal '%s' to '%s'", merkleTree.Hash, merkleTreeSealed.Hash)
+	return merkleTreeSealed, sealedPath, http.StatusOK, nil
+}
+
+// Unseal parts in 'path' which are named like 'index_hash' and write original parts into the sibling directory
+func (mock *MockSworker) unseal(path string) (string, int, error) {
+	partInfos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+
+	sort.Slice(partInfos, func(i, j int) bool {
+		return getMockPartIndex(partInfos[i].Name()) < getMockPartIndex(partInfos[j].Name())
+	})
+
+	originalPath := filepath.Join(filepath.Dir(path), mockUnsealPathPrefix+filepath.Base(path))
+	if err := os.MkdirAll(originalPath, os.ModePerm); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	for i, partInfo := range partInfos {
+		if getMockPartIndex(partInfo.Name()) != i {
+			os.RemoveAll(originalPath)
+			return "", http.StatusBadRequest, fmt.Errorf("Illegal sealed part '%s'", partInfo.Name())
+		}
+
+		partBytes, err := ioutil.ReadFile(filepath.Join(path, partInfo.Name()))
+		if err != nil {
+			os.RemoveAll(originalPath)
+			return "", http.StatusInternalServerError, err
+		}
+
+		mockSealTransform(partBytes)
+		partHash := sha256.Sum256(partBytes)
+		if err = ioutil.WriteFile(filepath.Join(originalPath, strconv.Itoa(i)+"_"+hex.EncodeToString(partHash[:])), partBytes, os.ModePerm); err != nil {
+			os.RemoveAll(originalPath)
+			return "", http.StatusInternalServerError, err
+		}
+	}
+
+	logger.Info("(MockSworker) Unseal '%s' to '%s'", path, originalPath)
+	return originalPath, http.StatusOK, nil
+}
+
+// Replace leaves by sealed leaves in order and compute hashes of parents like merkle tree
+func buildMockSealedTree(node *merkletree.MerkleTreeNode, sealedLeaves []merkletree.MerkleTreeNode, leafIndex *int) *merkletree.MerkleTreeNode {
+	if node.LinksNum == 0 {
+		sealedLeaf := sealedLeaves[*leafIndex]
+		*leafIndex++
+		return &sealedLeaf
+	}
+
+	links := make([]merkletree.MerkleTreeNode, 0, len(node.Links))
+	allHashs := make([]byte, 0)
+	var totalSize uint64 = 0
+	for index := range node.Links {
+		link := buildMockSealedTree(&node.Links[index], sealedLeaves, leafIndex)
+		links = append(links, *link)
+		allHashs = append(allHashs, link.HashBytes()...)
+		totalSize = totalSize + link.Size
+	}
+
+	hashBytes := sha256.Sum256(allHashs)
+	return &merkletree.MerkleTreeNode{
+		Hash:     hex.EncodeToString(hashBytes[:]),
+		Size:     totalSize,
+		LinksNum: uint64(len(links)),
+		Links:    links,
+	}
+}
+
+// Xor data with the key stream, so the same transform seals and unseals data
+func mockSealTransform(data []byte) {
+	counter := make([]byte, 8)
+	for begin := 0; begin < len(data); begin = begin + sha256.Size {
+		binary.BigEndian.PutUint64(counter, uint64(begin/sha256.Size))
+		keyBlock := sha256.Sum256(append([]byte(mockSealKey), counter...))
+		for i := 0; i < sha256.Size && begin+i < len(data); i++ {
+			data[begin+i] ^= keyBlock[i]
+		}
+	}
+}
+
+func getMockPartIndex(name string) int {
+	index, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+	if err != nil {
+		return -1
+	}
+	return index
+}
+
+func writeMockSworkerError(w http.ResponseWriter, code int, err error) {
+	logger.Error("(MockSworker) %s", err)
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(err.Error()))
+}
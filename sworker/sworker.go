@@ -8,26 +8,64 @@ import (
 	"karst/config"
 	"karst/logger"
 	"karst/merkletree"
+	"karst/metrics"
 	"net/http"
+	"path"
+	"strconv"
 	"time"
 )
 
+const (
+	probeTimeout = 5 * time.Second
+)
+
 type sealedMessage struct {
 	Body string
 	Path string
 }
 
+// The error code returned by sworker, error codes in 4xx mean that the request can't be dealt
+type StatusError struct {
+	StatusCode int
+}
+
+func (statusErr *StatusError) Error() string {
+	return fmt.Sprintf("Error code is: %d", statusErr.StatusCode)
+}
+
+func (statusErr *StatusError) IsPermanent() bool {
+	return statusErr.StatusCode >= 400 && statusErr.StatusCode < 500
+}
+
 func httpRetryHandle(client *http.Client, req *http.Request, cfg *config.Configuration) ([]byte, error) {
+	// The api is the last element of url, like 'seal'
+	api := path.Base(req.URL.Path)
+	timeStart := time.Now()
+	defer func() {
+		metrics.SworkerRequestDuration.WithLabelValues(api).Observe(time.Since(timeStart).Seconds())
+	}()
+
 	tryTimes := 0
 
 	for {
 		tryTimes++
+		// The body has been read by the last try
+		if tryTimes > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
 		resp, err := client.Do(req)
 		if err != nil {
+			metrics.SworkerResponses.WithLabelValues(api, "error").Inc()
 			if tryTimes > cfg.RetryTimes {
 				return nil, err
 			}
 		} else {
+			metrics.SworkerResponses.WithLabelValues(api, strconv.Itoa(resp.StatusCode)).Inc()
 			if resp.StatusCode == 200 {
 				returnBody, err := ioutil.ReadAll(resp.Body)
 				resp.Body.Close()
@@ -40,16 +78,40 @@ func httpRetryHandle(client *http.Client, req *http.Request, cfg *config.Configu
 				}
 			} else {
 				resp.Body.Close()
-				return nil, fmt.Errorf("Error code is: %d", resp.StatusCode)
+				return nil, &StatusError{StatusCode: resp.StatusCode}
 			}
 		}
 		time.Sleep(cfg.RetryInterval)
 	}
 }
 
-func Seal(cfg *config.Configuration, path string, merkleTree *merkletree.MerkleTreeNode) (*merkletree.MerkleTreeNode, string, error) {
+// Client is used by merchant to seal, unseal, confirm and delete files, it is implemented by sworker and mock sworker
+type Client interface {
+	// Seal the file in 'path' and return the sealed merkle tree and the path of sealed file
+	Seal(path string, merkleTree *merkletree.MerkleTreeNode) (*merkletree.MerkleTreeNode, string, error)
+	// Unseal the sealed file in 'path' and return the path of original file
+	Unseal(path string) (string, error)
+	Confirm(sealedHash string) error
+	Delete(sealedHash string) error
+	// Check if sworker can be reached, it is lightweight and never retried
+	Probe() error
+}
+
+// The client of sworker http api
+type httpClient struct {
+	cfg *config.Configuration
+}
+
+// Create the client of sworker with the sworker configuration
+func NewClient(cfg *config.Configuration) Client {
+	return &httpClient{
+		cfg: cfg,
+	}
+}
+
+func (client *httpClient) Seal(path string, merkleTree *merkletree.MerkleTreeNode) (*merkletree.MerkleTreeNode, string, error) {
 	// Generate request
-	url := cfg.Sworker.HttpBaseUrl + "/api/v0/storage/seal"
+	url := client.cfg.Sworker.HttpBaseUrl + "/api/v0/storage/seal"
 	reqBody := map[string]interface{}{
 		"body": merkleTree,
 		"path": path,
@@ -63,17 +125,17 @@ func Seal(cfg *config.Configuration, path string, merkleTree *merkletree.MerkleT
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("backup", cfg.Sworker.Backup)
+	req.Header.Set("backup", client.cfg.Sworker.Backup)
 
 	// Request
-	client := &http.Client{
+	httpClient := &http.Client{
 		Timeout: 1000 * time.Second,
 		Transport: &http.Transport{
 			DisableKeepAlives: true,
 		},
 	}
 
-	returnBody, err := httpRetryHandle(client, req, cfg)
+	returnBody, err := httpRetryHandle(httpClient, req, client.cfg)
 	if err != nil {
 		return nil, "", err
 	}
@@ -92,9 +154,9 @@ func Seal(cfg *config.Configuration, path string, merkleTree *merkletree.MerkleT
 	return &merkleTreeSealed, sealedMsg.Path, nil
 }
 
-func Unseal(cfg *config.Configuration, path string) (string, error) {
+func (client *httpClient) Unseal(path string) (string, error) {
 	// Generate request
-	url := cfg.Sworker.HttpBaseUrl + "/api/v0/storage/unseal"
+	url := client.cfg.Sworker.HttpBaseUrl + "/api/v0/storage/unseal"
 	reqBody := map[string]interface{}{
 		"path": path,
 	}
@@ -107,17 +169,17 @@ func Unseal(cfg *config.Configuration, path string) (string, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("backup", cfg.Sworker.Backup)
+	req.Header.Set("backup", client.cfg.Sworker.Backup)
 
 	// Request
-	client := &http.Client{
+	httpClient := &http.Client{
 		Timeout: 1000 * time.Second,
 		Transport: &http.Transport{
 			DisableKeepAlives: true,
 		},
 	}
 
-	returnBody, err := httpRetryHandle(client, req, cfg)
+	returnBody, err := httpRetryHandle(httpClient, req, client.cfg)
 	if err != nil {
 		return "", err
 	}
@@ -125,9 +187,9 @@ func Unseal(cfg *config.Configuration, path string) (string, error) {
 	return string(returnBody), nil
 }
 
-func Confirm(cfg *config.Configuration, sealedHash string) error {
+func (client *httpClient) Confirm(sealedHash string) error {
 	// Generate request
-	url := cfg.Sworker.HttpBaseUrl + "/api/v0/storage/confirm"
+	url := client.cfg.Sworker.HttpBaseUrl + "/api/v0/storage/confirm"
 	reqBody := map[string]interface{}{
 		"hash": sealedHash,
 	}
@@ -140,17 +202,17 @@ func Confirm(cfg *config.Configuration, sealedHash string) error {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("backup", cfg.Sworker.Backup)
+	req.Header.Set("backup", client.cfg.Sworker.Backup)
 
 	// Request
-	client := &http.Client{
+	httpClient := &http.Client{
 		Timeout: 1000 * time.Second,
 		Transport: &http.Transport{
 			DisableKeepAlives: true,
 		},
 	}
 
-	returnBody, err := httpRetryHandle(client, req, cfg)
+	returnBody, err := httpRetryHandle(httpClient, req, client.cfg)
 	if err != nil {
 		return err
 	}
@@ -159,9 +221,9 @@ func Confirm(cfg *config.Configuration, sealedHash string) error {
 	return nil
 }
 
-func Delete(cfg *config.Configuration, sealedHash string) error {
+func (client *httpClient) Delete(sealedHash string) error {
 	// Generate request
-	url := cfg.Sworker.HttpBaseUrl + "/api/v0/storage/delete"
+	url := client.cfg.Sworker.HttpBaseUrl + "/api/v0/storage/delete"
 	reqBody := map[string]interface{}{
 		"hash": sealedHash,
 	}
@@ -174,17 +236,17 @@ func Delete(cfg *config.Configuration, sealedHash string) error {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("backup", cfg.Sworker.Backup)
+	req.Header.Set("backup", client.cfg.Sworker.Backup)
 
 	// Request
-	client := &http.Client{
+	httpClient := &http.Client{
 		Timeout: 1000 * time.Second,
 		Transport: &http.Transport{
 			DisableKeepAlives: true,
 		},
 	}
 
-	returnBody, err := httpRetryHandle(client, req, cfg)
+	returnBody, err := httpRetryHandle(httpClient, req, client.cfg)
 	if err != nil {
 		return err
 	}
@@ -192,3 +254,33 @@ func Delete(cfg *config.Configuration, sealedHash string) error {
 	logger.Debug(string(returnBody))
 	return nil
 }
+
+func (client *httpClient) Probe() error {
+	// Generate request
+	url := client.cfg.Sworker.HttpBaseUrl + "/api/v0/enclave/id_info"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("backup", client.cfg.Sworker.Backup)
+
+	// Request
+	httpClient := &http.Client{
+		Timeout: probeTimeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+		},
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package sworker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"karst/config"
+	"karst/merkletree"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testBackup = "{\"address\":\"test\"}"
+
+func newTestClient(t *testing.T, mock *MockSworker) (Client, func()) {
+	server := httptest.NewServer(mock)
+	cfg := &config.Configuration{
+		RetryTimes:    3,
+		RetryInterval: time.Millisecond,
+	}
+	cfg.Sworker.HttpBaseUrl = server.URL
+	cfg.Sworker.Backup = testBackup
+	return NewClient(cfg), server.Close
+}
+
+// Write parts named like 'index_hash' into 'dir' and return the merkle tree of them
+func writeTestParts(t *testing.T, dir string, partsNum int) (*merkletree.MerkleTreeNode, [][]byte) {
+	hashs := make([][]byte, 0, partsNum)
+	sizes := make([]uint64, 0, partsNum)
+	parts := make([][]byte, 0, partsNum)
+	for i := 0; i < partsNum; i++ {
+		part := bytes.Repeat([]byte(strconv.Itoa(i)), 100+i)
+		hash := sha256.Sum256(part)
+		hashs = append(hashs, hash[:])
+		sizes = append(sizes, uint64(len(part)))
+		parts = append(parts, part)
+	}
+
+	mt := merkletree.CreateMerkleTree(hashs, sizes, 2)
+	for i, leaf := range mt.Leaves() {
+		if err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(i)+"_"+leaf.Hash), parts[i], os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mt, parts
+}
+
+func TestSealAndUnseal(t *testing.T) {
+	mock := NewMockSworker(testBackup)
+	client, closeServer := newTestClient(t, mock)
+	defer closeServer()
+
+	dir, err := ioutil.TempDir("", "karst-sworker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	originalPath := filepath.Join(dir, "original")
+	if err = os.Mkdir(originalPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	mt, parts := writeTestParts(t, originalPath, 5)
+
+	// The body of seal request must be sent again after sworker returns 503
+	mock.SetUpdating(2)
+	mtSealed, sealedPath, err := client.Seal(originalPath, mt)
+	if err != nil {
+		t.Fatalf("Seal failed while sworker is updating: %s", err)
+	}
+
+	if !mtSealed.IsLegal() || mtSealed.Size != mt.Size || len(mtSealed.Leaves()) != len(mt.Leaves()) {
+		t.Fatalf("Sealed tree doesn't have the same structure as the original tree")
+	}
+
+	unsealedPath, err := client.Unseal(sealedPath)
+	if err != nil {
+		t.Fatalf("Unseal failed: %s", err)
+	}
+
+	for i, leaf := range mt.Leaves() {
+		part, err := ioutil.ReadFile(filepath.Join(unsealedPath, strconv.Itoa(i)+"_"+leaf.Hash))
+		if err != nil {
+			t.Fatalf("Read unsealed part %d failed: %s", i, err)
+		}
+		if !bytes.Equal(part, parts[i]) {
+			t.Fatalf("Unsealed part %d doesn't equal the original part", i)
+		}
+	}
+
+	if err = client.Confirm(mtSealed.Hash); err != nil {
+		t.Fatalf("Confirm failed: %s", err)
+	}
+
+	if err = client.Delete(mtSealed.Hash); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+
+	err = client.Delete(mtSealed.Hash)
+	if statusErr, ok := err.(*StatusError); !ok || !statusErr.IsPermanent() {
+		t.Fatalf("Delete of deleted file returns '%v', expected permanent status error", err)
+	}
+}
+
+func TestWrongBackup(t *testing.T) {
+	client, closeServer := newTestClient(t, NewMockSworker("{\"address\":\"other\"}"))
+	defer closeServer()
+
+	err := client.Confirm("hash")
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != 401 {
+		t.Fatalf("Request with wrong backup returns '%v', expected 401", err)
+	}
+
+	if err = client.Probe(); err != nil {
+		t.Fatalf("Probe failed: %s", err)
+	}
+}
+
+func TestSworkerUpdatesTooSlow(t *testing.T) {
+	mock := NewMockSworker(testBackup)
+	client, closeServer := newTestClient(t, mock)
+	defer closeServer()
+
+	// Karst waits for at most 'RetryTimes*180' tries while sworker is updating
+	mock.SetUpdating(3*180 + 1)
+	if err := client.Confirm("hash"); err == nil {
+		t.Fatal("Request succeeded while sworker is always updating")
+	}
+
+	mock.SetUpdating(3 * 180)
+	err := client.Confirm("hash")
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != 404 {
+		t.Fatalf("Confirm of unknown file returns '%v', expected 404 after sworker updated", err)
+	}
+}
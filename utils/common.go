@@ -19,7 +19,9 @@ type KarstPaths struct {
 	ConfigFilePath  string
 	UnsealFilesPath string
 	SealFilesPath   string
+	PutFilesPath    string
 	DbPath          string
+	ApiTokenPath    string
 }
 
 func GetKarstPaths() KarstPaths {
@@ -33,7 +35,9 @@ func GetKarstPaths() KarstPaths {
 	karstPaths.ConfigFilePath = filepath.FromSlash(karstPaths.KarstPath + "/config.json")
 	karstPaths.UnsealFilesPath = filepath.FromSlash(karstPaths.KarstPath + "/unseal_files")
 	karstPaths.SealFilesPath = filepath.FromSlash(karstPaths.KarstPath + "/seal_files")
+	karstPaths.PutFilesPath = filepath.FromSlash(karstPaths.KarstPath + "/put_files")
 	karstPaths.DbPath = filepath.FromSlash(karstPaths.KarstPath + "/db")
+	karstPaths.ApiTokenPath = filepath.FromSlash(karstPaths.KarstPath + "/api_token")
 
 	return karstPaths
 }
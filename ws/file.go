@@ -3,20 +3,22 @@ package ws
 import (
 	"fmt"
 	"karst/cache"
-	"karst/chain"
 	"karst/filesystem"
 	"karst/logger"
 	"karst/loop"
+	"karst/merkletree"
 	"karst/model"
-	"karst/sworker"
 	"karst/utils"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/gorilla/websocket"
 )
 
+var unsealInfoLock sync.Mutex
+
 // URL: /file/seal
 func fileSeal(w http.ResponseWriter, r *http.Request) {
 	// Upgrade http to ws
@@ -59,8 +61,17 @@ func fileSeal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Signature check
+	if err := checkSignedRequest(model.FileSealSignPath, fileSealMsg.Client, fileSealMsg); err != nil {
+		fileSealReturnMsg.Info = fmt.Sprintf("Invalid signature of seal request from '%s', error is %s", fileSealMsg.Client, err)
+		logger.Error(fileSealReturnMsg.Info)
+		fileSealReturnMsg.Status = 401
+		model.SendTextMessage(c, fileSealReturnMsg)
+		return
+	}
+
 	// Storage order check
-	sOrder, err := chain.GetStorageOrder(cfg, fileSealMsg.StoreOrderHash)
+	sOrder, err := chainClient.GetStorageOrder(fileSealMsg.StoreOrderHash)
 	if err != nil {
 		fileSealReturnMsg.Info = fmt.Sprintf("Error from chain api, order id is '%s', error is %s", fileSealMsg.StoreOrderHash, err)
 		logger.Error(fileSealReturnMsg.Info)
@@ -75,6 +86,13 @@ func fileSeal(w http.ResponseWriter, r *http.Request) {
 		model.SendTextMessage(c, fileSealReturnMsg)
 		return
 	}
+	if sOrder.Client != fileSealMsg.Client {
+		fileSealReturnMsg.Info = fmt.Sprintf("The storage order '%s' doesn't belong to '%s'", fileSealMsg.StoreOrderHash, fileSealMsg.Client)
+		logger.Error(fileSealReturnMsg.Info)
+		fileSealReturnMsg.Status = 403
+		model.SendTextMessage(c, fileSealReturnMsg)
+		return
+	}
 	if sOrder.FileSize != fileSealMsg.MerkleTree.Size {
 		fileSealReturnMsg.Info = fmt.Sprintf("Invalid file size: %d, file_size in order: %d", fileSealMsg.MerkleTree.Size, sOrder.FileSize)
 		logger.Error(fileSealReturnMsg.Info)
@@ -104,8 +122,8 @@ func fileSeal(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Put message into seal loop
-	if !loop.TryEnqueueFileSealJob(*fileSealMsg) {
-		fileSealReturnMsg.Info = "The seal queue is full or the seal loop doesn't start."
+	if err := loop.TryEnqueueFileSealJob(*fileSealMsg); err != nil {
+		fileSealReturnMsg.Info = fmt.Sprintf("Put file seal job into seal loop failed, error is %s", err)
 		logger.Error(fileSealReturnMsg.Info)
 		fileSealReturnMsg.Status = 500
 		model.SendTextMessage(c, fileSealReturnMsg)
@@ -122,6 +140,104 @@ func fileSeal(w http.ResponseWriter, r *http.Request) {
 	model.SendTextMessage(c, fileSealReturnMsg)
 }
 
+// URL: /file/seal/cancel
+func fileSealCancel(w http.ResponseWriter, r *http.Request) {
+	// Upgrade http to ws
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("Upgrade: %s", err)
+		return
+	}
+	defer c.Close()
+
+	fileSealCancelReturnMsg := model.FileSealCancelReturnMessage{
+		Status: 200,
+	}
+
+	// Check file seal cancel message
+	mt, message, err := c.ReadMessage()
+	if err != nil {
+		logger.Error("Read err: %s", err)
+		fileSealCancelReturnMsg.Info = err.Error()
+		fileSealCancelReturnMsg.Status = 500
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+	logger.Debug("Recv file seal cancel message: %s, message type is %d", message, mt)
+
+	if mt != websocket.TextMessage {
+		fileSealCancelReturnMsg.Info = fmt.Sprintf("Wrong message type is %d", mt)
+		logger.Error(fileSealCancelReturnMsg.Info)
+		fileSealCancelReturnMsg.Status = 400
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+
+	fileSealCancelMsg, err := model.NewFileSealCancelMessage(message)
+	if err != nil {
+		fileSealCancelReturnMsg.Info = fmt.Sprintf("Create file seal cancel message, error is %s", err)
+		logger.Error(fileSealCancelReturnMsg.Info)
+		fileSealCancelReturnMsg.Status = 500
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+
+	if fileSealCancelMsg.Client == "" {
+		fileSealCancelReturnMsg.Info = "The field 'client' is needed"
+		logger.Error(fileSealCancelReturnMsg.Info)
+		fileSealCancelReturnMsg.Status = 400
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+
+	// Signature check
+	if err := checkSignedRequest(model.FileSealCancelSignPath, fileSealCancelMsg.Client, fileSealCancelMsg); err != nil {
+		fileSealCancelReturnMsg.Info = fmt.Sprintf("Invalid signature of seal cancel request from '%s', error is %s", fileSealCancelMsg.Client, err)
+		logger.Error(fileSealCancelReturnMsg.Info)
+		fileSealCancelReturnMsg.Status = 401
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+
+	// Storage order check, the original file of client is deleted by canceling
+	sOrder, err := chainClient.GetStorageOrder(fileSealCancelMsg.StoreOrderHash)
+	if err != nil {
+		fileSealCancelReturnMsg.Info = fmt.Sprintf("Error from chain api, order id is '%s', error is %s", fileSealCancelMsg.StoreOrderHash, err)
+		logger.Error(fileSealCancelReturnMsg.Info)
+		fileSealCancelReturnMsg.Status = 400
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+	if sOrder.Merchant != cfg.Crust.Address {
+		fileSealCancelReturnMsg.Info = fmt.Sprintf("Invalid order id: %s", fileSealCancelMsg.StoreOrderHash)
+		logger.Error(fileSealCancelReturnMsg.Info)
+		fileSealCancelReturnMsg.Status = 400
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+	if sOrder.Client != fileSealCancelMsg.Client {
+		fileSealCancelReturnMsg.Info = fmt.Sprintf("The storage order '%s' doesn't belong to '%s'", fileSealCancelMsg.StoreOrderHash, fileSealCancelMsg.Client)
+		logger.Error(fileSealCancelReturnMsg.Info)
+		fileSealCancelReturnMsg.Status = 403
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+
+	// Only the owner of the job can cancel it
+	status, err := loop.CancelFileSealJob(fileSealCancelMsg.StoreOrderHash, fileSealCancelMsg.Client, fs)
+	if err != nil {
+		fileSealCancelReturnMsg.Info = fmt.Sprintf("Cancel seal job '%s' failed, error is %s", fileSealCancelMsg.StoreOrderHash, err)
+		logger.Error(fileSealCancelReturnMsg.Info)
+		fileSealCancelReturnMsg.Status = status
+		model.SendTextMessage(c, fileSealCancelReturnMsg)
+		return
+	}
+
+	fileSealCancelReturnMsg.Info = fmt.Sprintf("The seal job '%s' has been canceled, the running job will stop at the next stage", fileSealCancelMsg.StoreOrderHash)
+	logger.Info(fileSealCancelReturnMsg.Info)
+	model.SendTextMessage(c, fileSealCancelReturnMsg)
+}
+
 // URL: /file/unseal
 func fileUnseal(w http.ResponseWriter, r *http.Request) {
 	// Upgrade http to ws
@@ -164,6 +280,32 @@ func fileUnseal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Signature check
+	if err := checkSignedRequest(model.FileUnsealSignPath, fileUnsealMsg.Client, fileUnsealMsg); err != nil {
+		fileUnsealReturnMsg.Info = fmt.Sprintf("Invalid signature of unseal request from '%s', error is %s", fileUnsealMsg.Client, err)
+		logger.Error(fileUnsealReturnMsg.Info)
+		fileUnsealReturnMsg.Status = 401
+		model.SendTextMessage(c, fileUnsealReturnMsg)
+		return
+	}
+
+	// Only the client of storage order can do it
+	isOwner, err := isStorageOrderOwner(fileUnsealMsg.FileHash, fileUnsealMsg.Client)
+	if err != nil {
+		fileUnsealReturnMsg.Info = fmt.Sprintf("Error from chain api, file is '%s', error is %s", fileUnsealMsg.FileHash, err)
+		logger.Error(fileUnsealReturnMsg.Info)
+		fileUnsealReturnMsg.Status = 500
+		model.SendTextMessage(c, fileUnsealReturnMsg)
+		return
+	}
+	if !isOwner {
+		fileUnsealReturnMsg.Info = fmt.Sprintf("'%s' has no storage order of '%s'", fileUnsealMsg.Client, fileUnsealMsg.FileHash)
+		logger.Error(fileUnsealReturnMsg.Info)
+		fileUnsealReturnMsg.Status = 403
+		model.SendTextMessage(c, fileUnsealReturnMsg)
+		return
+	}
+
 	// Check if the file has been stored locally
 	if ok, _ := db.Has([]byte(model.FileFlagInDb+fileUnsealMsg.FileHash), nil); !ok {
 		fileUnsealReturnMsg.Info = fmt.Sprintf("Can't find this file '%s' in merchant db", fileUnsealMsg.FileHash)
@@ -184,7 +326,7 @@ func fileUnseal(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Lock cache
-	if err := cache.WaitLock(fileInfo.MerkleTreeSealed.Size); err != nil {
+	if err := cache.WaitLock(serverCtx, fileInfo.MerkleTreeSealed.Size); err != nil {
 		fileUnsealReturnMsg.Info = fmt.Sprintf("Please check the free space in the %s directory, the file cannot be processed, the file size is %d, error is: %s", cfg.KarstPaths.KarstPath, fileInfo.MerkleTreeSealed.Size, err)
 		logger.Error(fileUnsealReturnMsg.Info)
 		fileUnsealReturnMsg.Status = 500
@@ -227,7 +369,7 @@ func fileUnseal(w http.ResponseWriter, r *http.Request) {
 
 	// TODO: Caching mechanism
 	// Unseal file
-	originalPath, err := sworker.Unseal(cfg, fileInfo.SealedPath)
+	originalPath, err := sworkerClient.Unseal(fileInfo.SealedPath)
 	if err != nil {
 		fileUnsealReturnMsg.Info = fmt.Sprintf("Fatal error in unsealing file '%s' : %s", fileInfo.MerkleTreeSealed.Hash, err)
 		logger.Error(fileUnsealReturnMsg.Info)
@@ -247,6 +389,25 @@ func fileUnseal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Finish of the client deletes the unsealed parts
+	if err = saveUnsealedFile(fileUnsealMsg.Client, fileInfo.MerkleTree); err != nil {
+		_ = fileInfo.DeleteOriginalFileFromFs(fs)
+		fileUnsealReturnMsg.Info = fmt.Sprintf("Fatal error in saving unseal info of '%s' for '%s': %s", fileInfo.MerkleTree.Hash, fileUnsealMsg.Client, err)
+		logger.Error(fileUnsealReturnMsg.Info)
+		fileUnsealReturnMsg.Status = 500
+		model.SendTextMessage(c, fileUnsealReturnMsg)
+		return
+	}
+
+	// The unsealed parts are got by the client from local fs
+	if err = model.GrantLocalFsKeys(fileUnsealMsg.Client, fileInfo.MerkleTree.StoredKeys(), db); err != nil {
+		fileUnsealReturnMsg.Info = fmt.Sprintf("Fatal error in granting file '%s' to '%s': %s", fileInfo.MerkleTree.Hash, fileUnsealMsg.Client, err)
+		logger.Error(fileUnsealReturnMsg.Info)
+		fileUnsealReturnMsg.Status = 500
+		model.SendTextMessage(c, fileUnsealReturnMsg)
+		return
+	}
+
 	fileUnsealReturnMsg.MerkleTree = fileInfo.MerkleTree
 	model.SendTextMessage(c, fileUnsealReturnMsg)
 }
@@ -293,6 +454,15 @@ func fileFinish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Signature check
+	if err := checkSignedRequest(model.FileFinishSignPath, fileFinishMsg.Client, fileFinishMsg); err != nil {
+		fileFinishReturnMsg.Info = fmt.Sprintf("Invalid signature of finish request from '%s', error is %s", fileFinishMsg.Client, err)
+		logger.Error(fileFinishReturnMsg.Info)
+		fileFinishReturnMsg.Status = 401
+		model.SendTextMessage(c, fileFinishReturnMsg)
+		return
+	}
+
 	// Check file exist
 	if ok, _ := db.Has([]byte(model.FileFlagInDb+fileFinishMsg.MerkleTree.Hash), nil); !ok {
 		fileFinishReturnMsg.Info = fmt.Sprintf("Can't find this file '%s' in merchant db", fileFinishMsg.MerkleTree.Hash)
@@ -311,9 +481,38 @@ func fileFinish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete file from fs
-	err = filesystem.DeleteMerkletreeFile(fs, fileFinishMsg.MerkleTree)
+	// Only the client of storage order can do it
+	isOwner, err := isStorageOrderOwner(fileFinishMsg.MerkleTree.Hash, fileFinishMsg.Client)
+	if err != nil {
+		fileFinishReturnMsg.Info = fmt.Sprintf("Error from chain api, file is '%s', error is %s", fileFinishMsg.MerkleTree.Hash, err)
+		logger.Error(fileFinishReturnMsg.Info)
+		fileFinishReturnMsg.Status = 500
+		model.SendTextMessage(c, fileFinishReturnMsg)
+		return
+	}
+	if !isOwner {
+		fileFinishReturnMsg.Info = fmt.Sprintf("'%s' has no storage order of '%s'", fileFinishMsg.Client, fileFinishMsg.MerkleTree.Hash)
+		logger.Error(fileFinishReturnMsg.Info)
+		fileFinishReturnMsg.Status = 403
+		model.SendTextMessage(c, fileFinishReturnMsg)
+		return
+	}
+
+	// The root and shape of sealed tree are returned, so client can audit the file by them later
+	fileInfo, err := model.GetFileInfoFromDb(fileFinishMsg.MerkleTree.Hash, db, model.FileFlagInDb)
+	if err == nil && fileInfo.MerkleTreeSealed == nil {
+		err = fmt.Errorf("The sealed merkle tree isn't recorded")
+	}
 	if err != nil {
+		fileFinishReturnMsg.Info = fmt.Sprintf("Read sealed file info of '%s' failed, error is %s", fileFinishMsg.MerkleTree.Hash, err)
+		logger.Error(fileFinishReturnMsg.Info)
+		fileFinishReturnMsg.Status = 500
+		model.SendTextMessage(c, fileFinishReturnMsg)
+		return
+	}
+
+	// Delete the parts unsealed for the client from fs, the original parts of put have been deleted by the seal job
+	if err = clearUnsealedFile(fileFinishMsg.Client, fileFinishMsg.MerkleTree.Hash); err != nil {
 		fileFinishReturnMsg.Info = fmt.Sprintf("Delete original file '%s', error is %s", fileFinishMsg.MerkleTree.Hash, err)
 		logger.Error(fileFinishReturnMsg.Info)
 		fileFinishReturnMsg.Status = 500
@@ -321,5 +520,110 @@ func fileFinish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fileFinishReturnMsg.SealedHash = fileInfo.MerkleTreeSealed.Hash
+	fileFinishReturnMsg.SealedSize = fileInfo.MerkleTreeSealed.Size
+	fileFinishReturnMsg.SealedLeavesNum = fileInfo.MerkleTreeSealed.LeavesNum()
+	fileFinishReturnMsg.SealedMaxLinksNum = fileInfo.MerkleTreeSealed.MaxLinksNum()
 	model.SendTextMessage(c, fileFinishReturnMsg)
 }
+
+// URL: /file/status
+func fileStatus(w http.ResponseWriter, r *http.Request) {
+	// Upgrade http to ws
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("Upgrade: %s", err)
+		return
+	}
+	defer c.Close()
+
+	fileStatusReturnMsg := model.FileStatusReturnMessage{
+		Status: 200,
+	}
+
+	// Check file status message
+	mt, message, err := c.ReadMessage()
+	if err != nil {
+		logger.Error("Read err: %s", err)
+		fileStatusReturnMsg.Info = err.Error()
+		fileStatusReturnMsg.Status = 500
+		model.SendTextMessage(c, fileStatusReturnMsg)
+		return
+	}
+	logger.Debug("Recv file status message: %s, message type is %d", message, mt)
+
+	if mt != websocket.TextMessage {
+		fileStatusReturnMsg.Info = fmt.Sprintf("Wrong message type is %d", mt)
+		logger.Error(fileStatusReturnMsg.Info)
+		fileStatusReturnMsg.Status = 400
+		model.SendTextMessage(c, fileStatusReturnMsg)
+		return
+	}
+
+	fileStatusMsg, err := model.NewFileStatusMessage(message)
+	if err != nil {
+		fileStatusReturnMsg.Info = fmt.Sprintf("Create file status message, error is %s", err)
+		logger.Error(fileStatusReturnMsg.Info)
+		fileStatusReturnMsg.Status = 500
+		model.SendTextMessage(c, fileStatusReturnMsg)
+		return
+	}
+
+	// Read seal job, the job may be in dead list
+	sealJob, err := model.GetSealJobFromDb(fileStatusMsg.StoreOrderHash, db)
+	if err != nil {
+		sealJob, err = model.GetSealDeadJobFromDb(fileStatusMsg.StoreOrderHash, db)
+	}
+	if err != nil {
+		fileStatusReturnMsg.Info = fmt.Sprintf("Can't find the seal job of '%s' in merchant db", fileStatusMsg.StoreOrderHash)
+		logger.Error(fileStatusReturnMsg.Info)
+		fileStatusReturnMsg.Status = 404
+		model.SendTextMessage(c, fileStatusReturnMsg)
+		return
+	}
+
+	fileStatusReturnMsg.SealJobStatus = sealJob.GetStatus()
+	fileStatusReturnMsg.Info = fmt.Sprintf("The seal job of '%s' is in stage '%s'", fileStatusMsg.StoreOrderHash, sealJob.Stage)
+	model.SendTextMessage(c, fileStatusReturnMsg)
+}
+
+// Each unseal puts another copy of original parts into fs, all of them are recorded until the client finishes the file
+func saveUnsealedFile(client string, mt *merkletree.MerkleTreeNode) error {
+	unsealInfoLock.Lock()
+	defer unsealInfoLock.Unlock()
+
+	unsealInfo, err := model.GetUnsealInfoFromDb(client, mt.Hash, db)
+	if err != nil {
+		unsealInfo = &model.UnsealInfo{
+			Client:   client,
+			FileHash: mt.Hash,
+		}
+	}
+
+	unsealInfo.MerkleTrees = append(unsealInfo.MerkleTrees, mt)
+	return unsealInfo.SaveToDb(db)
+}
+
+// Nothing is deleted if the file isn't unsealed for the client, so parts of other files can't be deleted by finish
+func clearUnsealedFile(client string, fileHash string) error {
+	unsealInfoLock.Lock()
+	defer unsealInfoLock.Unlock()
+
+	unsealInfo, err := model.GetUnsealInfoFromDb(client, fileHash, db)
+	if err != nil {
+		return nil
+	}
+
+	for i, mt := range unsealInfo.MerkleTrees {
+		if err = filesystem.DeleteMerkletreeFile(fs, mt); err != nil {
+			// The copy which is deleted partly is dropped, the rest are kept for the next finish
+			unsealInfo.MerkleTrees = unsealInfo.MerkleTrees[i+1:]
+			_ = unsealInfo.SaveToDb(db)
+			return err
+		}
+		model.RevokeLocalFsKeys(client, mt.StoredKeys(), db)
+	}
+
+	unsealInfo.ClearDb(db)
+	return nil
+}
@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"fmt"
+	"io"
+	"karst/filesystem"
+	"karst/logger"
+	"karst/model"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	localFsPath            = "/api/v0/fs/local"
+	localFsMaxPutSize      = 64 * (1 << 20) // 64 MB
+	localFsUploadSizeCycle = 24 * time.Hour
+)
+
+// Serve local or s3 fs for clients, parts can be put and got by their keys, deletion isn't allowed.
+// Requests must be signed by clients, each client can put 'clientDailyUploadSize' bytes in a day at most
+// and can only get the parts put by itself or unsealed for it
+type localFsHandler struct {
+	servedFs              filesystem.FsInterface
+	db                    *leveldb.DB
+	clientDailyUploadSize uint64
+	uploadSizes           map[string]*localFsUploadSize
+	uploadSizesLock       sync.Mutex
+}
+
+type localFsUploadSize struct {
+	cycleStart time.Time
+	size       uint64
+}
+
+func newLocalFsHandler(servedFs filesystem.FsInterface, db *leveldb.DB, clientDailyUploadSize uint64) *localFsHandler {
+	return &localFsHandler{
+		servedFs:              servedFs,
+		db:                    db,
+		clientDailyUploadSize: clientDailyUploadSize,
+		uploadSizes:           make(map[string]*localFsUploadSize),
+	}
+}
+
+// URL: /fs/local
+func (handler *localFsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	localFsReq, err := model.NewLocalFsRequest(r.Header.Get(model.LocalFsRequestHeader))
+	if err == nil {
+		err = checkSignedRequest(model.LocalFsSignPath, localFsReq.Client, localFsReq)
+	}
+	if err == nil && localFsReq.Method != r.Method {
+		err = fmt.Errorf("The request is signed for '%s'", localFsReq.Method)
+	}
+	if err != nil {
+		logger.Error("(LocalFs) Invalid signed request: %s", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		key := query.Get("key")
+		if key != localFsReq.Key {
+			http.Error(w, fmt.Sprintf("The request is signed for part '%s'", localFsReq.Key), http.StatusUnauthorized)
+			return
+		}
+
+		if !model.IsLocalFsKeyGranted(localFsReq.Client, key, handler.db) {
+			logger.Error("(LocalFs) Part '%s' isn't granted to '%s'", key, localFsReq.Client)
+			http.Error(w, "Part isn't granted to the client", http.StatusForbidden)
+			return
+		}
+
+		offset, length, err := getLocalFsRange(query.Get("offset"), query.Get("length"))
+		if err != nil {
+			logger.Error("(LocalFs) Wrong range of part '%s': %s", key, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		partReader, err := handler.servedFs.GetRangeReader(key, offset, length)
+		if err != nil {
+			logger.Error("(LocalFs) Open part '%s' failed: %s", key, err)
+			if os.IsNotExist(err) {
+				http.Error(w, "Part doesn't exist", http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		defer partReader.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if _, err = io.Copy(w, partReader); err != nil {
+			logger.Error("(LocalFs) Write err: %s", err)
+		}
+	case http.MethodPost:
+		// The size is needed for streaming the part into fs
+		if r.ContentLength < 0 {
+			http.Error(w, "Content length is required", http.StatusLengthRequired)
+			return
+		}
+
+		if r.ContentLength > localFsMaxPutSize {
+			http.Error(w, "Part is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		size := uint64(r.ContentLength)
+		if size != localFsReq.Size {
+			http.Error(w, fmt.Sprintf("The request is signed for %d bytes", localFsReq.Size), http.StatusUnauthorized)
+			return
+		}
+
+		if err := handler.reserveUploadSize(localFsReq.Client, size); err != nil {
+			logger.Error("(LocalFs) Reject part from '%s': %s", localFsReq.Client, err)
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+
+		key, err := handler.servedFs.PutReader(r.Body, size)
+		if err != nil {
+			handler.releaseUploadSize(localFsReq.Client, size)
+			logger.Error("(LocalFs) Put part failed: %s", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err = model.GrantLocalFsKeys(localFsReq.Client, []string{key}, handler.db); err != nil {
+			logger.Error("(LocalFs) Grant part '%s' to '%s' failed: %s", key, localFsReq.Client, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logger.Debug("(LocalFs) Put part '%s' from '%s'", key, localFsReq.Client)
+		_, _ = w.Write([]byte(key))
+	}
+}
+
+// The size is counted before putting, so that parallel uploads can't exceed the limit
+func (handler *localFsHandler) reserveUploadSize(client string, size uint64) error {
+	handler.uploadSizesLock.Lock()
+	defer handler.uploadSizesLock.Unlock()
+
+	now := time.Now()
+	for uploadClient, uploadSize := range handler.uploadSizes {
+		if now.Sub(uploadSize.cycleStart) >= localFsUploadSizeCycle {
+			delete(handler.uploadSizes, uploadClient)
+		}
+	}
+
+	uploadSize, ok := handler.uploadSizes[client]
+	if !ok {
+		uploadSize = &localFsUploadSize{cycleStart: now}
+		handler.uploadSizes[client] = uploadSize
+	}
+
+	if uploadSize.size+size > handler.clientDailyUploadSize {
+		return fmt.Errorf("Upload size of '%s' exceeds %d bytes in %s", client, handler.clientDailyUploadSize, localFsUploadSizeCycle)
+	}
+	uploadSize.size = uploadSize.size + size
+	return nil
+}
+
+func (handler *localFsHandler) releaseUploadSize(client string, size uint64) {
+	handler.uploadSizesLock.Lock()
+	defer handler.uploadSizesLock.Unlock()
+	if uploadSize, ok := handler.uploadSizes[client]; ok && uploadSize.size >= size {
+		uploadSize.size = uploadSize.size - size
+	}
+}
+
+func getLocalFsRange(offsetStr string, lengthStr string) (uint64, uint64, error) {
+	var offset, length uint64 = 0, 0
+	var err error
+	if offsetStr != "" {
+		if offset, err = strconv.ParseUint(offsetStr, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	if lengthStr != "" {
+		if length, err = strconv.ParseUint(lengthStr, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	return offset, length, nil
+}
@@ -0,0 +1,148 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"karst/logger"
+	"net/http"
+	"time"
+)
+
+const (
+	healthzPath             = "/healthz"
+	readyzPath              = "/readyz"
+	healthCheckTimeout      = 10 * time.Second
+	healthStatusOk          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+type healthCheckResult struct {
+	Status string `json:"status"`
+	Info   string `json:"info,omitempty"`
+}
+
+type healthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]healthCheckResult `json:"checks"`
+}
+
+type healthCheck struct {
+	name  string
+	check func() error
+	// Karst should be restarted if a liveness check fails, the other checks only drain karst
+	liveness bool
+}
+
+// Sworker and fs are checked only in merchant model
+func getHealthChecks() []healthCheck {
+	checks := []healthCheck{
+		{name: "chain", check: checkChain},
+		{name: "leveldb", check: checkLeveldb, liveness: true},
+	}
+
+	if sworkerClient != nil {
+		checks = append(checks, healthCheck{name: "sworker", check: sworkerClient.Probe})
+	}
+
+	if fs != nil {
+		checks = append(checks, healthCheck{name: "fs", check: fs.Check})
+	}
+
+	return checks
+}
+
+func checkChain() error {
+	if !chainClient.IsReady() {
+		return fmt.Errorf("Chain can't be reached or is synchronizing")
+	}
+	return nil
+}
+
+func checkLeveldb() error {
+	_, err := db.GetProperty("leveldb.stats")
+	return err
+}
+
+// URL: /healthz, it is unavailable only if karst can't work without restart
+func healthz(w http.ResponseWriter, r *http.Request) {
+	serveHealthReport(w, r, true)
+}
+
+// URL: /readyz, it is unavailable if any dependency can't be used, the merchant should be drained
+func readyz(w http.ResponseWriter, r *http.Request) {
+	serveHealthReport(w, r, false)
+}
+
+func serveHealthReport(w http.ResponseWriter, r *http.Request, livenessOnly bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := runHealthChecks(getHealthChecks(), livenessOnly)
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		logger.Error("(Health) Marshal health report failed: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == healthStatusOk {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(reportBytes)
+}
+
+// Checks run concurrently, the check which doesn't finish in timeout is treated as failed
+func runHealthChecks(checks []healthCheck, livenessOnly bool) healthReport {
+	type checkDone struct {
+		index int
+		err   error
+	}
+
+	dones := make(chan checkDone, len(checks))
+	for i := range checks {
+		go func(index int) {
+			dones <- checkDone{index: index, err: checks[index].check()}
+		}(i)
+	}
+
+	errs := make([]error, len(checks))
+	for i := range errs {
+		errs[i] = fmt.Errorf("Check timeout after %s", healthCheckTimeout)
+	}
+
+	timer := time.NewTimer(healthCheckTimeout)
+	defer timer.Stop()
+wait:
+	for doneNum := 0; doneNum < len(checks); doneNum++ {
+		select {
+		case done := <-dones:
+			errs[done.index] = done.err
+		case <-timer.C:
+			break wait
+		}
+	}
+
+	report := healthReport{
+		Status: healthStatusOk,
+		Checks: make(map[string]healthCheckResult),
+	}
+	for i, check := range checks {
+		if errs[i] == nil {
+			report.Checks[check.name] = healthCheckResult{Status: healthStatusOk}
+			continue
+		}
+
+		logger.Warn("(Health) Check of %s failed: %s", check.name, errs[i])
+		report.Checks[check.name] = healthCheckResult{Status: healthStatusUnavailable, Info: errs[i].Error()}
+		if check.liveness || !livenessOnly {
+			report.Status = healthStatusUnavailable
+		}
+	}
+
+	return report
+}
@@ -3,7 +3,11 @@ package ws
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"karst/config"
 	"karst/logger"
+	"karst/loop"
+	"karst/metrics"
 	"karst/model"
 	"net/http"
 
@@ -47,19 +51,67 @@ func nodeData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the file being audited can be read without backup
+	auditFileHash := ""
 	if backupMes.Backup != cfg.Crust.Backup {
-		logger.Error("(NodeData) Need right backup")
-		err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 400 }"))
+		var nodeAuditMsg model.NodeAuditMessage
+		_ = json.Unmarshal([]byte(message), &nodeAuditMsg)
+		if nodeAuditMsg.FileHash == "" || fs == nil {
+			logger.Error("(NodeData) Need right backup")
+			err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 400 }"))
+			if err != nil {
+				logger.Error("(NodeData) Write err: %s", err)
+			}
+			return
+		}
+
+		nodeAuditReturnMsg := model.NodeAuditReturnMessage{
+			Status: 200,
+		}
+
+		// Only the client of storage order can audit the file
+		if err := checkSignedRequest(model.NodeAuditSignPath, nodeAuditMsg.Client, &nodeAuditMsg); err != nil {
+			nodeAuditReturnMsg.Info = fmt.Sprintf("Invalid signature of audit request from '%s', error is %s", nodeAuditMsg.Client, err)
+			logger.Error("(NodeData) %s", nodeAuditReturnMsg.Info)
+			nodeAuditReturnMsg.Status = 401
+			model.SendTextMessage(c, nodeAuditReturnMsg)
+			return
+		}
+
+		isOwner, err := isStorageOrderOwner(nodeAuditMsg.FileHash, nodeAuditMsg.Client)
 		if err != nil {
-			logger.Error("(NodeData) Write err: %s", err)
+			nodeAuditReturnMsg.Info = fmt.Sprintf("Error from chain api, file is '%s', error is %s", nodeAuditMsg.FileHash, err)
+			logger.Error("(NodeData) %s", nodeAuditReturnMsg.Info)
+			nodeAuditReturnMsg.Status = 500
+			model.SendTextMessage(c, nodeAuditReturnMsg)
+			return
+		}
+		if !isOwner {
+			nodeAuditReturnMsg.Info = fmt.Sprintf("'%s' has no storage order of '%s'", nodeAuditMsg.Client, nodeAuditMsg.FileHash)
+			logger.Error("(NodeData) %s", nodeAuditReturnMsg.Info)
+			nodeAuditReturnMsg.Status = 403
+			model.SendTextMessage(c, nodeAuditReturnMsg)
+			return
+		}
+		fileInfo, err := model.GetFileInfoFromDb(nodeAuditMsg.FileHash, db, model.FileFlagInDb)
+		if err != nil {
+			nodeAuditReturnMsg.Info = fmt.Sprintf("Read file info of '%s' failed: %s", nodeAuditMsg.FileHash, err)
+			logger.Error("(NodeData) %s", nodeAuditReturnMsg.Info)
+			nodeAuditReturnMsg.Status = 404
+			model.SendTextMessage(c, nodeAuditReturnMsg)
+			return
 		}
-		return
-	}
 
-	// Send right backup message
-	err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 200 }"))
-	if err != nil {
-		logger.Error("(NodeData) Write err: %s", err)
+		logger.Info("(NodeData) Client '%s' is auditing file '%s'", nodeAuditMsg.Client, nodeAuditMsg.FileHash)
+		auditFileHash = fileInfo.MerkleTreeSealed.Hash
+		nodeAuditReturnMsg.SealedHash = auditFileHash
+		model.SendTextMessage(c, nodeAuditReturnMsg)
+	} else {
+		// Send right backup message
+		err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 200 }"))
+		if err != nil {
+			logger.Error("(NodeData) Write err: %s", err)
+		}
 	}
 
 	// Get and send node data
@@ -86,6 +138,15 @@ func nodeData(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if auditFileHash != "" && nodeDataMsg.FileHash != auditFileHash {
+			logger.Error("(NodeData) Bad request, only the audited file '%s' can be read", auditFileHash)
+			err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 400 }"))
+			if err != nil {
+				logger.Error("(NodeData) Write err: %s", err)
+			}
+			continue
+		}
+
 		// Get node of file
 		if fs == nil {
 			err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 404 }"))
@@ -105,20 +166,19 @@ func nodeData(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		if nodeDataMsg.NodeIndex > fileInfo.MerkleTreeSealed.LinksNum-1 {
-			logger.Error("(NodeData) Bad request, node index is out of range")
+		nodeInfo, err := fileInfo.MerkleTreeSealed.GetLeaf(nodeDataMsg.NodeIndex)
+		if err != nil {
+			logger.Error("(NodeData) Bad request, %s", err)
 			err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 400 }"))
 			if err != nil {
 				logger.Error("(NodeData) Write err: %s", err)
 			}
 			continue
 		}
-
-		nodeInfo := fileInfo.MerkleTreeSealed.Links[nodeDataMsg.NodeIndex]
 		// nodeInfoBytes, _ := json.Marshal(nodeInfo)
 		// logger.Debug("(NodeData) Node info in db: %s", string(nodeInfoBytes))
 
-		if nodeInfo.Hash != nodeDataMsg.NodeHash {
+		if nodeDataMsg.NodeHash != "" && nodeInfo.Hash != nodeDataMsg.NodeHash {
 			logger.Error("(NodeData) Bad request, request node hash is '%s', db node hash is '%s'", nodeDataMsg.NodeHash, nodeInfo.Hash)
 			err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 400 }"))
 			if err != nil {
@@ -127,7 +187,29 @@ func nodeData(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		fileBytes, err := fs.GetToBuffer(nodeInfo.StoredKey, nodeInfo.Size)
+		// The proof is generated from the sealed tree in db, so the node data must match the sealed root to pass audit
+		if nodeDataMsg.WithProof {
+			nodeProofMsg := model.NodeProofMessage{
+				Status: 200,
+			}
+			if nodeProofMsg.Proof, err = fileInfo.MerkleTreeSealed.GenerateProof(nodeDataMsg.NodeIndex); err != nil {
+				nodeProofMsg.Info = err.Error()
+				nodeProofMsg.Status = 500
+				logger.Error("(NodeData) Generate proof of node %d in '%s' failed: %s", nodeDataMsg.NodeIndex, nodeDataMsg.FileHash, err)
+				model.SendTextMessage(c, nodeProofMsg)
+				continue
+			}
+			model.SendTextMessage(c, nodeProofMsg)
+		} else if nodeDataMsg.NodeHash == "" {
+			logger.Error("(NodeData) Bad request, the node hash is needed without proof")
+			err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 400 }"))
+			if err != nil {
+				logger.Error("(NodeData) Write err: %s", err)
+			}
+			continue
+		}
+
+		partReader, err := fs.GetReader(nodeInfo.StoredKey)
 		if err != nil {
 			logger.Error("(NodeData) Read file '%s' failed: %s", nodeInfo.Hash, err)
 			err = c.WriteMessage(websocket.TextMessage, []byte("{ \"status\": 404 }"))
@@ -137,12 +219,35 @@ func nodeData(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		err = c.WriteMessage(websocket.BinaryMessage, fileBytes)
+		// Stream the part into binary message, the connection is closed if the part breaks halfway
+		err = writePartMessage(c, partReader, nodeInfo.Size)
+		partReader.Close()
 		if err != nil {
 			logger.Error("(NodeData) Write err: %s", err)
 			return
 		}
+		metrics.NodeDataParts.Inc()
+		metrics.NodeDataBytes.Add(float64(nodeInfo.Size))
+	}
+}
+
+func writePartMessage(c *websocket.Conn, partReader io.Reader, size uint64) error {
+	msgWriter, err := c.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+
+	// The writer isn't closed on errors, closing completes the frame and the peer would get a truncated part,
+	// the caller closes the connection instead
+	n, err := io.Copy(msgWriter, partReader)
+	if err != nil {
+		return err
+	}
+
+	if uint64(n) != size {
+		return fmt.Errorf("The size of part is %d, not %d", n, size)
 	}
+	return msgWriter.Close()
 }
 
 // URL: /node/info
@@ -180,6 +285,13 @@ func nodeInfo(w http.ResponseWriter, r *http.Request) {
 	if string(message) == "address" {
 		nodeInfoReturnMsg.FastdfsAddress = cfg.Fs.Fastdfs.OuterTrackerAddrs
 		nodeInfoReturnMsg.IpfsAddress = cfg.Fs.Ipfs.OuterBaseUrl
+		if cfg.Fs.FsFlag == config.LOCAL_FLAG || cfg.Fs.FsFlag == config.S3_FLAG {
+			// The path means that the fs is served by karst itself
+			nodeInfoReturnMsg.LocalAddress = cfg.Fs.Local.OuterBaseUrl
+			if nodeInfoReturnMsg.LocalAddress == "" {
+				nodeInfoReturnMsg.LocalAddress = localFsPath
+			}
+		}
 		model.SendTextMessage(c, nodeInfoReturnMsg)
 	} else if string(message) == "storage" {
 		nodeInfoReturnMsg.StorageStatus, err = model.GetStorageStatus(db)
@@ -191,6 +303,9 @@ func nodeInfo(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		model.SendTextMessage(c, nodeInfoReturnMsg)
+	} else if string(message) == "queue" {
+		nodeInfoReturnMsg.SealQueue = loop.GetFileSealQueueStatus()
+		model.SendTextMessage(c, nodeInfoReturnMsg)
 	} else {
 		nodeInfoReturnMsg.Info = fmt.Sprintf("Not support this request: %s", string(message))
 		nodeInfoReturnMsg.Status = 400
@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"fmt"
+	"karst/model"
+	"sync"
+	"time"
+)
+
+// Nonces of signed requests are kept until their requests are out of valid duration, then the timestamp rejects them
+var usedNonces = make(map[string]time.Time)
+var usedNoncesLock sync.Mutex
+
+// Verify the signature of request made by the client and reject the replayed one
+func checkSignedRequest(path string, client string, req model.SignedRequest) error {
+	if err := model.VerifyRequest(path, client, req); err != nil {
+		return err
+	}
+
+	reqSig := req.GetRequestSignature()
+	nonceKey := client + "/" + reqSig.Nonce
+	now := time.Now()
+
+	usedNoncesLock.Lock()
+	defer usedNoncesLock.Unlock()
+	for key, expireTime := range usedNonces {
+		if now.After(expireTime) {
+			delete(usedNonces, key)
+		}
+	}
+
+	if _, ok := usedNonces[nonceKey]; ok {
+		return fmt.Errorf("The nonce '%s' has been used", reqSig.Nonce)
+	}
+	usedNonces[nonceKey] = time.Unix(reqSig.Timestamp, 0).Add(model.RequestValidDuration)
+	return nil
+}
+
+// Check if the client has a storage order of the file on this merchant
+func isStorageOrderOwner(fileHash string, client string) (bool, error) {
+	fileMap, err := chainClient.GetMerchantFileMap(cfg.Crust.Address)
+	if err != nil {
+		return false, err
+	}
+
+	for _, orderId := range fileMap["0x"+fileHash] {
+		sOrder, err := chainClient.GetStorageOrder(orderId)
+		if err != nil {
+			return false, err
+		}
+		if sOrder.Client == client && sOrder.Merchant == cfg.Crust.Address {
+			return true, nil
+		}
+	}
+	return false, nil
+}
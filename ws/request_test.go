@@ -0,0 +1,40 @@
+package ws
+
+import (
+	"karst/account"
+	"karst/model"
+	"testing"
+)
+
+// Exported by polkadot-js, the password is '123456'
+const (
+	testAddress  = "5FqazaU79hjpEMiWTWZx81VjsYFst15eBuSBKdQLgQibD7CX"
+	testPassword = "123456"
+	testBackup   = `{"address":"5FqazaU79hjpEMiWTWZx81VjsYFst15eBuSBKdQLgQibD7CX","encoded":"0xc81537c9442bd1d3f4985531293d88f6d2a960969a88b1cf8413e7c9ec1d5f4955adf91d2d687d8493b70ef457532d505b9cee7a3d2b726a554242b75fb9bec7d4beab74da4bf65260e1d6f7a6b44af4505bf35aaae4cf95b1059ba0f03f1d63c5b7c3ccbacd6bd80577de71f35d0c4976b6e43fe0e1583530e773dfab3ab46c92ce3fa2168673ba52678407a3ef619b5e14155706d43bd329a5e72d36","encoding":{"content":["pkcs8","sr25519"],"type":"xsalsa20-poly1305","version":"2"},"meta":{"name":"Yang1","tags":[],"whenCreated":1580628430860}}`
+)
+
+func TestCheckSignedRequestRejectsReplay(t *testing.T) {
+	keypair, err := account.LoadKeypair(testBackup, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := model.FileSealCancelMessage{Client: testAddress, StoreOrderHash: "0x01"}
+	if err = model.SignRequest(model.FileSealCancelSignPath, &req, keypair); err != nil {
+		t.Fatal(err)
+	}
+	if err = checkSignedRequest(model.FileSealCancelSignPath, testAddress, &req); err != nil {
+		t.Fatalf("Check signed request failed: %s", err)
+	}
+	if err = checkSignedRequest(model.FileSealCancelSignPath, testAddress, &req); err == nil {
+		t.Fatal("The replayed request is accepted")
+	}
+
+	// A new nonce is used for each signing
+	if err = model.SignRequest(model.FileSealCancelSignPath, &req, keypair); err != nil {
+		t.Fatal(err)
+	}
+	if err = checkSignedRequest(model.FileSealCancelSignPath, testAddress, &req); err != nil {
+		t.Fatalf("Check signed request with new nonce failed: %s", err)
+	}
+}
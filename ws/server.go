@@ -1,41 +1,147 @@
 package ws
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"sync"
+	"time"
 
+	"karst/chain"
 	"karst/config"
 	"karst/filesystem"
+	"karst/logger"
+	"karst/metrics"
+	"karst/sworker"
 
 	"github.com/gorilla/websocket"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
+const (
+	serverShutdownTimeout = 30 * time.Second
+)
+
 var cfg *config.Configuration = nil
 var fs filesystem.FsInterface = nil
 var db *leveldb.DB = nil
+var chainClient chain.Client = nil
+var sworkerClient sworker.Client = nil
+var server *http.Server = nil
+var probeServer *http.Server = nil
+var serverStopped = false
+var serverLock sync.Mutex
+
+// Handlers waiting in websocket connections give up when the server is stopping, 'Shutdown' doesn't wait for them
+var serverCtx, serverCancel = context.WithCancel(context.Background())
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
-// TODO: wss is needed
-func StartServer(inConfig *config.Configuration, inFs filesystem.FsInterface, inDb *leveldb.DB) error {
+// Karst serves wss and https if tls is configured
+func StartServer(inConfig *config.Configuration, inFs filesystem.FsInterface, inDb *leveldb.DB, inChain chain.Client, inSworker sworker.Client) error {
 	cfg = inConfig
 	fs = inFs
 	db = inDb
+	chainClient = inChain
+	sworkerClient = inSworker
 
 	if fs != nil {
-		http.HandleFunc("/api/v0/node/data", nodeData)
-		http.HandleFunc("/api/v0/node/info", nodeInfo)
-		http.HandleFunc("/api/v0/file/seal", fileSeal)
-		http.HandleFunc("/api/v0/file/unseal", fileUnseal)
-		http.HandleFunc("/api/v0/file/finish", fileFinish)
+		handleWsFunc("/api/v0/node/data", nodeData)
+		handleWsFunc("/api/v0/node/info", nodeInfo)
+		handleWsFunc("/api/v0/file/seal", fileSeal)
+		handleWsFunc("/api/v0/file/seal/cancel", fileSealCancel)
+		handleWsFunc("/api/v0/file/unseal", fileUnseal)
+		handleWsFunc("/api/v0/file/finish", fileFinish)
+		handleWsFunc("/api/v0/file/status", fileStatus)
+	}
+
+	// Probes can't present client certificates, so they are served on another listener without tls if it is given
+	probeMux := http.DefaultServeMux
+	if cfg.ProbeUrl != "" {
+		probeMux = http.NewServeMux()
+	}
+	probeMux.Handle(metrics.Path, metrics.Handler())
+	probeMux.HandleFunc(healthzPath, healthz)
+	probeMux.HandleFunc(readyzPath, readyz)
+
+	if cfg.Fs.FsFlag == config.LOCAL_FLAG || cfg.Fs.FsFlag == config.S3_FLAG {
+		http.Handle(localFsPath, newLocalFsHandler(fs, db, cfg.Fs.ClientDailyUploadSize))
+	}
+
+	httpServer := &http.Server{Addr: cfg.BaseUrl}
+	if cfg.TLS.IsEnabled() {
+		tlsConfig, err := cfg.TLS.GetServerTLSConfig()
+		if err != nil {
+			return fmt.Errorf("Fatal error in loading tls config: %s", err)
+		}
+		httpServer.TLSConfig = tlsConfig
 	}
 
-	if err := http.ListenAndServe(cfg.BaseUrl, nil); err != nil {
+	// The server may be stopped before it starts
+	serverLock.Lock()
+	if serverStopped {
+		serverLock.Unlock()
+		return nil
+	}
+	server = httpServer
+
+	if cfg.ProbeUrl != "" {
+		probeListener, err := net.Listen("tcp", cfg.ProbeUrl)
+		if err != nil {
+			serverLock.Unlock()
+			return fmt.Errorf("Fatal error in listening probe url: %s", err)
+		}
+
+		probeServer = &http.Server{Handler: probeMux}
+		go func(probeServer *http.Server) {
+			if err := probeServer.Serve(probeListener); err != nil && err != http.ErrServerClosed {
+				logger.Error("Probe server: %s", err)
+			}
+		}(probeServer)
+	}
+	serverLock.Unlock()
+
+	var err error
+	if cfg.TLS.IsEnabled() {
+		// The certificate is in tls config already
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+
+	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
 
 	return nil
 }
+
+// Active connections of websocket apis are counted for prometheus
+func handleWsFunc(path string, handler http.HandlerFunc) {
+	http.HandleFunc(path, metrics.InstrumentWsHandler(path, handler))
+}
+
+// Stop accepting new requests and wait for the running http requests, the server which hasn't started won't start
+func StopServer() error {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	serverStopped = true
+	serverCancel()
+	if server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if probeServer != nil {
+		if probeErr := probeServer.Shutdown(ctx); err == nil {
+			err = probeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package ws
+
+import (
+	"karst/chain"
+	"karst/config"
+	"testing"
+	"time"
+)
+
+func TestStopServerBeforeStart(t *testing.T) {
+	if err := StopServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-serverCtx.Done():
+	default:
+		t.Fatal("Context of server isn't canceled")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- StartServer(&config.Configuration{BaseUrl: "127.0.0.1:0"}, nil, nil, chain.NewFakeChain().NewClient("client"), nil)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start stopped server returns '%s'", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The stopped server is started")
+	}
+}